@@ -0,0 +1,40 @@
+// Command goat-crdgen generates typed kube-goAT builders from a CustomResourceDefinition.
+//
+//	goat-crdgen -crd certificates.yaml -package certs -o certs/certificate.go
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/arpanpathak/kube-goAT/pkg/codegen"
+)
+
+func main() {
+	crdPath := flag.String("crd", "", "path to the CustomResourceDefinition YAML")
+	pkg := flag.String("package", "", "Go package name of the generated file (defaults to the lowercased kind)")
+	version := flag.String("version", "", "CRD version to generate (defaults to the storage version)")
+	out := flag.String("o", "", "output file (defaults to stdout)")
+	flag.Parse()
+
+	if *crdPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	crd, err := os.ReadFile(*crdPath)
+	if err != nil {
+		log.Fatalf("read CRD: %v", err)
+	}
+	src, err := codegen.GenerateCRD(crd, codegen.CRDOptions{Package: *pkg, Version: *version})
+	if err != nil {
+		log.Fatalf("generate: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("write %s: %v", *out, err)
+	}
+}
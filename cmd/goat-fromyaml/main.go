@@ -0,0 +1,51 @@
+// Command goat-fromyaml converts existing Kubernetes manifests into kube-goAT DSL source.
+//
+//	goat-fromyaml -package infra -o infra/graph.go manifests/*.yaml
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/arpanpathak/kube-goAT/pkg/codegen"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+)
+
+func main() {
+	pkg := flag.String("package", "infra", "Go package name of the generated file")
+	fn := flag.String("func", "Graph", "name of the generated function returning the graph")
+	out := flag.String("o", "", "output file (defaults to stdout)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var manifests bytes.Buffer
+	for _, path := range flag.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("read %s: %v", path, err)
+		}
+		manifests.Write(data)
+		manifests.WriteString("\n---\n")
+	}
+
+	graph, err := dsl.FromYAML(&manifests)
+	if err != nil {
+		log.Fatalf("import: %v", err)
+	}
+	src, err := codegen.GenerateGraph(graph.Build(), codegen.GraphOptions{Package: *pkg, Func: *fn})
+	if err != nil {
+		log.Fatalf("generate: %v", err)
+	}
+	if *out == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := os.WriteFile(*out, src, 0644); err != nil {
+		log.Fatalf("write %s: %v", *out, err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/compiler"
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+)
+
+func runPlan(e *env, args []string) error {
+	fs := e.flagSet("plan")
+	e.clusterFlags(fs)
+	e.stateKeyFlag(fs)
+	e.sourceFlags(fs)
+	detailed := fs.Bool("detailed-exitcode", false, "exit with 2 when the plan contains changes")
+	prune := pruneFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	payload, err := e.payload(ctx, fs.Args())
+	if err != nil {
+		return err
+	}
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store, pruneOptions(*prune)...)
+	if err != nil {
+		return err
+	}
+	plan, err := eng.Plan(ctx, payload, e.stateKey)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(e.stdout, plan)
+	if *detailed && plan.HasChanges() {
+		return errChanges
+	}
+	return nil
+}
+
+func runApply(e *env, args []string) error {
+	fs := e.flagSet("apply")
+	e.clusterFlags(fs)
+	e.stateKeyFlag(fs)
+	e.sourceFlags(fs)
+	recreate := fs.Bool("recreate-on-selector-change", false, "delete and recreate Deployments whose selector changed")
+	adopt := fs.Bool("adopt", false, "take over existing objects no kube-goAT stack owns")
+	prune := pruneFlag(fs)
+	e.trustFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	payload, err := e.payload(ctx, fs.Args())
+	if err != nil {
+		return err
+	}
+	opts, err := e.trustOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, pruneOptions(*prune)...)
+	if *recreate {
+		opts = append(opts, engine.WithRecreateOnSelectorChange())
+	}
+	if *adopt {
+		opts = append(opts, engine.WithAdoption())
+	}
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store, opts...)
+	if err != nil {
+		return err
+	}
+	if err := eng.Apply(ctx, payload, e.stateKey); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, "Apply complete.")
+	return nil
+}
+
+// pruneFlag registers -prune, the allowlist of kinds to prune.
+func pruneFlag(fs *flag.FlagSet) *string {
+	return fs.String("prune", "", "comma-separated kinds to prune when owned but missing from graph and state, e.g. Service,Deployment,Widget.example.com")
+}
+
+func pruneOptions(kinds string) []engine.Option {
+	if kinds == "" {
+		return nil
+	}
+	return []engine.Option{engine.WithPrune(strings.Split(kinds, ",")...)}
+}
+
+func runImport(e *env, args []string) error {
+	fs := e.flagSet("import")
+	e.clusterFlags(fs)
+	e.stateKeyFlag(fs)
+	e.sourceFlags(fs)
+	e.trustFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	payload, err := e.payload(ctx, fs.Args())
+	if err != nil {
+		return err
+	}
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	opts, err := e.trustOptions()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store, opts...)
+	if err != nil {
+		return err
+	}
+	imported, err := eng.Import(ctx, payload, e.stateKey)
+	for _, id := range imported {
+		fmt.Fprintf(e.stdout, "Imported %s\n", id)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Import complete: %d object(s) now managed under %q.\n", len(imported), e.stateKey)
+	return nil
+}
+
+func runDestroy(e *env, args []string) error {
+	fs := e.flagSet("destroy")
+	e.clusterFlags(fs)
+	e.stateKeyFlag(fs)
+	approve := fs.Bool("auto-approve", false, "skip the interactive confirmation")
+	timeout := fs.Duration("timeout", engine.DefaultDeleteTimeout, "how long to wait for each object's finalizers")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if !*approve && !confirm(e, fmt.Sprintf("Destroy every resource recorded under %q?", e.stateKey)) {
+		return fmt.Errorf("destroy cancelled")
+	}
+
+	ctx := context.Background()
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store, engine.WithDeleteTimeout(*timeout))
+	if err != nil {
+		return err
+	}
+	if err := eng.Destroy(ctx, e.stateKey); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.stdout, "Destroy complete.")
+	return nil
+}
+
+func runDrift(e *env, args []string) error {
+	fs := e.flagSet("drift")
+	e.clusterFlags(fs)
+	e.stateKeyFlag(fs)
+	format := fs.String("format", "text", `output format: "text" or "json"`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store)
+	if err != nil {
+		return err
+	}
+	report, err := eng.DetectDrift(context.Background(), e.stateKey)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		out, err := report.JSON()
+		if err != nil {
+			return err
+		}
+		e.stdout.Write(out)
+	} else {
+		fmt.Fprint(e.stdout, report)
+	}
+	if report.HasDrift() {
+		return errChanges
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on the command's streams.
+func confirm(e *env, question string) bool {
+	fmt.Fprintf(e.stderr, "%s Only 'yes' will be accepted: ", question)
+	answer, _ := bufio.NewReader(e.stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
+
+var layouts = map[string]compiler.Layout{
+	"single":    compiler.SingleFile,
+	"files":     compiler.FilePerResource,
+	"kustomize": compiler.Kustomize,
+}
+
+func runRender(e *env, args []string) error {
+	fs := e.flagSet("render")
+	e.sourceFlags(fs)
+	format := fs.String("format", "yaml", `output format: "yaml" or "json"`)
+	out := fs.String("o", "", "write files to this directory instead of stdout")
+	layoutName := fs.String("layout", "single", `file layout with -o: "single", "files" or "kustomize"`)
+	stateKey := fs.String("key", "", "stamp the manifests as owned by this state key, so goat apply -key manages them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	layout, ok := layouts[*layoutName]
+	if !ok {
+		return fmt.Errorf("unknown layout %q", *layoutName)
+	}
+	var opts []compiler.ManifestOption
+	if *stateKey != "" {
+		opts = append(opts, compiler.WithStateKey(*stateKey))
+	}
+
+	dag, err := e.dag(fs.Args())
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		files, err := compiler.RenderFiles(dag, layout, opts...)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(*out, 0755); err != nil {
+			return err
+		}
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(*out, name), data, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var data []byte
+	switch *format {
+	case "yaml":
+		data, err = compiler.RenderYAML(dag, opts...)
+	case "json":
+		data, err = compiler.RenderJSON(dag, opts...)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = e.stdout.Write(data)
+	return err
+}
+
+func runCompile(e *env, args []string) error {
+	fs := e.flagSet("compile")
+	e.sourceFlags(fs)
+	e.signFlag(fs)
+	out := fs.String("o", "", "output file (defaults to stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	payload, err := e.signedPayload(context.Background(), fs.Args())
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, payload, 0644)
+	}
+	_, err = e.stdout.Write(payload)
+	return err
+}
+
+func runGraph(e *env, args []string) error {
+	fs := e.flagSet("graph")
+	e.sourceFlags(fs)
+	format := fs.String("format", "text", `output format: "text" or "dot"`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dag, err := e.dag(fs.Args())
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "text":
+		writeGraphText(e.stdout, dag)
+	case "dot":
+		writeGraphDot(e.stdout, dag)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
+
+func (e *env) dag(args []string) (*ast.DAG, error) {
+	payload, err := e.payload(context.Background(), args)
+	if err != nil {
+		return nil, err
+	}
+	dag, err := ast.Deserialize(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize AST: %w", err)
+	}
+	return dag, nil
+}
+
+func sortedNodeKeys(dag *ast.DAG) []string {
+	keys := make([]string, 0, len(dag.Nodes))
+	for key := range dag.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeGraphText(w io.Writer, dag *ast.DAG) {
+	for _, key := range sortedNodeKeys(dag) {
+		node := dag.Nodes[key]
+		fmt.Fprintf(w, "%s %s/%s", node.ObjectKind(), node.Namespace, node.Name)
+		if len(node.Dependencies) > 0 {
+			deps := append([]string(nil), node.Dependencies...)
+			sort.Strings(deps)
+			fmt.Fprintf(w, " -> %s", strings.Join(deps, ", "))
+		}
+		if status, ok := dag.Status[node.Identity()]; ok {
+			fmt.Fprintf(w, " [%s failed: %s]", status.Op, status.Error)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func writeGraphDot(w io.Writer, dag *ast.DAG) {
+	fmt.Fprintln(w, "digraph goat {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	for _, key := range sortedNodeKeys(dag) {
+		node := dag.Nodes[key]
+		fmt.Fprintf(w, "  %q [label=%q];\n", key, node.ObjectKind()+"\n"+node.Namespace+"/"+node.Name)
+	}
+	for _, key := range sortedNodeKeys(dag) {
+		deps := append([]string(nil), dag.Nodes[key].Dependencies...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			fmt.Fprintf(w, "  %q -> %q;\n", key, dep)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
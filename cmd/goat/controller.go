@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/arpanpathak/kube-goAT/pkg/controller"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func runController(e *env, args []string) error {
+	fs := e.flagSet("controller")
+	e.clusterFlags(fs)
+	keys := fs.String("keys", "", "comma-separated state keys to reconcile (defaults to every recorded key)")
+	stacks := fs.Bool("stacks", false, "apply GoatStack resources published with 'goat publish' instead of state keys")
+	clusterKinds := fs.String("cluster-kinds", "", `comma-separated cluster-scoped kinds GoatStacks may apply, as "Kind" or "Kind.group"`)
+	watch := fs.String("watch-namespace", "", "namespace to watch managed objects or GoatStacks in (defaults to all)")
+	resync := fs.Duration("resync", controller.DefaultResync, "how often every key is reconciled without a watch event")
+	leaderElect := fs.Bool("leader-elect", false, "run only while holding a Lease, so several replicas can be deployed")
+	leaseNamespace := fs.String("lease-namespace", "", "namespace of the Lease (defaults to -namespace)")
+	leaseName := fs.String("lease-name", "kube-goat-controller", "name of the Lease")
+	identity := fs.String("identity", "", "holder identity for the Lease (defaults to the hostname)")
+	e.trustFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	trust, err := e.trustOptions()
+	if err != nil {
+		return err
+	}
+	eng, err := e.engine(store, trust...)
+	if err != nil {
+		return err
+	}
+
+	opts := []controller.Option{controller.WithNamespace(*watch), controller.WithResync(*resync)}
+	if *leaderElect {
+		if *leaseNamespace == "" {
+			*leaseNamespace = e.namespace
+		}
+		if *identity == "" {
+			if *identity, err = os.Hostname(); err != nil {
+				return err
+			}
+		}
+		opts = append(opts, controller.WithLeaderElection(*leaseNamespace, *leaseName, *identity))
+	}
+
+	if *stacks {
+		if *clusterKinds != "" {
+			opts = append(opts, controller.WithClusterScopedKinds(strings.Split(*clusterKinds, ",")...))
+		}
+		dyn, err := e.dynamicClient()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stderr, "Reconciling GoatStacks every %s.\n", *resync)
+		return controller.NewStackController(eng, dyn, opts...).Run(ctx)
+	}
+
+	var stateKeys []string
+	if *keys != "" {
+		stateKeys = strings.Split(*keys, ",")
+	} else {
+		if stateKeys, err = store.List(ctx, ""); err != nil {
+			return err
+		}
+	}
+	if len(stateKeys) == 0 {
+		return fmt.Errorf("no state keys to reconcile")
+	}
+	fmt.Fprintf(e.stderr, "Reconciling %s every %s.\n", strings.Join(stateKeys, ", "), *resync)
+	return controller.New(eng, store, stateKeys, opts...).Run(ctx)
+}
+
+func runPublish(e *env, args []string) error {
+	fs := e.flagSet("publish")
+	e.clusterFlags(fs)
+	e.sourceFlags(fs)
+	e.signFlag(fs)
+	name := fs.String("name", "goat", "name of the GoatStack in -namespace")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	payload, err := e.signedPayload(ctx, fs.Args())
+	if err != nil {
+		return err
+	}
+	dyn, err := e.dynamicClient()
+	if err != nil {
+		return err
+	}
+	stacks := dyn.Resource(controller.StackResource).Namespace(e.namespace)
+	encoded := base64.StdEncoding.EncodeToString(payload)
+
+	stack, err := stacks.Get(ctx, *name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		stack = &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": controller.StackResource.GroupVersion().String(),
+			"kind":       controller.StackKind,
+			"metadata":   map[string]any{"name": *name, "namespace": e.namespace},
+			"spec":       map[string]any{"payload": encoded},
+		}}
+		_, err = stacks.Create(ctx, stack, metav1.CreateOptions{})
+	} else if err == nil {
+		if err = unstructured.SetNestedField(stack.Object, encoded, "spec", "payload"); err == nil {
+			_, err = stacks.Update(ctx, stack, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "Published GoatStack %s/%s (%d bytes).\n", e.namespace, *name, len(payload))
+	return nil
+}
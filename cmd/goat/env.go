@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// env carries the streams and the flags shared by the commands.
+type env struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	kubeconfig  string
+	kubecontext string
+	namespace   string
+	stateSpec   string
+	stateKey    string
+	payloadFile string
+	graphFunc   string
+	signKey     string
+	trust       string
+
+	config *rest.Config
+}
+
+func (e *env) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("goat "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+// clusterFlags registers the flags selecting the cluster and state store.
+func (e *env) clusterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&e.kubeconfig, "kubeconfig", "", "path to the kubeconfig file (defaults to $KUBECONFIG or ~/.kube/config)")
+	fs.StringVar(&e.kubecontext, "context", "", "kubeconfig context to use")
+	fs.StringVar(&e.namespace, "namespace", "", "namespace of the state Secrets (defaults to the context's namespace)")
+	fs.StringVar(&e.stateSpec, "state", "secret", `state backend: "secret" or "configmap" for Secrets or ConfigMaps in -namespace, "local:<dir>" or "s3://<bucket>/<prefix>"`)
+}
+
+// stateKeyFlag registers the -key flag naming the state record.
+func (e *env) stateKeyFlag(fs *flag.FlagSet) {
+	fs.StringVar(&e.stateKey, "key", "goat", "state key the graph is recorded under")
+}
+
+// sourceFlags registers the flags selecting where the graph comes from.
+func (e *env) sourceFlags(fs *flag.FlagSet) {
+	fs.StringVar(&e.payloadFile, "payload", "", `compiled payload file ("-" for stdin) instead of a Go package`)
+	fs.StringVar(&e.graphFunc, "func", "Graph", "function of the package returning the *dsl.GraphBuilder")
+}
+
+// signFlag registers -sign-key, the private key compiled payloads are
+// signed with.
+func (e *env) signFlag(fs *flag.FlagSet) {
+	fs.StringVar(&e.signKey, "sign-key", "", "sign the compiled payload with this ed25519 private key (PEM); the file name is the signer identity")
+}
+
+// trustFlag registers -trust, the public keys payloads must be signed with.
+func (e *env) trustFlag(fs *flag.FlagSet) {
+	fs.StringVar(&e.trust, "trust", "", "comma-separated ed25519 public keys (PEM) or directories of *.pub files; refuse payloads not signed by one of them")
+}
+
+// trustOptions returns the engine options enforcing -trust.
+func (e *env) trustOptions() ([]engine.Option, error) {
+	if e.trust == "" {
+		return nil, nil
+	}
+	trust, err := signing.LoadTrustSet(strings.Split(e.trust, ",")...)
+	if err != nil {
+		return nil, err
+	}
+	return []engine.Option{engine.WithTrust(trust)}, nil
+}
+
+// signedPayload loads the payload like payload and signs it with -sign-key
+// if given.
+func (e *env) signedPayload(ctx context.Context, args []string) ([]byte, error) {
+	payload, err := e.payload(ctx, args)
+	if err != nil || e.signKey == "" {
+		return payload, err
+	}
+	signer, err := signing.LoadEd25519Signer(e.signKey)
+	if err != nil {
+		return nil, err
+	}
+	return signing.Sign(payload, signer)
+}
+
+// restConfig resolves the kubeconfig once, honouring -kubeconfig, -context
+// and -namespace the same way kubectl does.
+func (e *env) restConfig() (*rest.Config, error) {
+	if e.config != nil {
+		return e.config, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = e.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: e.kubecontext}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if e.namespace == "" {
+		if e.namespace, _, err = loader.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+	e.config = config
+	return config, nil
+}
+
+// store opens the backend selected by -state.
+func (e *env) store() (state.Store, error) {
+	return e.openStore(e.stateSpec)
+}
+
+// openStore opens the backend named by spec, in the form -state takes.
+func (e *env) openStore(spec string) (state.Store, error) {
+	if dir, ok := strings.CutPrefix(spec, "local:"); ok {
+		return state.NewLocalStore(dir)
+	}
+	if location, ok := strings.CutPrefix(spec, "s3://"); ok {
+		return s3Store(location)
+	}
+	if spec != "secret" && spec != "configmap" {
+		return nil, fmt.Errorf("unknown state backend %q", spec)
+	}
+	config, err := e.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if spec == "configmap" {
+		return state.NewConfigMapStore(client, e.namespace), nil
+	}
+	return state.NewKubernetesStore(client, e.namespace), nil
+}
+
+// s3Store opens an S3Store for "bucket/prefix", taking credentials, the
+// region and the endpoint from the environment variables the AWS CLI reads.
+func s3Store(location string) (*state.S3Store, error) {
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return state.NewS3Store(state.S3Config{
+		Endpoint:        firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		Region:          firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Bucket:          bucket,
+		Prefix:          prefix,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (e *env) dynamicClient() (dynamic.Interface, error) {
+	config, err := e.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(config)
+}
+
+func (e *env) engine(store state.Store, opts ...engine.Option) (*engine.Engine, error) {
+	config, err := e.restConfig()
+	if err != nil {
+		return nil, err
+	}
+	return engine.NewEngineForConfig(config, store, opts...)
+}
+
+// payload loads the compiled graph from -payload or by running the package
+// named by the remaining arguments.
+func (e *env) payload(ctx context.Context, args []string) ([]byte, error) {
+	switch {
+	case e.payloadFile != "" && len(args) > 0:
+		return nil, fmt.Errorf("-payload and a package are mutually exclusive")
+	case e.payloadFile == "-":
+		return io.ReadAll(e.stdin)
+	case e.payloadFile != "":
+		return os.ReadFile(e.payloadFile)
+	case len(args) > 1:
+		return nil, fmt.Errorf("expected one package, got %d", len(args))
+	}
+	pkg := "."
+	if len(args) == 1 {
+		pkg = args[0]
+	}
+	return e.compilePackage(ctx, pkg)
+}
+
+const runnerSource = `package main
+
+import (
+	"fmt"
+	"os"
+
+	graph %q
+	"github.com/arpanpathak/kube-goAT/pkg/compiler"
+)
+
+func main() {
+	payload, err := compiler.Compile(graph.%s())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(payload)
+}
+`
+
+// compilePackage compiles the graph returned by a Go package. A throwaway
+// main package is written inside the package's module, so it builds with
+// the module's own dependencies, and run with the go tool.
+func (e *env) compilePackage(ctx context.Context, pkg string) ([]byte, error) {
+	var listed bytes.Buffer
+	list := exec.CommandContext(ctx, "go", "list", "-f", "{{.ImportPath}}\t{{.Name}}\t{{with .Module}}{{.Dir}}{{end}}", pkg)
+	list.Stdout, list.Stderr = &listed, e.stderr
+	if err := list.Run(); err != nil {
+		return nil, fmt.Errorf("go list %s: %w", pkg, err)
+	}
+	fields := strings.Split(strings.TrimSpace(listed.String()), "\t")
+	if len(fields) != 3 || fields[2] == "" {
+		return nil, fmt.Errorf("package %s is not part of a Go module", pkg)
+	}
+	importPath, name, moduleDir := fields[0], fields[1], fields[2]
+	if name == "main" {
+		return nil, fmt.Errorf("package %s is a main package; export func %s() *dsl.GraphBuilder from a library package", pkg, e.graphFunc)
+	}
+
+	dir, err := os.MkdirTemp(moduleDir, ".goat-run-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	src := fmt.Sprintf(runnerSource, importPath, e.graphFunc)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644); err != nil {
+		return nil, err
+	}
+
+	var payload bytes.Buffer
+	run := exec.CommandContext(ctx, "go", "run", "./"+filepath.Base(dir))
+	run.Dir = moduleDir
+	run.Stdout, run.Stderr = &payload, e.stderr
+	if err := run.Run(); err != nil {
+		return nil, fmt.Errorf("compile %s: %w", pkg, err)
+	}
+	return payload.Bytes(), nil
+}
@@ -0,0 +1,102 @@
+// Command goat plans, applies and destroys kube-goAT graphs and manages
+// their recorded state.
+//
+//	goat plan       [flags] [package]   show what apply would change
+//	goat apply      [flags] [package]   reconcile the cluster with the graph
+//	goat import     [flags] [package]   take over existing objects into state
+//	goat destroy    [flags]             delete everything recorded in state
+//	goat drift      [flags]             compare live objects with recorded state
+//	goat controller [flags]             keep correcting drift until interrupted
+//	goat publish    [flags] [package]   store the compiled graph in a GoatStack
+//	goat compile    [flags] [package]   write the compiled, optionally signed, payload
+//	goat render     [flags] [package]   print the manifests the graph renders to
+//	goat graph      [flags] [package]   print the graph's nodes and dependencies
+//	goat state      list|show|rm|mv|pull|push
+//
+// The package is a Go package exporting func Graph() *dsl.GraphBuilder (the
+// name is set with -func); goat compiles and runs it. -payload loads an
+// already compiled payload instead.
+//
+// Exit codes: 0 on success, 1 on any error, and 2 from plan
+// -detailed-exitcode when the plan contains changes or from drift when
+// live objects drifted.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitChanges = 2
+)
+
+// errChanges makes plan -detailed-exitcode and drift exit with exitChanges.
+var errChanges = errors.New("changes detected")
+
+type command struct {
+	name    string
+	summary string
+	run     func(env *env, args []string) error
+}
+
+var commands = []command{
+	{"plan", "show the changes apply would make", runPlan},
+	{"apply", "reconcile the cluster with the graph", runApply},
+	{"import", "take over existing objects into state", runImport},
+	{"destroy", "delete every resource recorded in state", runDestroy},
+	{"drift", "report live objects that drifted from recorded state", runDrift},
+	{"controller", "continuously correct drift from recorded state", runController},
+	{"publish", "store the compiled graph in a GoatStack for the operator", runPublish},
+	{"compile", "write the compiled payload, optionally signed, to stdout or -o", runCompile},
+	{"render", "print the manifests the graph renders to", runRender},
+	{"graph", "print the graph's nodes and dependencies", runGraph},
+	{"state", "list, show, remove, move, pull or push recorded state", runState},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes one goat invocation and returns its exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return exitError
+		}
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(&env{stdin: stdin, stdout: stdout, stderr: stderr}, args[1:])
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errChanges):
+			return exitChanges
+		default:
+			fmt.Fprintf(stderr, "goat %s: %v\n", cmd.name, err)
+			return exitError
+		}
+	}
+	fmt.Fprintf(stderr, "goat: unknown command %q\n", args[0])
+	usage(stderr)
+	return exitError
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: goat <command> [flags] [package]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nRun 'goat <command> -h' for the command's flags.")
+}
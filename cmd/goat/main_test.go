@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
+)
+
+// kubeconfig points at an unreachable server; commands that only touch
+// local state never connect to it.
+const kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster: {server: "https://127.0.0.1:1"}
+contexts:
+- name: test
+  context: {cluster: test, user: test, namespace: team}
+current-context: test
+users:
+- name: test
+  user: {token: test}
+`
+
+func goat(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func writePayload(t *testing.T, dir, name string, g *dsl.GraphBuilder) string {
+	t.Helper()
+	payload, err := g.Build().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRenderAndGraph(t *testing.T) {
+	api := dsl.NewService("api", 80, 8080)
+	payload := writePayload(t, t.TempDir(), "graph.gob", dsl.NewGraph().
+		Add(api).
+		Add(dsl.NewDeployment("web", "nginx:1.27").AttachedTo(api)))
+
+	code, out, stderr := goat(t, "", "render", "-payload", payload)
+	if code != exitOK || !strings.Contains(out, "kind: Service") || !strings.Contains(out, "image: nginx:1.27") {
+		t.Fatalf("render exited %d: %s%s", code, out, stderr)
+	}
+	if strings.Contains(out, "kube-goat.io/stack") {
+		t.Errorf("expected no ownership without -key:\n%s", out)
+	}
+	code, out, _ = goat(t, "", "render", "-key", "web", "-payload", payload)
+	if code != exitOK || !strings.Contains(out, "kube-goat.io/stack: web") {
+		t.Errorf("expected render -key to stamp the ownership:\n%s", out)
+	}
+
+	code, out, _ = goat(t, "", "graph", "-payload", payload)
+	want := "Service default/api\nDeployment default/web -> api\n"
+	if code != exitOK || out != want {
+		t.Errorf("graph exited %d:\n%s\nwant:\n%s", code, out, want)
+	}
+
+	code, out, _ = goat(t, "", "graph", "-format", "dot", "-payload", payload)
+	if code != exitOK || !strings.Contains(out, `"web" -> "api";`) {
+		t.Errorf("dot output missing edge:\n%s", out)
+	}
+
+	dir := t.TempDir()
+	if code, _, stderr := goat(t, "", "render", "-payload", payload, "-o", dir, "-layout", "kustomize"); code != exitOK {
+		t.Fatalf("render -o exited %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kustomization.yaml")); err != nil {
+		t.Errorf("expected kustomization.yaml: %v", err)
+	}
+}
+
+func TestPlanExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "kubeconfig")
+	if err := os.WriteFile(config, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	payload := writePayload(t, dir, "graph.gob", dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	flags := []string{"-kubeconfig", config, "-state", "local:" + filepath.Join(dir, "state"), "-key", "app", "-payload", payload}
+
+	code, out, stderr := goat(t, "", append([]string{"plan", "-detailed-exitcode"}, flags...)...)
+	if code != exitChanges || !strings.Contains(out, "+ create Service default/api") {
+		t.Fatalf("plan exited %d: %s%s", code, out, stderr)
+	}
+
+	stateFlags := flags[2:4]
+	if code, _, stderr := goat(t, "", append(append([]string{"state", "push"}, stateFlags...), "app", payload)...); code != exitOK {
+		t.Fatalf("state push exited %d: %s", code, stderr)
+	}
+	code, out, _ = goat(t, "", append([]string{"plan", "-detailed-exitcode"}, flags...)...)
+	if code != exitOK || !strings.Contains(out, "0 to create, 0 to update, 0 to delete, 1 unchanged") {
+		t.Errorf("plan against matching state exited %d: %s", code, out)
+	}
+}
+
+func TestStateCommands(t *testing.T) {
+	dir := t.TempDir()
+	payload := writePayload(t, dir, "graph.gob", dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	local := []string{"-state", "local:" + filepath.Join(dir, "state")}
+	state := func(args ...string) (int, string, string) {
+		cmd := append([]string{"state", args[0]}, local...)
+		return goat(t, "", append(cmd, args[1:]...)...)
+	}
+
+	if code, _, stderr := state("push", "one", payload); code != exitOK {
+		t.Fatalf("push exited %d: %s", code, stderr)
+	}
+	if code, _, _ := state("push", "bad", filepath.Join(dir, "state", "missing.gob")); code != exitError {
+		t.Error("pushing a missing file should fail")
+	}
+	if code, _, stderr := state("mv", "one", "two"); code != exitOK {
+		t.Fatalf("mv exited %d: %s", code, stderr)
+	}
+	if code, out, _ := state("list"); code != exitOK || out != "two\n" {
+		t.Errorf("list = %q", out)
+	}
+	if code, out, _ := state("show", "two"); code != exitOK || out != "Service default/api\n" {
+		t.Errorf("show = %q", out)
+	}
+	if code, out, _ := state("show", "-format", "json", "two"); code != exitOK || !strings.Contains(out, `"kind": "Service"`) {
+		t.Errorf("show -format json = %q", out)
+	}
+	want, _ := os.ReadFile(payload)
+	if code, out, _ := state("pull", "two"); code != exitOK || out != string(want) {
+		t.Error("pull did not return the pushed payload")
+	}
+	if code, out, stderr := state("convert", "-format", "proto"); code != exitOK || out != "converted two to proto\n" {
+		t.Fatalf("convert exited %d: %q %s", code, out, stderr)
+	}
+	if code, out, _ := state("show", "two"); code != exitOK || out != "Service default/api\n" {
+		t.Errorf("show after convert = %q", out)
+	}
+	if code, _, _ := state("convert", "-format", "yaml", "two"); code != exitError {
+		t.Error("converting to an unknown format should fail")
+	}
+	if code, _, stderr := state("rm", "two"); code != exitOK {
+		t.Fatalf("rm exited %d: %s", code, stderr)
+	}
+	if code, out, _ := state("list"); code != exitOK || out != "" {
+		t.Errorf("list after rm = %q", out)
+	}
+
+	dest := "local:" + filepath.Join(dir, "migrated")
+	state("push", "three", payload)
+	if code, _, _ := state("migrate", "three"); code != exitError {
+		t.Error("migrate without -to should fail")
+	}
+	if code, out, stderr := state("migrate", "-to", dest, "-lock", "-delete"); code != exitOK || out != "migrated three\n" {
+		t.Fatalf("migrate exited %d: %q %s", code, out, stderr)
+	}
+	if code, out, _ := state("list"); code != exitOK || out != "" {
+		t.Errorf("list after migrate -delete = %q", out)
+	}
+	if code, out, _ := goat(t, "", "state", "pull", "-state", dest, "three"); code != exitOK || out != string(want) {
+		t.Error("the migrated payload differs from the pushed one")
+	}
+}
+
+func TestCompilePackage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go tool")
+	}
+	code, out, stderr := goat(t, "", "graph", "./testdata/infra")
+	if code != exitOK || out != "Service default/api\nDeployment default/web -> api\n" {
+		t.Fatalf("graph of package exited %d:\n%s%s", code, out, stderr)
+	}
+	matches, _ := filepath.Glob("../../.goat-run-*")
+	if len(matches) != 0 {
+		t.Errorf("runner directory left behind: %v", matches)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if code, _, _ := goat(t, "", "frobnicate"); code != exitError {
+		t.Errorf("unknown command exited %d", code)
+	}
+	if code, _, _ := goat(t, "", "destroy", "-state", "local:"+t.TempDir()); code != exitError {
+		t.Errorf("unconfirmed destroy exited %d", code)
+	}
+}
+
+func TestCompileSigned(t *testing.T) {
+	dir := t.TempDir()
+	public, private, _ := ed25519.GenerateKey(nil)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	pub, _ := x509.MarshalPKIXPublicKey(public)
+	os.WriteFile(filepath.Join(dir, "ci.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, "ci.pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644)
+	config := filepath.Join(dir, "kubeconfig")
+	os.WriteFile(config, []byte(kubeconfig), 0600)
+	payload := writePayload(t, dir, "graph.gob", dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+
+	signed := filepath.Join(dir, "signed.goat")
+	if code, _, stderr := goat(t, "", "compile", "-payload", payload, "-sign-key", filepath.Join(dir, "ci.pem"), "-o", signed); code != exitOK {
+		t.Fatalf("compile exited %d: %s", code, stderr)
+	}
+	data, _ := os.ReadFile(signed)
+	if _, identity, err := signing.Verify(data, signing.TrustSet{"ci": public}); err != nil || identity != "ci" {
+		t.Errorf("expected a payload signed by ci, got %q, %v", identity, err)
+	}
+	if code, out, _ := goat(t, "", "graph", "-payload", signed); code != exitOK || out != "Service default/api\n" {
+		t.Errorf("graph of signed payload = %q", out)
+	}
+
+	code, _, stderr := goat(t, "", "apply", "-kubeconfig", config, "-state", "local:"+filepath.Join(dir, "state"),
+		"-trust", filepath.Join(dir, "ci.pub"), "-payload", payload)
+	if code != exitError || !strings.Contains(stderr, "payload is not signed") {
+		t.Errorf("expected apply -trust to refuse an unsigned payload, exited %d: %s", code, stderr)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+)
+
+var stateCommands = []command{
+	{"list", "list recorded state keys", stateList},
+	{"show", "print the graph recorded under KEY", stateShow},
+	{"rm", "forget KEY without touching the cluster", stateRm},
+	{"mv", "rename FROM to TO", stateMv},
+	{"pull", "write the raw payload recorded under KEY to stdout or -o", statePull},
+	{"push", "record a compiled payload FILE under KEY", statePush},
+	{"convert", "rewrite KEYs (default all) in another payload format", stateConvert},
+	{"migrate", "copy KEYs (default all) and their history to the -to backend", stateMigrate},
+}
+
+func runState(e *env, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(e.stderr, "Usage: goat state <command> [flags] [args]\n\nCommands:")
+		for _, cmd := range stateCommands {
+			fmt.Fprintf(e.stderr, "  %-7s %s\n", cmd.name, cmd.summary)
+		}
+		return fmt.Errorf("missing state command")
+	}
+	for _, cmd := range stateCommands {
+		if cmd.name == args[0] {
+			return cmd.run(e, args[1:])
+		}
+	}
+	return fmt.Errorf("unknown state command %q", args[0])
+}
+
+// stateArgs parses a state subcommand's flags and checks its positional
+// argument count before opening the store; a negative want accepts any
+// number.
+func stateArgs(e *env, name string, args []string, want int, extra func(*flag.FlagSet)) ([]string, state.Store, error) {
+	fs := e.flagSet("state " + name)
+	e.clusterFlags(fs)
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if want >= 0 && fs.NArg() != want {
+		return nil, nil, fmt.Errorf("expected %d arguments, got %d", want, fs.NArg())
+	}
+	store, err := e.store()
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs.Args(), store, nil
+}
+
+func stateList(e *env, args []string) error {
+	var prefix string
+	_, store, err := stateArgs(e, "list", args, 0, func(fs *flag.FlagSet) {
+		fs.StringVar(&prefix, "prefix", "", "only list keys starting with this prefix")
+	})
+	if err != nil {
+		return err
+	}
+	keys, err := store.List(context.Background(), prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fmt.Fprintln(e.stdout, key)
+	}
+	return nil
+}
+
+func stateShow(e *env, args []string) error {
+	var format string
+	rest, store, err := stateArgs(e, "show", args, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", "text", "output format: text or json")
+	})
+	if err != nil {
+		return err
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	data, err := store.Load(context.Background(), rest[0])
+	if err != nil {
+		return err
+	}
+	dag, err := ast.Deserialize(data)
+	if errors.Is(err, ast.ErrNewerSchema) {
+		return fmt.Errorf("state %s: %w", rest[0], err)
+	} else if err != nil {
+		return fmt.Errorf("state %s is corrupt: %w", rest[0], err)
+	}
+	if format == "json" {
+		return writeGraphJSON(e.stdout, dag)
+	}
+	writeGraphText(e.stdout, dag)
+	if dag.Signer != "" {
+		fmt.Fprintf(e.stdout, "Signed by %s\n", dag.Signer)
+	}
+	return nil
+}
+
+// writeGraphJSON prints the body of dag's JSON payload, indented.
+func writeGraphJSON(w io.Writer, dag *ast.DAG) error {
+	codec, err := ast.LookupCodec(ast.FormatJSON)
+	if err != nil {
+		return err
+	}
+	body, err := codec.Marshal(dag)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+func stateRm(e *env, args []string) (err error) {
+	rest, store, err := stateArgs(e, "rm", args, 1, nil)
+	if err != nil {
+		return err
+	}
+	ctx, unlock, err := state.Lock(context.Background(), store, rest[0])
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, unlock()) }()
+	return store.Delete(ctx, rest[0])
+}
+
+func stateMv(e *env, args []string) error {
+	rest, store, err := stateArgs(e, "mv", args, 2, nil)
+	if err != nil {
+		return err
+	}
+	return state.Rename(context.Background(), store, rest[0], rest[1])
+}
+
+func statePull(e *env, args []string) error {
+	var out string
+	rest, store, err := stateArgs(e, "pull", args, 1, func(fs *flag.FlagSet) {
+		fs.StringVar(&out, "o", "", "output file (defaults to stdout)")
+	})
+	if err != nil {
+		return err
+	}
+	data, err := store.Load(context.Background(), rest[0])
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		return os.WriteFile(out, data, 0600)
+	}
+	_, err = e.stdout.Write(data)
+	return err
+}
+
+func statePush(e *env, args []string) (err error) {
+	rest, store, err := stateArgs(e, "push", args, 2, nil)
+	if err != nil {
+		return err
+	}
+	key, file := rest[0], rest[1]
+	var data []byte
+	if file == "-" {
+		data, err = io.ReadAll(e.stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := ast.Deserialize(data); err != nil {
+		return fmt.Errorf("%s is not a compiled payload: %w", file, err)
+	}
+	ctx, unlock, err := state.Lock(context.Background(), store, key)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, unlock()) }()
+	// Stores saving conditionally replace only what they last loaded.
+	if _, err := store.Load(ctx, key); err != nil && !errors.Is(err, state.ErrNotFound) {
+		return err
+	}
+	return store.Save(ctx, key, data)
+}
+
+func stateConvert(e *env, args []string) error {
+	var format string
+	keys, store, err := stateArgs(e, "convert", args, -1, func(fs *flag.FlagSet) {
+		fs.StringVar(&format, "format", ast.FormatJSON, "payload format: "+strings.Join(ast.Formats(), ", "))
+	})
+	if err != nil {
+		return err
+	}
+	converted, err := state.Convert(context.Background(), store, format, keys...)
+	for _, key := range converted {
+		fmt.Fprintf(e.stdout, "converted %s to %s\n", key, format)
+	}
+	return err
+}
+
+func stateMigrate(e *env, args []string) error {
+	var to string
+	var lock, deleteSource, noHistory bool
+	keys, from, err := stateArgs(e, "migrate", args, -1, func(fs *flag.FlagSet) {
+		fs.StringVar(&to, "to", "", "destination backend, in the form -state takes")
+		fs.BoolVar(&lock, "lock", false, "lock each key in both backends while it is copied")
+		fs.BoolVar(&deleteSource, "delete", false, "delete each key from the source once its copy is verified")
+		fs.BoolVar(&noHistory, "no-history", false, "copy only the current payload when the destination keeps no history")
+	})
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		return fmt.Errorf("-to is required")
+	}
+	dest, err := e.openStore(to)
+	if err != nil {
+		return err
+	}
+	var opts []state.MigrateOption
+	if lock {
+		opts = append(opts, state.WithMigrationLock())
+	}
+	if deleteSource {
+		opts = append(opts, state.WithSourceDeletion())
+	}
+	if noHistory {
+		opts = append(opts, state.WithoutHistory())
+	}
+	migrated, err := state.Migrate(context.Background(), from, dest, keys, opts...)
+	for _, key := range migrated {
+		fmt.Fprintf(e.stdout, "migrated %s\n", key)
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+// Package infra is a graph package used by the goat command tests.
+package infra
+
+import "github.com/arpanpathak/kube-goAT/pkg/dsl"
+
+// Graph returns the test graph.
+func Graph() *dsl.GraphBuilder {
+	api := dsl.NewService("api", 80, 8080)
+	return dsl.NewGraph().
+		Add(api).
+		Add(dsl.NewDeployment("web", "nginx:1.27").AttachedTo(api))
+}
@@ -38,7 +38,10 @@ func main() {
 
 	// 3. Execution Phase
 	// Setup local state directory for demo purposes (can easily swap to state.NewKubernetesStore)
-	store := state.NewLocalStore("./state-store")
+	store, err := state.NewLocalStore("./state-store")
+	if err != nil {
+		log.Fatalf("State store error: %v", err)
+	}
 
 	home, _ := os.UserHomeDir()
 	kubeconfig := filepath.Join(home, ".kube", "config")
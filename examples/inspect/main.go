@@ -40,7 +40,10 @@ func main() {
 	fmt.Printf("\n📦 Binary Serialized File Size: %d bytes (Extremely Compact!)\n", len(binaryPayload))
 
 	// Manually push into the state-store to populate it for the user
-	store := state.NewLocalStore("./state-store")
+	store, err := state.NewLocalStore("./state-store")
+	if err != nil {
+		log.Fatalf("Failed to open state store: %v", err)
+	}
 	err = store.Save(context.Background(), "web-server-infra", binaryPayload)
 	if err != nil {
 		log.Fatalf("Failed to save state: %v", err)
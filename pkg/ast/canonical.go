@@ -0,0 +1,306 @@
+package ast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The canonical wire form replaces every map with a slice sorted by key, so
+// identical graphs always encode to identical bytes.
+type wireDAG struct {
+	Graph         []wireNode
+	NodeStatus    []wireStatus
+	Signer        string
+	SignedPayload []byte
+}
+
+type wireNode struct {
+	Key          string
+	Kind         string
+	Name         string
+	Namespace    string
+	Dependencies []string
+	Properties   wireMap
+}
+
+type wireStatus struct {
+	Identity string
+	Op       string
+	Error    string
+}
+
+// wireMap is the canonical form of a map[string]any.
+type wireMap []wireEntry
+
+type wireEntry struct {
+	Key   string
+	Value any
+}
+
+// wireStringMap is the canonical form of a map[string]string.
+type wireStringMap []wireStringEntry
+
+type wireStringEntry struct {
+	Key   string
+	Value string
+}
+
+func init() {
+	gob.Register(wireMap{})
+	gob.Register(wireStringMap{})
+	// Assign the wire types their gob ids up front, independent of what
+	// else the process encodes first.
+	gob.NewEncoder(io.Discard).Encode(wireDAG{})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *DAG) wire() *wireDAG {
+	w := &wireDAG{Signer: d.Signer, SignedPayload: d.SignedPayload}
+	for _, key := range sortedKeys(d.Nodes) {
+		n := d.Nodes[key]
+		w.Graph = append(w.Graph, wireNode{
+			Key:          key,
+			Kind:         n.Kind,
+			Name:         n.Name,
+			Namespace:    n.Namespace,
+			Dependencies: n.Dependencies,
+			Properties:   toWireMap(encodeNode(n)),
+		})
+	}
+	for _, id := range sortedKeys(d.Status) {
+		s := d.Status[id]
+		w.NodeStatus = append(w.NodeStatus, wireStatus{Identity: id, Op: s.Op, Error: s.Error})
+	}
+	return w
+}
+
+// dag decodes every node's properties into its registered Spec type.
+func (w *wireDAG) dag() (*DAG, error) {
+	d := &DAG{Nodes: make(map[string]*Node, len(w.Graph)), Signer: w.Signer, SignedPayload: w.SignedPayload}
+	for _, n := range w.Graph {
+		node := &Node{
+			Kind:         n.Kind,
+			Name:         n.Name,
+			Namespace:    n.Namespace,
+			Dependencies: n.Dependencies,
+		}
+		if err := decodeNode(node, fromWireMap(n.Properties)); err != nil {
+			return nil, err
+		}
+		d.Nodes[n.Key] = node
+	}
+	for _, s := range w.NodeStatus {
+		if d.Status == nil {
+			d.Status = make(map[string]NodeStatus)
+		}
+		d.Status[s.Identity] = NodeStatus{Op: s.Op, Error: s.Error}
+	}
+	return d, nil
+}
+
+// legacyDAG is the DAG as gob-encoded before the canonical wire form, when
+// nodes held untyped properties.
+type legacyDAG struct {
+	Nodes  map[string]*legacyNode
+	Status map[string]NodeStatus
+}
+
+type legacyNode struct {
+	Kind         string
+	Name         string
+	Namespace    string
+	Dependencies []string
+	Properties   map[string]any
+}
+
+func (d *legacyDAG) wire() *wireDAG {
+	w := &wireDAG{}
+	for _, key := range sortedKeys(d.Nodes) {
+		n := d.Nodes[key]
+		w.Graph = append(w.Graph, wireNode{
+			Key:          key,
+			Kind:         n.Kind,
+			Name:         n.Name,
+			Namespace:    n.Namespace,
+			Dependencies: n.Dependencies,
+			Properties:   toWireMap(n.Properties),
+		})
+	}
+	for _, id := range sortedKeys(d.Status) {
+		s := d.Status[id]
+		w.NodeStatus = append(w.NodeStatus, wireStatus{Identity: id, Op: s.Op, Error: s.Error})
+	}
+	return w
+}
+
+func toWireMap(m map[string]any) wireMap {
+	if m == nil {
+		return nil
+	}
+	w := make(wireMap, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		w = append(w, wireEntry{Key: k, Value: toWire(m[k])})
+	}
+	return w
+}
+
+func toWire(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return toWireMap(v)
+	case map[string]string:
+		w := make(wireStringMap, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			w = append(w, wireStringEntry{Key: k, Value: v[k]})
+		}
+		return w
+	case []any:
+		w := make([]any, len(v))
+		for i := range v {
+			w[i] = toWire(v[i])
+		}
+		return w
+	}
+	return v
+}
+
+func fromWireMap(w wireMap) map[string]any {
+	if w == nil {
+		return nil
+	}
+	m := make(map[string]any, len(w))
+	for _, e := range w {
+		m[e.Key] = fromWire(e.Value)
+	}
+	return m
+}
+
+func fromWire(v any) any {
+	switch v := v.(type) {
+	case wireMap:
+		return fromWireMap(v)
+	case wireStringMap:
+		m := make(map[string]string, len(v))
+		for _, e := range v {
+			m[e.Key] = e.Value
+		}
+		return m
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			out[i] = fromWire(v[i])
+		}
+		return out
+	}
+	return v
+}
+
+// Hash returns the hex SHA-256 digest of the node's content: its kind,
+// name, namespace, dependencies and encoded spec. It does not depend on map
+// iteration order, the order dependencies were declared in, or how the
+// node was encoded.
+func (n *Node) Hash() string {
+	h := sha256.New()
+	writeNode(h, n)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hash returns the hex SHA-256 digest of the graph: every node key with its
+// node's content. Status is not part of the graph and is ignored.
+func (d *DAG) Hash() string {
+	h := sha256.New()
+	for _, key := range sortedKeys(d.Nodes) {
+		writeString(h, key)
+		writeNode(h, d.Nodes[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeNode(w io.Writer, n *Node) {
+	writeString(w, n.Kind)
+	writeString(w, n.Name)
+	writeString(w, n.Namespace)
+	deps := append([]string(nil), n.Dependencies...)
+	sort.Strings(deps)
+	writeValue(w, deps)
+	writeValue(w, encodeNode(n))
+}
+
+// writeString writes a length-prefixed string so adjacent values cannot run
+// into each other.
+func writeString(w io.Writer, s string) {
+	io.WriteString(w, strconv.Itoa(len(s)))
+	io.WriteString(w, ":")
+	io.WriteString(w, s)
+}
+
+// writeValue writes a property value tagged with its type, so values that
+// render alike, such as int32(1) and int64(1), hash differently just as
+// they decode differently.
+func writeValue(w io.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		io.WriteString(w, "n")
+	case string:
+		io.WriteString(w, "s")
+		writeString(w, v)
+	case bool:
+		io.WriteString(w, "b"+strconv.FormatBool(v)+";")
+	case int:
+		io.WriteString(w, "i"+strconv.FormatInt(int64(v), 10)+";")
+	case int32:
+		io.WriteString(w, "i32:"+strconv.FormatInt(int64(v), 10)+";")
+	case int64:
+		io.WriteString(w, "i64:"+strconv.FormatInt(v, 10)+";")
+	case float64:
+		io.WriteString(w, "f64:"+strconv.FormatUint(math.Float64bits(v), 16)+";")
+	case map[string]any:
+		io.WriteString(w, "M"+strconv.Itoa(len(v))+"{")
+		for _, k := range sortedKeys(v) {
+			writeString(w, k)
+			writeValue(w, v[k])
+		}
+		io.WriteString(w, "}")
+	case map[string]string:
+		io.WriteString(w, "S"+strconv.Itoa(len(v))+"{")
+		for _, k := range sortedKeys(v) {
+			writeString(w, k)
+			writeString(w, v[k])
+		}
+		io.WriteString(w, "}")
+	case []any:
+		io.WriteString(w, "A"+strconv.Itoa(len(v))+"[")
+		for _, e := range v {
+			writeValue(w, e)
+		}
+		io.WriteString(w, "]")
+	case []string:
+		io.WriteString(w, "L"+strconv.Itoa(len(v))+"[")
+		for _, e := range v {
+			writeString(w, e)
+		}
+		io.WriteString(w, "]")
+	default:
+		// Structs such as ServicePort hold no maps, so their Go syntax
+		// representation is deterministic.
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "%#v", v)
+		io.WriteString(w, "T")
+		writeString(w, buf.String())
+	}
+}
@@ -0,0 +1,121 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+)
+
+func sampleDAG() *DAG {
+	return &DAG{
+		Nodes: map[string]*Node{
+			"web": {Kind: "Deployment", Name: "web", Namespace: "default", Dependencies: []string{"db", "api"},
+				Spec: &DeploymentSpec{
+					Image:    "nginx",
+					Replicas: 2,
+					Labels:   map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"},
+					Ports:    []ContainerPort{{Name: "http", Port: 80, Protocol: "TCP"}},
+				}},
+			"api": {Kind: "Custom", Name: "api", Namespace: "default",
+				Spec: &CustomSpec{
+					APIVersion: "example.com/v1",
+					Kind:       "Widget",
+					Labels:     map[string]string{"team": "x"},
+					Content: map[string]any{
+						"spec": map[string]any{"size": int64(3), "ratio": 0.5, "tags": []any{"x", map[string]any{"y": true}}},
+					},
+				}},
+			"db": {Kind: "Service", Name: "db", Namespace: "default", Protected: true,
+				Spec: &ServiceSpec{
+					Type:     "ClusterIP",
+					Ports:    []ServicePort{{Protocol: "TCP", Port: 5432, TargetPort: 5432}},
+					Labels:   map[string]string{"tier": "data"},
+					Selector: map[string]string{"app": "db"},
+				}},
+		},
+		Status: map[string]NodeStatus{"Deployment/default/web": {Op: "update", Error: "boom"}},
+	}
+}
+
+func TestSerialize_Canonical(t *testing.T) {
+	first, err := sampleDAG().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		again, err := sampleDAG().Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, again) {
+			t.Fatal("identical graphs serialized to different bytes")
+		}
+	}
+
+	decoded, err := Deserialize(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, sampleDAG()) {
+		t.Errorf("round trip changed the graph:\n got %#v\nwant %#v", decoded, sampleDAG())
+	}
+}
+
+func TestDeserialize_LegacyPayload(t *testing.T) {
+	dag := sampleDAG()
+	legacy := legacyDAG{Nodes: make(map[string]*legacyNode), Status: dag.Status}
+	for key, n := range dag.Nodes {
+		legacy.Nodes[key] = &legacyNode{Kind: n.Kind, Name: n.Name, Namespace: n.Namespace,
+			Dependencies: n.Dependencies, Properties: encodeNode(n)}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Deserialize(buf.Bytes())
+	if err != nil {
+		t.Fatalf("legacy payload rejected: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, sampleDAG()) {
+		t.Error("legacy payload decoded differently")
+	}
+}
+
+func TestHash(t *testing.T) {
+	base := sampleDAG()
+	if base.Hash() != sampleDAG().Hash() {
+		t.Fatal("identical graphs hashed differently")
+	}
+
+	reordered := sampleDAG()
+	reordered.Nodes["web"].Dependencies = []string{"api", "db"}
+	reordered.Status = nil
+	if reordered.Hash() != base.Hash() || reordered.Nodes["web"].Hash() != base.Nodes["web"].Hash() {
+		t.Error("dependency order or status changed the hash")
+	}
+
+	changed := sampleDAG()
+	changed.Nodes["web"].Spec.(*DeploymentSpec).Replicas = 3
+	if changed.Nodes["web"].Hash() == base.Nodes["web"].Hash() {
+		t.Error("changing the spec did not change the node hash")
+	}
+	unprotected := sampleDAG()
+	unprotected.Nodes["db"].Protected = false
+	if unprotected.Nodes["db"].Hash() == base.Nodes["db"].Hash() {
+		t.Error("changing Protected did not change the node hash")
+	}
+	if changed.Nodes["db"].Hash() != base.Nodes["db"].Hash() {
+		t.Error("an unrelated node's hash changed")
+	}
+	if changed.Hash() == base.Hash() {
+		t.Error("changing a node did not change the graph hash")
+	}
+
+	renamed := sampleDAG()
+	renamed.Nodes["database"] = renamed.Nodes["db"]
+	delete(renamed.Nodes, "db")
+	if renamed.Hash() == base.Hash() {
+		t.Error("re-keying a node did not change the graph hash")
+	}
+}
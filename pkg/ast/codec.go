@@ -0,0 +1,88 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+// Names of the built-in payload formats.
+const (
+	// FormatGob is the compact Go-only encoding and the default.
+	FormatGob = "gob"
+	// FormatJSON is canonical JSON, for human inspection and tooling.
+	FormatJSON = "json"
+	// FormatProto is Protocol Buffers, described by payload.proto, for
+	// compact cross-language use.
+	FormatProto = "proto"
+)
+
+// Codec encodes graphs in one payload format. Serialize and Deserialize
+// add and strip the header naming the format, so codecs only see the
+// body. Encodings must be canonical: the same graph always encodes to the
+// same bytes.
+type Codec interface {
+	Marshal(d *DAG) ([]byte, error)
+	Unmarshal(body []byte) (*DAG, error)
+}
+
+var codecs = map[string]Codec{
+	FormatGob:   gobCodec{},
+	FormatJSON:  jsonCodec{},
+	FormatProto: protoCodec{},
+}
+
+// RegisterCodec makes a payload format available to SerializeAs and
+// Deserialize under name. It panics if the name is already registered,
+// like gob.Register.
+func RegisterCodec(name string, c Codec) {
+	if _, ok := codecs[name]; ok {
+		panic(fmt.Sprintf("ast: payload format %q registered twice", name))
+	}
+	codecs[name] = c
+}
+
+// Formats returns the names of every registered payload format.
+func Formats() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupCodec returns the codec registered under name.
+func LookupCodec(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown payload format %q", name)
+	}
+	return c, nil
+}
+
+// gobCodec encodes the canonical wire form with encoding/gob.
+type gobCodec struct{}
+
+func (gobCodec) Marshal(d *DAG) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d.wire()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal reads the gob body. Unversioned payloads come in two
+// encodings: the canonical one and, from before it, the DAG struct itself.
+func (gobCodec) Unmarshal(body []byte) (*DAG, error) {
+	var w wireDAG
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&w); err == nil {
+		return w.dag()
+	}
+	var d legacyDAG
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&d); err != nil {
+		return nil, err
+	}
+	return d.wire().dag()
+}
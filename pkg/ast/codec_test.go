@@ -0,0 +1,113 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	for _, format := range []string{FormatGob, FormatJSON, FormatProto} {
+		t.Run(format, func(t *testing.T) {
+			payload, err := sampleDAG().SerializeAs(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, _ := sampleDAG().SerializeAs(format)
+			if !bytes.Equal(payload, again) {
+				t.Error("identical graphs serialized to different bytes")
+			}
+			if got, err := PayloadFormat(payload); err != nil || got != format {
+				t.Errorf("expected format %q, got %q (%v)", format, got, err)
+			}
+
+			decoded, err := Deserialize(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, sampleDAG()) {
+				t.Errorf("round trip changed the graph:\n got %#v\nwant %#v", decoded, sampleDAG())
+			}
+			if decoded.Hash() != sampleDAG().Hash() {
+				t.Error("round trip changed the graph hash")
+			}
+		})
+	}
+}
+
+func TestJSONCodec_Readable(t *testing.T) {
+	payload, _ := sampleDAG().SerializeAs(FormatJSON)
+	_, body, _ := splitHeader(payload)
+	var doc struct {
+		Nodes []struct {
+			Key  string
+			Spec map[string]any
+		}
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("body is not JSON: %v", err)
+	}
+	if len(doc.Nodes) != 3 || doc.Nodes[2].Key != "web" || doc.Nodes[2].Spec["image"] != "nginx" {
+		t.Errorf("unexpected document: %s", body)
+	}
+
+	bad := bytes.Replace(payload, []byte(`"replicas":2`), []byte(`"replicas":"2"`), 1)
+	if _, err := Deserialize(bad); err == nil || !strings.Contains(err.Error(), "Deployment node web") {
+		t.Errorf("expected a mistyped field to fail, got %v", err)
+	}
+}
+
+func TestDeserialize_UnknownFormat(t *testing.T) {
+	header := binary.AppendUvarint([]byte("GOAT"), SchemaVersion)
+	header = binary.AppendUvarint(header, 4)
+	if _, err := Deserialize(append(header, "yaml"...)); err == nil || !strings.Contains(err.Error(), `unknown payload format "yaml"`) {
+		t.Errorf("expected an unknown format error, got %v", err)
+	}
+	if _, err := sampleDAG().SerializeAs("yaml"); err == nil {
+		t.Error("expected serializing to an unknown format to fail")
+	}
+	if _, err := Deserialize(binary.AppendUvarint(header[:5], 9)); err == nil {
+		t.Error("expected a truncated format name to fail")
+	}
+}
+
+type namesCodec struct{}
+
+func (namesCodec) Marshal(d *DAG) ([]byte, error) {
+	return []byte(strings.Join(sortedKeys(d.Nodes), ",")), nil
+}
+
+func (namesCodec) Unmarshal(body []byte) (*DAG, error) {
+	d := &DAG{Nodes: map[string]*Node{}}
+	for _, key := range strings.Split(string(body), ",") {
+		d.Nodes[key] = &Node{Name: key}
+	}
+	return d, nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	RegisterCodec("names", namesCodec{})
+	defer delete(codecs, "names")
+
+	payload, err := sampleDAG().SerializeAs("names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Deserialize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != 3 || decoded.Nodes["web"] == nil {
+		t.Errorf("expected the registered codec to decode, got %v", decoded.Nodes)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a format twice to panic")
+		}
+	}()
+	RegisterCodec(FormatJSON, namesCodec{})
+}
@@ -0,0 +1,171 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+// The JSON form lists nodes sorted by key with their specs encoded through
+// the spec types' json tags. encoding/json writes map keys in sorted order,
+// so the encoding is canonical.
+type jsonDAG struct {
+	Nodes  []jsonNode   `json:"nodes"`
+	Status []jsonStatus `json:"status,omitempty"`
+	Signer string       `json:"signer,omitempty"`
+	// SignedPayload is encoded in base64.
+	SignedPayload []byte `json:"signedPayload,omitempty"`
+}
+
+type jsonNode struct {
+	Key          string          `json:"key"`
+	Kind         string          `json:"kind"`
+	Name         string          `json:"name"`
+	Namespace    string          `json:"namespace,omitempty"`
+	Dependencies []string        `json:"dependencies,omitempty"`
+	Protected    bool            `json:"protected,omitempty"`
+	Spec         json.RawMessage `json:"spec,omitempty"`
+}
+
+type jsonStatus struct {
+	Identity string `json:"identity"`
+	Op       string `json:"op"`
+	Error    string `json:"error"`
+}
+
+// jsonCodec encodes graphs as canonical JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(d *DAG) ([]byte, error) {
+	out := jsonDAG{Nodes: []jsonNode{}, Signer: d.Signer, SignedPayload: d.SignedPayload}
+	for _, key := range sortedKeys(d.Nodes) {
+		n := d.Nodes[key]
+		node := jsonNode{
+			Key:          key,
+			Kind:         n.Kind,
+			Name:         n.Name,
+			Namespace:    n.Namespace,
+			Dependencies: n.Dependencies,
+			Protected:    n.Protected,
+		}
+		if n.Spec != nil {
+			spec, err := marshalJSON(n.Spec)
+			if err != nil {
+				return nil, err
+			}
+			node.Spec = spec
+		}
+		out.Nodes = append(out.Nodes, node)
+	}
+	for _, id := range sortedKeys(d.Status) {
+		s := d.Status[id]
+		out.Status = append(out.Status, jsonStatus{Identity: id, Op: s.Op, Error: s.Error})
+	}
+	return marshalJSON(out)
+}
+
+// marshalJSON encodes v without escaping HTML characters, which only hurts
+// readability, or the trailing newline json.Encoder adds.
+func marshalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Unmarshal decodes each spec into its kind's registered Spec type, so a
+// field of the wrong type is an error. Specs start from the defaults their
+// Decode applies to an empty property map.
+func (jsonCodec) Unmarshal(body []byte) (*DAG, error) {
+	var in jsonDAG
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+	d := &DAG{Nodes: make(map[string]*Node, len(in.Nodes)), Signer: in.Signer, SignedPayload: in.SignedPayload}
+	for _, n := range in.Nodes {
+		node := &Node{
+			Kind:         n.Kind,
+			Name:         n.Name,
+			Namespace:    n.Namespace,
+			Dependencies: n.Dependencies,
+			Protected:    n.Protected,
+		}
+		if newSpec, ok := kinds[n.Kind]; ok {
+			node.Spec = newSpec()
+			node.Spec.Decode(nil)
+		} else if n.Spec != nil {
+			node.Spec = &RawSpec{}
+		}
+		if n.Spec != nil {
+			if err := json.Unmarshal(n.Spec, node.Spec); err != nil {
+				return nil, nodeError(node, err)
+			}
+		}
+		d.Nodes[n.Key] = node
+	}
+	for _, s := range in.Status {
+		if d.Status == nil {
+			d.Status = make(map[string]NodeStatus)
+		}
+		d.Status[s.Identity] = NodeStatus{Op: s.Op, Error: s.Error}
+	}
+	return d, nil
+}
+
+// UnmarshalJSON decodes Content as the builders produce it: integers as
+// int64 and other numbers as float64.
+func (s *CustomSpec) UnmarshalJSON(data []byte) error {
+	type plain CustomSpec
+	if err := decodeJSONNumbers(data, (*plain)(s)); err != nil {
+		return err
+	}
+	if s.Content != nil {
+		s.Content = jsonNumbers(s.Content).(map[string]any)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes the properties of an unregistered kind as plain
+// JSON values, with integers as int64 and other numbers as float64.
+func (s *RawSpec) UnmarshalJSON(data []byte) error {
+	var props map[string]any
+	if err := decodeJSONNumbers(data, &props); err != nil {
+		return err
+	}
+	if props != nil {
+		props = jsonNumbers(props).(map[string]any)
+	}
+	*s = props
+	return nil
+}
+
+func decodeJSONNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func jsonNumbers(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			t[k] = jsonNumbers(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = jsonNumbers(e)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil && !math.IsInf(f, 0) {
+			return f
+		}
+		return t.String()
+	}
+	return v
+}
@@ -0,0 +1,156 @@
+package ast
+
+import "errors"
+
+func init() {
+	RegisterKind("Deployment", func() Spec { return &DeploymentSpec{} })
+	RegisterKind("Service", func() Spec { return &ServiceSpec{} })
+	RegisterKind("Custom", func() Spec { return &CustomSpec{} })
+}
+
+// DeploymentSpec is the spec of a Deployment node.
+type DeploymentSpec struct {
+	Image    string `json:"image"`
+	Replicas int32  `json:"replicas"`
+	// Labels are metadata only. Selector and PodLabels are nil in payloads
+	// compiled before selectors were split from labels; renderers then
+	// use Labels for both.
+	Labels    map[string]string `json:"labels"`
+	Selector  map[string]string `json:"selector"`
+	PodLabels map[string]string `json:"podLabels"`
+	Ports     []ContainerPort   `json:"ports"`
+}
+
+func (s *DeploymentSpec) Encode() map[string]any {
+	props := map[string]any{
+		"image":    s.Image,
+		"replicas": s.Replicas,
+		"labels":   s.Labels,
+		"ports":    s.Ports,
+	}
+	if s.Selector != nil {
+		props["selector"] = s.Selector
+	}
+	if s.PodLabels != nil {
+		props["podLabels"] = s.PodLabels
+	}
+	return props
+}
+
+func (s *DeploymentSpec) Decode(props map[string]any) error {
+	s.Replicas = 1
+	return errors.Join(
+		prop(props, "image", &s.Image),
+		prop(props, "replicas", &s.Replicas),
+		prop(props, "labels", &s.Labels),
+		prop(props, "selector", &s.Selector),
+		prop(props, "podLabels", &s.PodLabels),
+		prop(props, "ports", &s.Ports),
+	)
+}
+
+// ServiceSpec is the spec of a Service node.
+type ServiceSpec struct {
+	Type  string        `json:"type"`
+	Ports []ServicePort `json:"ports"`
+	// Labels are metadata only. Selector is nil for ExternalName Services
+	// and in payloads compiled before selectors were split from labels,
+	// where renderers route on Labels instead.
+	Labels       map[string]string `json:"labels"`
+	Selector     map[string]string `json:"selector"`
+	ClusterIP    string            `json:"clusterIP,omitempty"`
+	ExternalName string            `json:"externalName,omitempty"`
+	// SessionAffinity is "ClientIP" or empty; a zero timeout keeps the
+	// Kubernetes default.
+	SessionAffinity        string `json:"sessionAffinity,omitempty"`
+	SessionAffinityTimeout int32  `json:"sessionAffinityTimeout,omitempty"`
+}
+
+func (s *ServiceSpec) Encode() map[string]any {
+	props := map[string]any{
+		"type":   s.Type,
+		"ports":  s.Ports,
+		"labels": s.Labels,
+	}
+	if s.ClusterIP != "" {
+		props["clusterIP"] = s.ClusterIP
+	}
+	if s.ExternalName != "" {
+		props["externalName"] = s.ExternalName
+	}
+	if s.Selector != nil {
+		props["selector"] = s.Selector
+	}
+	if s.SessionAffinity != "" {
+		props["sessionAffinity"] = s.SessionAffinity
+	}
+	if s.SessionAffinityTimeout != 0 {
+		props["sessionAffinityTimeout"] = s.SessionAffinityTimeout
+	}
+	return props
+}
+
+func (s *ServiceSpec) Decode(props map[string]any) error {
+	var port, targetPort int32
+	err := errors.Join(
+		prop(props, "type", &s.Type),
+		prop(props, "ports", &s.Ports),
+		prop(props, "labels", &s.Labels),
+		prop(props, "selector", &s.Selector),
+		prop(props, "clusterIP", &s.ClusterIP),
+		prop(props, "externalName", &s.ExternalName),
+		prop(props, "sessionAffinity", &s.SessionAffinity),
+		prop(props, "sessionAffinityTimeout", &s.SessionAffinityTimeout),
+		// Payloads compiled before named ports carry a single pair.
+		prop(props, "port", &port),
+		prop(props, "targetPort", &targetPort),
+	)
+	if _, ok := props["ports"]; !ok && port != 0 {
+		s.Ports = []ServicePort{{Port: port, TargetPort: targetPort}}
+	}
+	return err
+}
+
+// CustomSpec is the spec of a Custom node: any resource the DSL has no
+// dedicated builder for.
+type CustomSpec struct {
+	APIVersion  string            `json:"apiVersion"`
+	Kind        string            `json:"kind"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Content holds every top-level field other than apiVersion, kind and
+	// metadata, as plain JSON values.
+	Content map[string]any `json:"content,omitempty"`
+	// Error records why the builder could not encode the spec; such nodes
+	// are rejected by the compiler and never rendered.
+	Error string `json:"error,omitempty"`
+}
+
+func (s *CustomSpec) Encode() map[string]any {
+	props := map[string]any{
+		"apiVersion": s.APIVersion,
+		"kind":       s.Kind,
+		"labels":     s.Labels,
+	}
+	if len(s.Annotations) > 0 {
+		props["annotations"] = s.Annotations
+	}
+	if s.Content != nil {
+		props["content"] = s.Content
+	}
+	if s.Error != "" {
+		props["error"] = s.Error
+	}
+	return props
+}
+
+func (s *CustomSpec) Decode(props map[string]any) error {
+	return errors.Join(
+		prop(props, "apiVersion", &s.APIVersion),
+		prop(props, "kind", &s.Kind),
+		prop(props, "labels", &s.Labels),
+		prop(props, "annotations", &s.Annotations),
+		prop(props, "content", &s.Content),
+		prop(props, "error", &s.Error),
+	)
+}
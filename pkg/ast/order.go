@@ -0,0 +1,49 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TopologicalOrder returns the node keys with every dependency before its
+// dependents, breaking ties by key so the order is stable.
+func (d *DAG) TopologicalOrder() ([]string, error) {
+	keys := make([]string, 0, len(d.Nodes))
+	for key := range d.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	order := make([]string, 0, len(keys))
+	state := make(map[string]int) // 0 unvisited, 1 visiting, 2 done
+	var visit func(key string) error
+	visit = func(key string) error {
+		node, ok := d.Nodes[key]
+		if !ok {
+			return fmt.Errorf("unknown dependency %q", key)
+		}
+		switch state[key] {
+		case 1:
+			return fmt.Errorf("dependency cycle through %q", key)
+		case 2:
+			return nil
+		}
+		state[key] = 1
+		deps := append([]string(nil), node.Dependencies...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[key] = 2
+		order = append(order, key)
+		return nil
+	}
+	for _, key := range keys {
+		if err := visit(key); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,20 @@
+package ast
+
+// ServicePort describes a single port exposed by a Service.
+// TargetPortName, when set, refers to a named ContainerPort on the attached
+// Deployment and takes precedence over the numeric TargetPort.
+type ServicePort struct {
+	Name           string `json:"name,omitempty"`
+	Protocol       string `json:"protocol,omitempty"`
+	Port           int32  `json:"port"`
+	TargetPort     int32  `json:"targetPort,omitempty"`
+	TargetPortName string `json:"targetPortName,omitempty"`
+	NodePort       int32  `json:"nodePort,omitempty"`
+}
+
+// ContainerPort describes a port opened by a Deployment's container.
+type ContainerPort struct {
+	Name     string `json:"name,omitempty"`
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+}
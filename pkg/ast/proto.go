@@ -0,0 +1,498 @@
+package ast
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoCodec encodes graphs as the DAG message of payload.proto. Nodes,
+// statuses and map entries are written in sorted order, so the encoding
+// is canonical.
+type protoCodec struct{}
+
+func (protoCodec) Marshal(d *DAG) ([]byte, error) {
+	var b []byte
+	for _, key := range sortedKeys(d.Nodes) {
+		node, err := appendProtoNode(nil, key, d.Nodes[key])
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoBytes(b, 1, node)
+	}
+	for _, id := range sortedKeys(d.Status) {
+		s := d.Status[id]
+		var status []byte
+		status = appendProtoString(status, 1, id)
+		status = appendProtoString(status, 2, s.Op)
+		status = appendProtoString(status, 3, s.Error)
+		b = appendProtoBytes(b, 2, status)
+	}
+	b = appendProtoString(b, 3, d.Signer)
+	if len(d.SignedPayload) > 0 {
+		b = appendProtoBytes(b, 4, d.SignedPayload)
+	}
+	return b, nil
+}
+
+func appendProtoNode(b []byte, key string, n *Node) ([]byte, error) {
+	b = appendProtoString(b, 1, key)
+	b = appendProtoString(b, 2, n.Kind)
+	b = appendProtoString(b, 3, n.Name)
+	b = appendProtoString(b, 4, n.Namespace)
+	for _, dep := range n.Dependencies {
+		b = appendProtoBytes(b, 5, []byte(dep))
+	}
+	if n.Protected {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if n.Spec == nil {
+		return b, nil
+	}
+	props, err := appendProtoProperties(nil, 7, n.Spec.Encode())
+	if err != nil {
+		return nil, nodeError(n, err)
+	}
+	return append(b, props...), nil
+}
+
+// appendProtoProperties appends a Property field numbered num for every
+// entry of props, in key order.
+func appendProtoProperties(b []byte, num protowire.Number, props map[string]any) ([]byte, error) {
+	for _, k := range sortedKeys(props) {
+		value, err := appendProtoValue(nil, props[k])
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %w", k, err)
+		}
+		var entry []byte
+		entry = appendProtoString(entry, 1, k)
+		entry = appendProtoBytes(entry, 2, value)
+		b = appendProtoBytes(b, num, entry)
+	}
+	return b, nil
+}
+
+// appendProtoValue appends the Value message for v. Oneof members are
+// written even when they hold their zero value, since their presence is
+// what records the type.
+func appendProtoValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return appendProtoBytes(b, 1, []byte(v)), nil
+	case bool:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v)), nil
+	case int32:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(int64(v))), nil
+	case int64:
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(v)), nil
+	case float64:
+		b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(v)), nil
+	case map[string]any:
+		entries, err := appendProtoProperties(nil, 1, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoBytes(b, 6, entries), nil
+	case map[string]string:
+		var entries []byte
+		for _, k := range sortedKeys(v) {
+			var entry []byte
+			entry = appendProtoString(entry, 1, k)
+			entry = appendProtoString(entry, 2, v[k])
+			entries = appendProtoBytes(entries, 1, entry)
+		}
+		return appendProtoBytes(b, 7, entries), nil
+	case []any:
+		var values []byte
+		for _, e := range v {
+			value, err := appendProtoValue(nil, e)
+			if err != nil {
+				return nil, err
+			}
+			values = appendProtoBytes(values, 1, value)
+		}
+		return appendProtoBytes(b, 8, values), nil
+	case []string:
+		var values []byte
+		for _, e := range v {
+			values = appendProtoBytes(values, 1, []byte(e))
+		}
+		return appendProtoBytes(b, 9, values), nil
+	case []ServicePort:
+		var ports []byte
+		for _, p := range v {
+			var port []byte
+			port = appendProtoString(port, 1, p.Name)
+			port = appendProtoString(port, 2, p.Protocol)
+			port = appendProtoInt32(port, 3, p.Port)
+			port = appendProtoInt32(port, 4, p.TargetPort)
+			port = appendProtoString(port, 5, p.TargetPortName)
+			port = appendProtoInt32(port, 6, p.NodePort)
+			ports = appendProtoBytes(ports, 1, port)
+		}
+		return appendProtoBytes(b, 10, ports), nil
+	case []ContainerPort:
+		var ports []byte
+		for _, p := range v {
+			var port []byte
+			port = appendProtoString(port, 1, p.Name)
+			port = appendProtoInt32(port, 2, p.Port)
+			port = appendProtoString(port, 3, p.Protocol)
+			ports = appendProtoBytes(ports, 1, port)
+		}
+		return appendProtoBytes(b, 11, ports), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendProtoString and appendProtoInt32 skip zero values like proto3.
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendProtoBytes(b, num, []byte(v))
+}
+
+func appendProtoInt32(b []byte, num protowire.Number, v int32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(v)))
+}
+
+func (protoCodec) Unmarshal(body []byte) (*DAG, error) {
+	d := &DAG{Nodes: make(map[string]*Node)}
+	err := eachProtoField(body, func(f protoField) error {
+		switch f.num {
+		case 1:
+			data, err := f.message()
+			if err != nil {
+				return err
+			}
+			key, node, err := parseProtoNode(data)
+			if err != nil {
+				return err
+			}
+			d.Nodes[key] = node
+		case 2:
+			data, err := f.message()
+			if err != nil {
+				return err
+			}
+			var id string
+			var s NodeStatus
+			err = eachProtoField(data, func(f protoField) (err error) {
+				switch f.num {
+				case 1:
+					id, err = f.string()
+				case 2:
+					s.Op, err = f.string()
+				case 3:
+					s.Error, err = f.string()
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if d.Status == nil {
+				d.Status = make(map[string]NodeStatus)
+			}
+			d.Status[id] = s
+		case 3:
+			signer, err := f.string()
+			if err != nil {
+				return err
+			}
+			d.Signer = signer
+		case 4:
+			signed, err := f.message()
+			if err != nil {
+				return err
+			}
+			d.SignedPayload = bytes.Clone(signed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func parseProtoNode(data []byte) (string, *Node, error) {
+	var key string
+	n := &Node{}
+	var props map[string]any
+	err := eachProtoField(data, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			key, err = f.string()
+		case 2:
+			n.Kind, err = f.string()
+		case 3:
+			n.Name, err = f.string()
+		case 4:
+			n.Namespace, err = f.string()
+		case 5:
+			var dep string
+			dep, err = f.string()
+			n.Dependencies = append(n.Dependencies, dep)
+		case 6:
+			n.Protected, err = f.bool()
+		case 7:
+			if props == nil {
+				props = make(map[string]any)
+			}
+			err = parseProtoProperty(f, props)
+		}
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if err := decodeNode(n, props); err != nil {
+		return "", nil, err
+	}
+	return key, n, nil
+}
+
+func parseProtoProperty(f protoField, into map[string]any) error {
+	data, err := f.message()
+	if err != nil {
+		return err
+	}
+	var key string
+	var value any
+	err = eachProtoField(data, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			key, err = f.string()
+		case 2:
+			var v []byte
+			if v, err = f.message(); err == nil {
+				value, err = parseProtoValue(v)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("property %q has no value", key)
+	}
+	into[key] = value
+	return nil
+}
+
+func parseProtoValue(data []byte) (any, error) {
+	var value any
+	err := eachProtoField(data, func(f protoField) error {
+		var err error
+		switch f.num {
+		case 1:
+			value, err = f.string()
+		case 2:
+			value, err = f.bool()
+		case 3:
+			var v int64
+			v, err = f.int64()
+			value = int32(v)
+		case 4:
+			value, err = f.int64()
+		case 5:
+			value, err = f.double()
+		case 6:
+			m := make(map[string]any)
+			err = f.each(func(f protoField) error { return parseProtoProperty(f, m) })
+			value = m
+		case 7:
+			m := make(map[string]string)
+			err = f.each(func(f protoField) error {
+				data, err := f.message()
+				if err != nil {
+					return err
+				}
+				var k, v string
+				err = eachProtoField(data, func(f protoField) (err error) {
+					switch f.num {
+					case 1:
+						k, err = f.string()
+					case 2:
+						v, err = f.string()
+					}
+					return err
+				})
+				m[k] = v
+				return err
+			})
+			value = m
+		case 8:
+			list := []any{}
+			err = f.each(func(f protoField) error {
+				data, err := f.message()
+				if err != nil {
+					return err
+				}
+				e, err := parseProtoValue(data)
+				list = append(list, e)
+				return err
+			})
+			value = list
+		case 9:
+			list := []string{}
+			err = f.each(func(f protoField) error {
+				e, err := f.string()
+				list = append(list, e)
+				return err
+			})
+			value = list
+		case 10:
+			var ports []ServicePort
+			err = f.each(func(f protoField) error {
+				var p ServicePort
+				err := f.each(func(f protoField) (err error) {
+					switch f.num {
+					case 1:
+						p.Name, err = f.string()
+					case 2:
+						p.Protocol, err = f.string()
+					case 3:
+						p.Port, err = f.int32()
+					case 4:
+						p.TargetPort, err = f.int32()
+					case 5:
+						p.TargetPortName, err = f.string()
+					case 6:
+						p.NodePort, err = f.int32()
+					}
+					return err
+				})
+				ports = append(ports, p)
+				return err
+			})
+			value = ports
+		case 11:
+			var ports []ContainerPort
+			err = f.each(func(f protoField) error {
+				var p ContainerPort
+				err := f.each(func(f protoField) (err error) {
+					switch f.num {
+					case 1:
+						p.Name, err = f.string()
+					case 2:
+						p.Port, err = f.int32()
+					case 3:
+						p.Protocol, err = f.string()
+					}
+					return err
+				})
+				ports = append(ports, p)
+				return err
+			})
+			value = ports
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, errors.New("value of unknown type")
+	}
+	return value, nil
+}
+
+// protoField is one field read from a message: a varint or fixed64 value
+// in v, or the contents of a length-delimited field in data.
+type protoField struct {
+	num  protowire.Number
+	typ  protowire.Type
+	v    uint64
+	data []byte
+}
+
+// eachProtoField calls fn for every field of a message. Fields of unknown
+// numbers reach fn too and are ignored there, so newer writers may add
+// fields.
+func eachProtoField(b []byte, fn func(protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f protoField) expect(typ protowire.Type) error {
+	if f.typ != typ {
+		return fmt.Errorf("field %d has wire type %d, expected %d", f.num, f.typ, typ)
+	}
+	return nil
+}
+
+func (f protoField) message() ([]byte, error) {
+	return f.data, f.expect(protowire.BytesType)
+}
+
+func (f protoField) each(fn func(protoField) error) error {
+	if err := f.expect(protowire.BytesType); err != nil {
+		return err
+	}
+	return eachProtoField(f.data, fn)
+}
+
+func (f protoField) string() (string, error) {
+	return string(f.data), f.expect(protowire.BytesType)
+}
+
+func (f protoField) bool() (bool, error) {
+	return protowire.DecodeBool(f.v), f.expect(protowire.VarintType)
+}
+
+func (f protoField) int32() (int32, error) {
+	return int32(f.v), f.expect(protowire.VarintType)
+}
+
+func (f protoField) int64() (int64, error) {
+	return int64(f.v), f.expect(protowire.VarintType)
+}
+
+func (f protoField) double() (float64, error) {
+	return math.Float64frombits(f.v), f.expect(protowire.Fixed64Type)
+}
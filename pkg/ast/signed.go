@@ -0,0 +1,60 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+)
+
+// FormatSigned names payloads wrapped in a signature Envelope. It is not a
+// codec: Deserialize decodes the payload an envelope wraps without checking
+// the signature, which is left to the signing package.
+const FormatSigned = "signed"
+
+// Envelope is a payload together with a signature over its exact bytes.
+// Its body is a protobuf message with the fields numbered in order.
+type Envelope struct {
+	// Signer identifies the key that made the signature.
+	Signer    string
+	Algorithm string
+	Signature []byte
+	Payload   []byte
+}
+
+// Seal encodes the envelope as a payload of format FormatSigned.
+func (e *Envelope) Seal() []byte {
+	b := appendHeader(nil, FormatSigned)
+	b = appendProtoString(b, 1, e.Signer)
+	b = appendProtoString(b, 2, e.Algorithm)
+	b = appendProtoBytes(b, 3, e.Signature)
+	return appendProtoBytes(b, 4, e.Payload)
+}
+
+// OpenEnvelope decodes a FormatSigned payload. It returns nil and no error
+// for payloads that are not signed.
+func OpenEnvelope(data []byte) (*Envelope, error) {
+	h, body, err := splitHeader(data)
+	if err != nil || h.format != FormatSigned {
+		return nil, err
+	}
+	e := &Envelope{}
+	err = eachProtoField(body, func(f protoField) (err error) {
+		switch f.num {
+		case 1:
+			e.Signer, err = f.string()
+		case 2:
+			e.Algorithm, err = f.string()
+		case 3:
+			e.Signature, err = f.message()
+		case 4:
+			e.Payload, err = f.message()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signed payload: %w", err)
+	}
+	if format, err := PayloadFormat(e.Payload); err == nil && format == FormatSigned {
+		return nil, errors.New("signed payload wraps another signed payload")
+	}
+	return e, nil
+}
@@ -0,0 +1,71 @@
+package ast
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEnvelope_SealOpen(t *testing.T) {
+	payload, _ := sampleDAG().Serialize()
+	env := &Envelope{Signer: "ci", Algorithm: "ed25519", Signature: []byte{1, 2, 3}, Payload: payload}
+	sealed := env.Seal()
+	if format, _ := PayloadFormat(sealed); format != FormatSigned {
+		t.Errorf("expected format %q, got %q", FormatSigned, format)
+	}
+
+	opened, err := OpenEnvelope(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opened, env) {
+		t.Errorf("OpenEnvelope = %+v, want %+v", opened, env)
+	}
+	decoded, err := Deserialize(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != sampleDAG().Hash() || decoded.Signer != "" {
+		t.Error("expected a signed payload to decode to the graph it wraps, with no signer")
+	}
+
+	if opened, err := OpenEnvelope(payload); opened != nil || err != nil {
+		t.Errorf("expected an unsigned payload to have no envelope, got %v, %v", opened, err)
+	}
+	nested := (&Envelope{Signer: "ci", Payload: sealed}).Seal()
+	if _, err := Deserialize(nested); err == nil {
+		t.Error("expected nested envelopes to be refused")
+	}
+	if _, err := Deserialize(sealed[:len(sealed)-3]); err == nil {
+		t.Error("expected a truncated envelope to fail")
+	}
+}
+
+func TestCodecs_Signer(t *testing.T) {
+	dag := sampleDAG()
+	dag.Signer = "ci"
+	dag.SignedPayload = []byte("signed payload")
+	for _, format := range []string{FormatGob, FormatJSON, FormatProto} {
+		payload, err := dag.SerializeAs(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Deserialize(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Signer != "ci" {
+			t.Errorf("%s: signer lost in round trip", format)
+		}
+		if string(decoded.SignedPayload) != "signed payload" {
+			t.Errorf("%s: signed payload lost in round trip", format)
+		}
+		if decoded.Hash() != sampleDAG().Hash() {
+			t.Errorf("%s: signer changed the graph hash", format)
+		}
+		unsigned, _ := sampleDAG().SerializeAs(format)
+		if bytes.Equal(payload, unsigned) {
+			t.Errorf("%s: signer not encoded", format)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package ast
+
+import "fmt"
+
+// Spec is the typed content of a node. Every node kind has its own Spec
+// type, registered with RegisterKind; payloads carry it as a property map.
+type Spec interface {
+	// Encode returns the spec as the property map written to payloads.
+	Encode() map[string]any
+	// Decode fills the spec from a decoded property map. A property of the
+	// wrong type is an error, never a silent default.
+	Decode(props map[string]any) error
+}
+
+var kinds = map[string]func() Spec{}
+
+// RegisterKind registers the Spec type of a node kind, so payloads holding
+// nodes of that kind decode to it. It panics if the kind is already
+// registered, like gob.Register.
+func RegisterKind(kind string, newSpec func() Spec) {
+	if _, ok := kinds[kind]; ok {
+		panic(fmt.Sprintf("ast: node kind %q registered twice", kind))
+	}
+	kinds[kind] = newSpec
+}
+
+// RawSpec holds the properties of a node whose kind has no registered Spec
+// type, so graphs produced by other tools still round-trip unchanged.
+type RawSpec map[string]any
+
+func (s RawSpec) Encode() map[string]any { return s }
+
+func (s *RawSpec) Decode(props map[string]any) error {
+	*s = props
+	return nil
+}
+
+// SpecOf returns the node's spec as T, failing when the node carries a spec
+// of another type.
+func SpecOf[T Spec](n *Node) (T, error) {
+	spec, ok := n.Spec.(T)
+	if !ok {
+		return spec, fmt.Errorf("%s node %s: expected a %T spec, got %T", n.Kind, n.Name, spec, n.Spec)
+	}
+	return spec, nil
+}
+
+// protectedProperty is the property recording Node.Protected in payloads.
+const protectedProperty = "protected"
+
+// encodeNode returns the property map of a node, including Protected.
+func encodeNode(n *Node) map[string]any {
+	var props map[string]any
+	if n.Spec != nil {
+		props = n.Spec.Encode()
+	}
+	if !n.Protected {
+		return props
+	}
+	out := make(map[string]any, len(props)+1)
+	for k, v := range props {
+		out[k] = v
+	}
+	out[protectedProperty] = true
+	return out
+}
+
+// decodeNode fills n.Spec and n.Protected from a decoded property map.
+func decodeNode(n *Node, props map[string]any) error {
+	if err := prop(props, protectedProperty, &n.Protected); err != nil {
+		return nodeError(n, err)
+	}
+	if _, ok := props[protectedProperty]; ok {
+		props = copyWithout(props, protectedProperty)
+	}
+	newSpec, ok := kinds[n.Kind]
+	if !ok {
+		if props != nil {
+			raw := RawSpec(props)
+			n.Spec = &raw
+		}
+		return nil
+	}
+	spec := newSpec()
+	if err := spec.Decode(props); err != nil {
+		return nodeError(n, err)
+	}
+	n.Spec = spec
+	return nil
+}
+
+func nodeError(n *Node, err error) error {
+	return fmt.Errorf("%s node %s: %w", n.Kind, n.Name, err)
+}
+
+func copyWithout(props map[string]any, key string) map[string]any {
+	out := make(map[string]any, len(props))
+	for k, v := range props {
+		if k != key {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// prop stores the property key in dst, leaving dst untouched when the
+// property is absent and failing when it holds another type.
+func prop[T any](props map[string]any, key string, dst *T) error {
+	v, ok := props[key]
+	if !ok {
+		return nil
+	}
+	t, ok := v.(T)
+	if !ok {
+		return fmt.Errorf("property %q: expected %T, got %T", key, t, v)
+	}
+	*dst = t
+	return nil
+}
@@ -0,0 +1,84 @@
+package ast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeserialize_WrongPropertyType(t *testing.T) {
+	dag := &DAG{Nodes: map[string]*Node{
+		"web": {Kind: "Deployment", Name: "web", Spec: &RawSpec{"image": "nginx", "replicas": int64(2)}},
+	}}
+	payload, err := dag.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Deserialize(payload)
+	if err == nil || !strings.Contains(err.Error(), `property "replicas": expected int32, got int64`) {
+		t.Errorf("expected a type error naming the property, got %v", err)
+	}
+}
+
+func TestDeserialize_LegacyServicePort(t *testing.T) {
+	dag := &DAG{Nodes: map[string]*Node{
+		"api": {Kind: "Service", Name: "api", Spec: &RawSpec{"port": int32(80), "targetPort": int32(8080)}},
+	}}
+	payload, _ := dag.Serialize()
+	decoded, err := Deserialize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := SpecOf[*ServiceSpec](decoded.Nodes["api"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ServicePort{{Port: 80, TargetPort: 8080}}; !reflect.DeepEqual(spec.Ports, want) {
+		t.Errorf("expected %v, got %v", want, spec.Ports)
+	}
+}
+
+func TestDeserialize_UnregisteredKind(t *testing.T) {
+	dag := &DAG{Nodes: map[string]*Node{
+		"x": {Kind: "Mystery", Name: "x", Protected: true, Spec: &RawSpec{"size": int64(3)}},
+	}}
+	payload, _ := dag.Serialize()
+	decoded, err := Deserialize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, dag) {
+		t.Errorf("unregistered kind did not round-trip: %#v", decoded.Nodes["x"])
+	}
+	if _, err := SpecOf[*DeploymentSpec](decoded.Nodes["x"]); err == nil {
+		t.Error("expected SpecOf to reject a spec of another type")
+	}
+}
+
+type widgetSpec struct{ Size int64 }
+
+func (s *widgetSpec) Encode() map[string]any { return map[string]any{"size": s.Size} }
+
+func (s *widgetSpec) Decode(props map[string]any) error { return prop(props, "size", &s.Size) }
+
+func TestRegisterKind(t *testing.T) {
+	RegisterKind("Widget", func() Spec { return &widgetSpec{} })
+	defer delete(kinds, "Widget")
+
+	dag := &DAG{Nodes: map[string]*Node{"w": {Kind: "Widget", Name: "w", Spec: &widgetSpec{Size: 3}}}}
+	payload, _ := dag.Serialize()
+	decoded, err := Deserialize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec, err := SpecOf[*widgetSpec](decoded.Nodes["w"]); err != nil || spec.Size != 3 {
+		t.Errorf("expected the registered spec type, got %#v (%v)", decoded.Nodes["w"].Spec, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a kind twice to panic")
+		}
+	}()
+	RegisterKind("Widget", func() Spec { return &widgetSpec{} })
+}
@@ -1,8 +1,8 @@
 package ast
 
 import (
-	"bytes"
 	"encoding/gob"
+	"fmt"
 )
 
 func init() {
@@ -11,6 +11,8 @@ func init() {
 	gob.Register([]any{})
 	gob.Register([]string{})
 	gob.Register(int32(0))
+	gob.Register([]ServicePort{})
+	gob.Register([]ContainerPort{})
 }
 
 // Node represents a generic Kubernetes resource intent.
@@ -19,31 +21,101 @@ type Node struct {
 	Name         string
 	Namespace    string
 	Dependencies []string
-	Properties   map[string]any
+	// Spec is the kind's registered Spec type, or a *RawSpec for kinds
+	// without one.
+	Spec Spec
+	// Protected keeps the engine from ever deleting the node's object.
+	Protected bool
+}
+
+// ObjectKind returns the Kubernetes kind the node renders to. Custom nodes
+// carry it in their spec.
+func (n *Node) ObjectKind() string {
+	if spec, ok := n.Spec.(*CustomSpec); ok && n.Kind == "Custom" && spec.Kind != "" {
+		return spec.Kind
+	}
+	return n.Kind
+}
+
+// Identity uniquely names the Kubernetes object a node manages.
+func (n *Node) Identity() string {
+	return n.ObjectKind() + "/" + n.Namespace + "/" + n.Name
 }
 
 // DAG represents the complete infrastructure graph.
 type DAG struct {
 	Nodes map[string]*Node
+	// Status is recorded only in state, keyed by node Identity. Nodes
+	// without an entry were applied successfully.
+	Status map[string]NodeStatus
+	// Signer is recorded only in state: the identity of the key that
+	// signed the payload last applied, if its signature was verified. It
+	// is not part of Hash.
+	Signer string
+	// SignedPayload is recorded only in state alongside Signer: the
+	// FormatSigned payload last applied, so its signature can be verified
+	// again instead of trusting Signer. It is not part of Hash.
+	SignedPayload []byte
 }
 
-// Serialize converts the DAG to a compact binary format using Gob.
+// NodeStatus records a failed operation on a node's object. The node kept
+// in state is then the version last known to exist in the cluster, if any.
+type NodeStatus struct {
+	// Op is the operation that failed: "create", "update", "delete" or "apply".
+	Op    string
+	Error string
+}
+
+// Serialize encodes the DAG as a gob payload: a header carrying
+// SchemaVersion and the format name, then the body. The encoding is
+// canonical: nodes, statuses and map keys are written in sorted order, so
+// identical graphs serialize to identical bytes.
 func (d *DAG) Serialize() ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(d); err != nil {
+	return d.SerializeAs(FormatGob)
+}
+
+// SerializeAs encodes the DAG in the named payload format.
+func (d *DAG) SerializeAs(format string) ([]byte, error) {
+	codec, err := LookupCodec(format)
+	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	body, err := codec.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendHeader(nil, format), body...), nil
 }
 
-// Deserialize restores the DAG from gob binary format.
+// Deserialize restores the DAG from a payload of any registered format,
+// detected from its header, migrating payloads of older schema versions.
+// Payloads of a newer schema fail with ErrNewerSchema. Signed payloads
+// decode to the graph they wrap; their signature is not checked.
 func Deserialize(data []byte) (*DAG, error) {
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	var d DAG
-	if err := dec.Decode(&d); err != nil {
+	h, body, err := splitHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.version > SchemaVersion {
+		return nil, fmt.Errorf("%w: payload schema %d, supported up to %d", ErrNewerSchema, h.version, SchemaVersion)
+	}
+	if h.format == FormatSigned {
+		env, err := OpenEnvelope(data)
+		if err != nil {
+			return nil, err
+		}
+		return Deserialize(env.Payload)
+	}
+	codec, err := LookupCodec(h.format)
+	if err != nil {
+		return nil, err
+	}
+	dag, err := codec.Unmarshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(dag, h.version); err != nil {
 		return nil, err
 	}
-	return &d, nil
+	return dag, nil
 }
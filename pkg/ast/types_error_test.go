@@ -6,7 +6,7 @@ func TestSerialize_Error(t *testing.T) {
 	dag := &DAG{
 		Nodes: map[string]*Node{
 			"bad": {
-				Properties: map[string]any{"chan": make(chan int)},
+				Spec: &RawSpec{"chan": make(chan int)},
 			},
 		},
 	}
@@ -12,9 +12,9 @@ func TestSerializeDeserialize(t *testing.T) {
 				Kind:      "Service",
 				Name:      "test-service",
 				Namespace: "default",
-				Properties: map[string]any{
-					"port":   int32(80),
-					"labels": map[string]string{"env": "test"},
+				Spec: &ServiceSpec{
+					Ports:  []ServicePort{{Port: 80}},
+					Labels: map[string]string{"env": "test"},
 				},
 			},
 		},
@@ -36,8 +36,8 @@ func TestSerializeDeserialize(t *testing.T) {
 	if decoded.Nodes["test-node"].Name != "test-service" {
 		t.Errorf("Expected Name test-service, got %v", decoded.Nodes["test-node"].Name)
 	}
-	if !reflect.DeepEqual(dag.Nodes["test-node"].Properties, decoded.Nodes["test-node"].Properties) {
-		t.Errorf("Properties mismatch after deserialization")
+	if !reflect.DeepEqual(dag.Nodes["test-node"].Spec, decoded.Nodes["test-node"].Spec) {
+		t.Errorf("Spec mismatch after deserialization")
 	}
 }
 
@@ -47,3 +47,46 @@ func TestDeserialize_Error(t *testing.T) {
 		t.Error("Expected error when deserializing garbage data")
 	}
 }
+
+func TestTopologicalOrder(t *testing.T) {
+	dag := &DAG{Nodes: map[string]*Node{
+		"web": {Name: "web", Dependencies: []string{"db", "api"}},
+		"api": {Name: "api", Dependencies: []string{"db"}},
+		"db":  {Name: "db"},
+		"aux": {Name: "aux"},
+	}}
+	order, err := dag.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("TopologicalOrder failed: %v", err)
+	}
+	want := []string{"db", "api", "aux", "web"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+
+	dag.Nodes["db"].Dependencies = []string{"web"}
+	if _, err := dag.TopologicalOrder(); err == nil {
+		t.Error("expected a cycle error")
+	}
+	dag.Nodes["db"].Dependencies = []string{"missing"}
+	if _, err := dag.TopologicalOrder(); err == nil {
+		t.Error("expected an unknown dependency error")
+	}
+}
+
+func TestProtectedRoundTrip(t *testing.T) {
+	dag := &DAG{Nodes: map[string]*Node{
+		"db": {Kind: "Deployment", Name: "db", Protected: true},
+	}}
+	payload, err := dag.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Deserialize(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Nodes["db"].Protected {
+		t.Error("protected flag lost in serialization")
+	}
+}
@@ -0,0 +1,108 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the payload schema Serialize writes. Payloads of older
+// schemas are upgraded on Deserialize by the registered migrations.
+//
+//	1: no header; the version of every payload written before versioning
+//	2: header added, node shape unchanged
+//	3: header names the payload format
+const SchemaVersion = 3
+
+// payloadMagic starts every versioned payload, followed by the schema
+// version as a uvarint and, from version 3, the format name as a
+// uvarint-prefixed string.
+var payloadMagic = []byte("GOAT")
+
+// ErrNewerSchema is returned when a payload was written by a newer
+// kube-goAT than the one reading it.
+var ErrNewerSchema = errors.New("payload schema is newer than this version of kube-goAT supports")
+
+// Migration upgrades a DAG decoded at one schema version to the next.
+type Migration func(*DAG) error
+
+var migrations = map[int]Migration{
+	1: func(*DAG) error { return nil },
+	2: func(*DAG) error { return nil },
+}
+
+// RegisterMigration registers the migration from schema version from to
+// from+1. It panics if one is already registered, like gob.Register.
+func RegisterMigration(from int, m Migration) {
+	if _, ok := migrations[from]; ok {
+		panic(fmt.Sprintf("ast: migration from schema %d registered twice", from))
+	}
+	migrations[from] = m
+}
+
+// PayloadVersion returns the schema version of a payload without decoding
+// it. Payloads without a header are version 1.
+func PayloadVersion(data []byte) (int, error) {
+	h, _, err := splitHeader(data)
+	return h.version, err
+}
+
+// PayloadFormat returns the name of the codec a payload was encoded with,
+// without decoding it. Payloads older than schema version 3 are gob.
+func PayloadFormat(data []byte) (string, error) {
+	h, _, err := splitHeader(data)
+	return h.format, err
+}
+
+type header struct {
+	version int
+	format  string
+}
+
+var errTruncatedHeader = errors.New("truncated payload header")
+
+func splitHeader(data []byte) (header, []byte, error) {
+	if !bytes.HasPrefix(data, payloadMagic) {
+		return header{version: 1, format: FormatGob}, data, nil
+	}
+	data = data[len(payloadMagic):]
+	version, n := binary.Uvarint(data)
+	if n <= 0 {
+		return header{}, nil, errTruncatedHeader
+	}
+	data = data[n:]
+	h := header{version: int(version), format: FormatGob}
+	if h.version < 3 || h.version > SchemaVersion {
+		// Newer headers may be laid out differently; Deserialize refuses
+		// them by version alone.
+		return h, data, nil
+	}
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return header{}, nil, errTruncatedHeader
+	}
+	h.format = string(data[n : n+int(size)])
+	return h, data[n+int(size):], nil
+}
+
+func appendHeader(buf []byte, format string) []byte {
+	buf = append(buf, payloadMagic...)
+	buf = binary.AppendUvarint(buf, SchemaVersion)
+	buf = binary.AppendUvarint(buf, uint64(len(format)))
+	return append(buf, format...)
+}
+
+// migrate upgrades dag from version to SchemaVersion.
+func migrate(dag *DAG, version int) error {
+	for v := version; v < SchemaVersion; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from payload schema %d to %d", v, v+1)
+		}
+		if err := m(dag); err != nil {
+			return fmt.Errorf("migrate payload schema %d to %d: %w", v, v+1, err)
+		}
+	}
+	return nil
+}
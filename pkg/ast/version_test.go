@@ -0,0 +1,82 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestSerialize_Header(t *testing.T) {
+	payload, err := sampleDAG().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(payload, []byte("GOAT")) {
+		t.Errorf("payload does not start with the header: %q", payload[:8])
+	}
+	if v, err := PayloadVersion(payload); err != nil || v != SchemaVersion {
+		t.Errorf("expected version %d, got %d (%v)", SchemaVersion, v, err)
+	}
+
+	_, body, _ := splitHeader(payload)
+	if v, _ := PayloadVersion(body); v != 1 {
+		t.Errorf("expected a headerless payload to be version 1, got %d", v)
+	}
+	if _, err := Deserialize(body); err != nil {
+		t.Errorf("headerless payload rejected: %v", err)
+	}
+	if _, err := Deserialize([]byte("GOAT")); err == nil {
+		t.Error("expected a truncated header to fail")
+	}
+}
+
+func TestDeserialize_NewerSchema(t *testing.T) {
+	payload, _ := sampleDAG().Serialize()
+	_, body, _ := splitHeader(payload)
+	newer := binary.AppendUvarint([]byte("GOAT"), SchemaVersion+1)
+	_, err := Deserialize(append(newer, body...))
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("expected ErrNewerSchema, got %v", err)
+	}
+}
+
+func TestDeserialize_Migrations(t *testing.T) {
+	payload, _ := sampleDAG().Serialize()
+	_, unversioned, _ := splitHeader(payload)
+
+	saved := migrations[1]
+	defer func() { migrations[1] = saved }()
+
+	migrations[1] = func(d *DAG) error {
+		d.Nodes["web"].Spec.(*DeploymentSpec).Image = "migrated"
+		return nil
+	}
+	dag, err := Deserialize(unversioned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dag.Nodes["web"].Spec.(*DeploymentSpec).Image != "migrated" {
+		t.Error("migration was not applied to an old payload")
+	}
+	if dag, _ := Deserialize(payload); dag.Nodes["web"].Spec.(*DeploymentSpec).Image == "migrated" {
+		t.Error("migration was applied to a current payload")
+	}
+
+	migrations[1] = func(*DAG) error { return errors.New("boom") }
+	if _, err := Deserialize(unversioned); err == nil {
+		t.Error("expected a failing migration to fail Deserialize")
+	}
+	delete(migrations, 1)
+	if _, err := Deserialize(unversioned); err == nil {
+		t.Error("expected a missing migration to fail Deserialize")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a migration twice to panic")
+		}
+	}()
+	RegisterMigration(1, saved)
+	RegisterMigration(1, saved)
+}
@@ -0,0 +1,255 @@
+// Package codegen emits Go DSL source: typed builders for CustomResourceDefinitions
+// and graph definitions for existing manifests.
+package codegen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CRDOptions tunes the builders generated from a CustomResourceDefinition.
+type CRDOptions struct {
+	// Package is the name of the generated Go package.
+	Package string
+	// Version selects the CRD version to generate; empty means the storage version.
+	Version string
+}
+
+type crdDocument struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Group string `json:"group"`
+		Scope string `json:"scope"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name    string `json:"name"`
+			Storage bool   `json:"storage"`
+			Schema  *struct {
+				OpenAPIV3Schema *schema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// schema is the subset of OpenAPI v3 that structural CRD schemas use.
+type schema struct {
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schemaOrBool      `json:"additionalProperties"`
+	IntOrString          bool               `json:"x-kubernetes-int-or-string"`
+	PreserveUnknown      bool               `json:"x-kubernetes-preserve-unknown-fields"`
+}
+
+type schemaOrBool struct {
+	Allows bool
+	Schema *schema
+}
+
+func (s *schemaOrBool) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &s.Allows); err == nil {
+		return nil
+	}
+	s.Allows = true
+	return json.Unmarshal(data, &s.Schema)
+}
+
+// GenerateCRD renders a Go file with a typed spec struct and a constructor
+// wrapping dsl.NewCustom for the CustomResourceDefinition in crd (YAML or JSON).
+func GenerateCRD(crd []byte, opts CRDOptions) ([]byte, error) {
+	var doc crdDocument
+	if err := yaml.Unmarshal(crd, &doc); err != nil {
+		return nil, fmt.Errorf("parse CRD: %w", err)
+	}
+	if doc.Kind != "CustomResourceDefinition" {
+		return nil, fmt.Errorf("expected a CustomResourceDefinition, got kind %q", doc.Kind)
+	}
+	kind := doc.Spec.Names.Kind
+	if kind == "" || doc.Spec.Group == "" {
+		return nil, fmt.Errorf("CRD is missing spec.group or spec.names.kind")
+	}
+	if opts.Package == "" {
+		opts.Package = strings.ToLower(kind)
+	}
+
+	var version string
+	var root *schema
+	for _, v := range doc.Spec.Versions {
+		if (opts.Version == "" && v.Storage) || v.Name == opts.Version {
+			version = v.Name
+			if v.Schema != nil {
+				root = v.Schema.OpenAPIV3Schema
+			}
+		}
+	}
+	if version == "" {
+		return nil, fmt.Errorf("CRD %s has no version %q", kind, opts.Version)
+	}
+
+	g := &generator{}
+	specType := "map[string]any"
+	if root != nil && root.Properties["spec"] != nil {
+		specType = g.goType(root.Properties["spec"], kind+"Spec")
+	}
+
+	apiVersion := doc.Spec.Group + "/" + version
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by goat-crdgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", opts.Package)
+	fmt.Fprintf(&buf, "import \"github.com/arpanpathak/kube-goAT/pkg/dsl\"\n\n")
+	fmt.Fprintf(&buf, "// APIVersion is the group/version every builder in this file targets.\n")
+	fmt.Fprintf(&buf, "const APIVersion = %q\n\n", apiVersion)
+	fmt.Fprintf(&buf, "// New%s starts a %s %s with a typed spec.\n", kind, apiVersion, kind)
+	fmt.Fprintf(&buf, "func New%s(name string, spec %s) *dsl.Custom {\n", kind, specType)
+	if doc.Spec.Scope == "Cluster" {
+		fmt.Fprintf(&buf, "\treturn dsl.NewCustom(APIVersion, %q, name).ClusterScoped().Spec(spec)\n}\n", kind)
+	} else {
+		fmt.Fprintf(&buf, "\treturn dsl.NewCustom(APIVersion, %q, name).Spec(spec)\n}\n", kind)
+	}
+	buf.Write(g.types.Bytes())
+
+	return format.Source(buf.Bytes())
+}
+
+type generator struct {
+	types bytes.Buffer
+}
+
+// goType returns the Go type for s, emitting named structs for objects with
+// declared properties.
+func (g *generator) goType(s *schema, name string) string {
+	switch {
+	case s.IntOrString:
+		return "any"
+	case s.Type == "string":
+		return "string"
+	case s.Type == "boolean":
+		return "bool"
+	case s.Type == "integer" && s.Format == "int32":
+		return "int32"
+	case s.Type == "integer":
+		return "int64"
+	case s.Type == "number":
+		return "float64"
+	case s.Type == "array" && s.Items != nil:
+		return "[]" + g.goType(s.Items, name+"Item")
+	case s.Type == "array":
+		return "[]any"
+	case s.Type == "object" && len(s.Properties) > 0:
+		g.emitStruct(s, name)
+		return name
+	case s.Type == "object" && s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil:
+		return "map[string]" + g.goType(s.AdditionalProperties.Schema, name+"Value")
+	default:
+		return "map[string]any"
+	}
+}
+
+func (g *generator) emitStruct(s *schema, name string) {
+	required := make(map[string]bool)
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	keys := make([]string, 0, len(s.Properties))
+	for k := range s.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var body bytes.Buffer
+	for _, key := range keys {
+		prop := s.Properties[key]
+		field := goName(key)
+		typ := g.goType(prop, name+field)
+		tag := key
+		if !required[key] {
+			tag += ",omitempty"
+			if prop.Type == "object" && len(prop.Properties) > 0 {
+				typ = "*" + typ
+			}
+		}
+		if doc := summary(prop.Description); doc != "" {
+			fmt.Fprintf(&body, "\t// %s\n", doc)
+		}
+		fmt.Fprintf(&body, "\t%s %s `json:%q`\n", field, typ, tag)
+	}
+
+	fmt.Fprintf(&g.types, "\n")
+	if doc := summary(s.Description); doc != "" {
+		fmt.Fprintf(&g.types, "// %s %s\n", name, lowerFirst(doc))
+	}
+	fmt.Fprintf(&g.types, "type %s struct {\n%s}\n", name, body.String())
+}
+
+var initialisms = map[string]string{
+	"api": "API", "cpu": "CPU", "dns": "DNS", "http": "HTTP", "https": "HTTPS",
+	"id": "ID", "ip": "IP", "json": "JSON", "tls": "TLS", "uid": "UID",
+	"uri": "URI", "url": "URL",
+}
+
+// goName turns a JSON property such as "dnsNames" or "secret-name" into an
+// exported Go identifier.
+func goName(key string) string {
+	var words []string
+	var cur []rune
+	flush := func() {
+		if len(cur) > 0 {
+			words = append(words, string(cur))
+			cur = nil
+		}
+	}
+	for i, r := range key {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+		case unicode.IsUpper(r) && i > 0:
+			flush()
+			cur = append(cur, r)
+		default:
+			cur = append(cur, r)
+		}
+	}
+	flush()
+
+	var out strings.Builder
+	for _, w := range words {
+		if up, ok := initialisms[strings.ToLower(w)]; ok {
+			out.WriteString(up)
+			continue
+		}
+		out.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	name := out.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+// summary returns the first sentence of a schema description on one line.
+func summary(desc string) string {
+	desc = strings.Join(strings.Fields(desc), " ")
+	if i := strings.Index(desc, ". "); i >= 0 {
+		desc = desc[:i+1]
+	}
+	return desc
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
@@ -0,0 +1,127 @@
+package codegen
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+const certificateCRD = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.cert-manager.io
+spec:
+  group: cert-manager.io
+  scope: Namespaced
+  names:
+    kind: Certificate
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: false
+  - name: v1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            description: Desired state of the Certificate. Extra detail is dropped.
+            required: [secretName, issuerRef]
+            properties:
+              secretName:
+                type: string
+              dnsNames:
+                type: array
+                items:
+                  type: string
+              duration:
+                type: string
+              revisionHistoryLimit:
+                type: integer
+                format: int32
+              issuerRef:
+                type: object
+                required: [name]
+                properties:
+                  name:
+                    type: string
+                  kind:
+                    type: string
+              privateKey:
+                type: object
+                properties:
+                  size:
+                    type: integer
+              secretTemplate:
+                type: object
+                properties:
+                  labels:
+                    type: object
+                    additionalProperties:
+                      type: string
+              keystores:
+                type: object
+                x-kubernetes-preserve-unknown-fields: true
+`
+
+func TestGenerateCRD(t *testing.T) {
+	src, err := GenerateCRD([]byte(certificateCRD), CRDOptions{Package: "certs"})
+	if err != nil {
+		t.Fatalf("GenerateCRD failed: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "certificate.go", src, 0); err != nil {
+		t.Fatalf("Generated source does not parse: %v\n%s", err, src)
+	}
+
+	// Compare with whitespace collapsed so gofmt alignment doesn't matter.
+	got := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"package certs",
+		`const APIVersion = "cert-manager.io/v1"`,
+		"func NewCertificate(name string, spec CertificateSpec) *dsl.Custom",
+		"// CertificateSpec desired state of the Certificate.",
+		"DNSNames []string `json:\"dnsNames,omitempty\"`",
+		"SecretName string `json:\"secretName\"`",
+		"IssuerRef CertificateSpecIssuerRef `json:\"issuerRef\"`",
+		"PrivateKey *CertificateSpecPrivateKey `json:\"privateKey,omitempty\"`",
+		"RevisionHistoryLimit int32",
+		"Size int64",
+		"Labels map[string]string",
+		"Keystores map[string]any",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Generated source missing %q\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateCRD_Errors(t *testing.T) {
+	if _, err := GenerateCRD([]byte("kind: Deployment"), CRDOptions{}); err == nil {
+		t.Error("Expected error for non-CRD document")
+	}
+	if _, err := GenerateCRD([]byte(certificateCRD), CRDOptions{Version: "v2"}); err == nil {
+		t.Error("Expected error for unknown version")
+	}
+	if _, err := GenerateCRD([]byte("{not yaml"), CRDOptions{}); err == nil {
+		t.Error("Expected error for malformed input")
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for in, want := range map[string]string{
+		"dnsNames":    "DNSNames",
+		"secret-name": "SecretName",
+		"apiURL":      "APIURL",
+		"ipv4":        "Ipv4",
+		"1st":         "X1st",
+	} {
+		if got := goName(in); got != want {
+			t.Errorf("goName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
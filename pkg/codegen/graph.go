@@ -0,0 +1,355 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+)
+
+// GraphOptions tunes the Go source generated for a graph.
+type GraphOptions struct {
+	// Package is the name of the generated Go package.
+	Package string
+	// Func is the name of the generated function returning the graph.
+	Func string
+}
+
+// GenerateGraph renders idiomatic DSL source that rebuilds dag, typically
+// one imported with dsl.FromYAML, so the manifests can be deleted.
+func GenerateGraph(dag *ast.DAG, opts GraphOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "infra"
+	}
+	if opts.Func == "" {
+		opts.Func = "Graph"
+	}
+	order, err := declarationOrder(dag)
+	if err != nil {
+		return nil, err
+	}
+
+	// vars maps DAG keys to Go variables; dependencies name their target by
+	// key, which is the resource name unless names collide.
+	vars := make(map[string]string, len(order))
+	used := make(map[string]bool)
+	for _, key := range order {
+		vars[key] = varName(dag.Nodes[key], used)
+	}
+
+	var body bytes.Buffer
+	for _, key := range order {
+		node := dag.Nodes[key]
+		var expr string
+		switch node.Kind {
+		case "Service":
+			expr, err = serviceExpr(node, vars)
+		case "Deployment":
+			expr, err = deploymentExpr(node, dag, vars)
+		case "Custom":
+			expr, err = customExpr(node, vars)
+		default:
+			return nil, fmt.Errorf("node %s: cannot generate source for kind %q", node.Name, node.Kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&body, "\t%s := %s\n", vars[key], expr)
+	}
+	fmt.Fprintf(&body, "\n\treturn dsl.NewGraph()")
+	for _, key := range order {
+		fmt.Fprintf(&body, ".\n\t\tAdd(%s)", vars[key])
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by goat-fromyaml. Review, then edit freely.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", opts.Package)
+	fmt.Fprintf(&buf, "import \"github.com/arpanpathak/kube-goAT/pkg/dsl\"\n\n")
+	fmt.Fprintf(&buf, "// %s returns the infrastructure graph.\n", opts.Func)
+	fmt.Fprintf(&buf, "func %s() *dsl.GraphBuilder {\n%s\n}\n", opts.Func, body.String())
+	return format.Source(buf.Bytes())
+}
+
+// declarationOrder sorts node keys so every dependency is declared before
+// use, breaking ties by key for stable output. Plain name keys come before
+// identity keys so rebuilding the graph assigns the same keys again.
+func declarationOrder(dag *ast.DAG) ([]string, error) {
+	names := make([]string, 0, len(dag.Nodes))
+	for name := range dag.Nodes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ii, ij := strings.Contains(names[i], "/"), strings.Contains(names[j], "/")
+		if ii != ij {
+			return ij
+		}
+		return names[i] < names[j]
+	})
+
+	var order []string
+	state := make(map[string]int) // 0 unvisited, 1 visiting, 2 done
+	var visit func(name string) error
+	visit = func(name string) error {
+		node, ok := dag.Nodes[name]
+		if !ok {
+			return fmt.Errorf("unknown dependency %q", name)
+		}
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("dependency cycle through %q", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		deps := append([]string(nil), node.Dependencies...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+var kindSuffix = map[string]string{"Service": "Svc", "Deployment": "Dep"}
+
+func varName(node *ast.Node, used map[string]bool) string {
+	suffix, ok := kindSuffix[node.Kind]
+	if !ok {
+		suffix = goName(node.ObjectKind())
+	}
+	base := lowerFirst(goName(node.Name)) + suffix
+	name := base
+	for i := 2; used[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	used[name] = true
+	return name
+}
+
+var serviceTypes = map[string]string{"NodePort": "dsl.NodePort", "LoadBalancer": "dsl.LoadBalancer"}
+
+func serviceExpr(node *ast.Node, vars map[string]string) (string, error) {
+	spec, err := ast.SpecOf[*ast.ServiceSpec](node)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	ports := spec.Ports
+
+	if spec.Type == "ExternalName" {
+		fmt.Fprintf(&b, "dsl.NewExternalService(%q, %q)", node.Name, spec.ExternalName)
+	} else {
+		var first ast.ServicePort
+		if len(ports) > 0 {
+			first, ports = ports[0], ports[1:]
+		}
+		fmt.Fprintf(&b, "dsl.NewService(%q, %d, %d)", node.Name, first.Port, first.TargetPort)
+		if first.Name != "" {
+			fmt.Fprintf(&b, ".\n\t\tPortName(%q)", first.Name)
+		}
+		writePortModifiers(&b, first)
+		for _, p := range ports {
+			if p.TargetPortName != "" {
+				fmt.Fprintf(&b, ".\n\t\tPortTo(%q, %d, %q)", p.Name, p.Port, p.TargetPortName)
+			} else {
+				fmt.Fprintf(&b, ".\n\t\tPort(%q, %d, %d)", p.Name, p.Port, p.TargetPort)
+			}
+			writePortModifiers(&b, p)
+		}
+		if t, ok := serviceTypes[spec.Type]; ok {
+			fmt.Fprintf(&b, ".\n\t\tType(%s)", t)
+		}
+		if spec.ClusterIP == "None" {
+			fmt.Fprintf(&b, ".\n\t\tHeadless()")
+		}
+		selector := spec.Selector
+		if !(len(selector) == 1 && selector[dsl.ServiceSelectorPrefix+node.Name] == "true") {
+			writePairs(&b, "Selector", selector)
+		}
+	}
+	if spec.SessionAffinity == "ClientIP" {
+		fmt.Fprintf(&b, ".\n\t\tStickySessions(%d)", spec.SessionAffinityTimeout)
+	}
+	writeNamespace(&b, node)
+	writePairs(&b, "Label", spec.Labels)
+	writeProtect(&b, node)
+	writeDependsOn(&b, node.Dependencies, vars)
+	return b.String(), nil
+}
+
+func writePortModifiers(b *strings.Builder, p ast.ServicePort) {
+	if p.Protocol != "" && p.Protocol != "TCP" {
+		fmt.Fprintf(b, ".Protocol(%q)", p.Protocol)
+	}
+	if p.NodePort != 0 {
+		fmt.Fprintf(b, ".NodePort(%d)", p.NodePort)
+	}
+}
+
+func deploymentExpr(node *ast.Node, dag *ast.DAG, vars map[string]string) (string, error) {
+	spec, err := ast.SpecOf[*ast.DeploymentSpec](node)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "dsl.NewDeployment(%q, %q)", node.Name, spec.Image)
+	if spec.Replicas != 1 {
+		fmt.Fprintf(&b, ".\n\t\tReplicas(%d)", spec.Replicas)
+	}
+	for _, p := range spec.Ports {
+		fmt.Fprintf(&b, ".\n\t\tPort(%q, %d)", p.Name, p.Port)
+	}
+	writeNamespace(&b, node)
+	writePairs(&b, "Label", spec.Labels)
+	selector := spec.Selector
+	if !(len(selector) == 1 && selector[dsl.DeploymentSelectorLabel] == node.Name) {
+		writePairs(&b, "Selector", selector)
+	}
+
+	writeProtect(&b, node)
+
+	// Dependencies on Services whose selector the pods carry are attachments.
+	var others []string
+	for _, dep := range node.Dependencies {
+		svc, ok := dag.Nodes[dep].Spec.(*ast.ServiceSpec)
+		if ok && dag.Nodes[dep].Kind == "Service" && len(svc.Selector) > 0 && containsAll(spec.PodLabels, svc.Selector) {
+			fmt.Fprintf(&b, ".\n\t\tAttachedTo(%s)", vars[dep])
+			continue
+		}
+		others = append(others, dep)
+	}
+	writeDependsOn(&b, others, vars)
+	return b.String(), nil
+}
+
+func customExpr(node *ast.Node, vars map[string]string) (string, error) {
+	spec, err := ast.SpecOf[*ast.CustomSpec](node)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "dsl.NewCustom(%q, %q, %q)", spec.APIVersion, spec.Kind, node.Name)
+	if node.Namespace == "" {
+		fmt.Fprintf(&b, ".\n\t\tClusterScoped()")
+	} else {
+		writeNamespace(&b, node)
+	}
+	writePairs(&b, "Label", spec.Labels)
+	writePairs(&b, "Annotation", spec.Annotations)
+
+	content := spec.Content
+	for _, field := range sortedKeys(content) {
+		if field == "spec" {
+			fmt.Fprintf(&b, ".\n\t\tSpec(%s)", literal(content[field], 2))
+		} else {
+			fmt.Fprintf(&b, ".\n\t\tSet(%q, %s)", field, literal(content[field], 2))
+		}
+	}
+	writeProtect(&b, node)
+	writeDependsOn(&b, node.Dependencies, vars)
+	return b.String(), nil
+}
+
+func writeNamespace(b *strings.Builder, node *ast.Node) {
+	if node.Namespace != "default" {
+		fmt.Fprintf(b, ".\n\t\tNamespace(%q)", node.Namespace)
+	}
+}
+
+func writeProtect(b *strings.Builder, node *ast.Node) {
+	if node.Protected {
+		fmt.Fprintf(b, ".\n\t\tProtect()")
+	}
+}
+
+func writePairs(b *strings.Builder, method string, pairs map[string]string) {
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, ".\n\t\t%s(%q, %q)", method, k, pairs[k])
+	}
+}
+
+func writeDependsOn(b *strings.Builder, deps []string, vars map[string]string) {
+	if len(deps) == 0 {
+		return
+	}
+	refs := make([]string, len(deps))
+	for i, dep := range deps {
+		refs[i] = vars[dep]
+	}
+	fmt.Fprintf(b, ".\n\t\tDependsOn(%s)", strings.Join(refs, ", "))
+}
+
+// literal renders a JSON-like value as a Go composite literal.
+func literal(v any, depth int) string {
+	indent := strings.Repeat("\t", depth+1)
+	switch t := v.(type) {
+	case map[string]any:
+		if len(t) == 0 {
+			return "map[string]any{}"
+		}
+		var b strings.Builder
+		b.WriteString("map[string]any{\n")
+		for _, k := range sortedKeys(t) {
+			fmt.Fprintf(&b, "%s%q: %s,\n", indent, k, literal(t[k], depth+1))
+		}
+		b.WriteString(strings.Repeat("\t", depth) + "}")
+		return b.String()
+	case []any:
+		if len(t) == 0 {
+			return "[]any{}"
+		}
+		var b strings.Builder
+		b.WriteString("[]any{\n")
+		for _, e := range t {
+			fmt.Fprintf(&b, "%s%s,\n", indent, literal(e, depth+1))
+		}
+		b.WriteString(strings.Repeat("\t", depth) + "}")
+		return b.String()
+	case string:
+		return strconv.Quote(t)
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	case nil:
+		return "nil"
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsAll(set, sub map[string]string) bool {
+	for k, v := range sub {
+		if set[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package codegen
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+)
+
+const manifests = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  labels:
+    team: edge
+spec:
+  type: NodePort
+  selector:
+    app: web
+  ports:
+  - name: http
+    port: 80
+    targetPort: 8080
+    nodePort: 30080
+  - name: dns
+    port: 53
+    protocol: UDP
+    targetPort: dns
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: nginx:1.27
+        ports:
+        - name: dns
+          containerPort: 53
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: prod
+data:
+  mode: fast
+  retries: "3"
+`
+
+func TestGenerateGraph(t *testing.T) {
+	graph, err := dsl.FromYAML(strings.NewReader(manifests))
+	if err != nil {
+		t.Fatalf("FromYAML failed: %v", err)
+	}
+	src, err := GenerateGraph(graph.Build(), GraphOptions{Package: "infra"})
+	if err != nil {
+		t.Fatalf("GenerateGraph failed: %v", err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "graph.go", src, 0); err != nil {
+		t.Fatalf("Generated source does not parse: %v\n%s", err, src)
+	}
+
+	got := strings.Join(strings.Fields(string(src)), " ")
+	for _, want := range []string{
+		"func Graph() *dsl.GraphBuilder",
+		`webSvc := dsl.NewService("web", 80, 8080). PortName("http").NodePort(30080). PortTo("dns", 53, "dns").Protocol("UDP"). Type(dsl.NodePort). Selector("app", "web"). Label("team", "edge")`,
+		`webDep := dsl.NewDeployment("web", "nginx:1.27"). Replicas(2). Port("dns", 53). Label("app", "web"). Selector("app", "web"). AttachedTo(webSvc)`,
+		`settingsConfigMap := dsl.NewCustom("v1", "ConfigMap", "settings"). Namespace("prod"). Set("data", map[string]any{ "mode": "fast", "retries": "3", })`,
+		"return dsl.NewGraph(). Add(settingsConfigMap). Add(webSvc). Add(webDep)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Generated source missing %q\n%s", want, src)
+		}
+	}
+}
+
+func TestGenerateGraph_Protect(t *testing.T) {
+	dag := dsl.NewGraph().Add(dsl.NewDeployment("db", "postgres:16").Protect()).Build()
+	src, err := GenerateGraph(dag, GraphOptions{})
+	if err != nil {
+		t.Fatalf("GenerateGraph failed: %v", err)
+	}
+	got := strings.Join(strings.Fields(string(src)), " ")
+	if want := `dbDep := dsl.NewDeployment("db", "postgres:16"). Protect()`; !strings.Contains(got, want) {
+		t.Errorf("Generated source missing %q\n%s", want, src)
+	}
+}
+
+func TestGenerateGraph_Errors(t *testing.T) {
+	cyclic := &ast.DAG{Nodes: map[string]*ast.Node{
+		"a": {Kind: "Custom", Name: "a", Dependencies: []string{"b"}},
+		"b": {Kind: "Custom", Name: "b", Dependencies: []string{"a"}},
+	}}
+	if _, err := GenerateGraph(cyclic, GraphOptions{}); err == nil {
+		t.Error("Expected cycle error")
+	}
+	dangling := &ast.DAG{Nodes: map[string]*ast.Node{"a": {Kind: "Custom", Name: "a", Dependencies: []string{"missing"}}}}
+	if _, err := GenerateGraph(dangling, GraphOptions{}); err == nil {
+		t.Error("Expected unknown dependency error")
+	}
+	unknown := &ast.DAG{Nodes: map[string]*ast.Node{"a": {Kind: "Mystery", Name: "a"}}}
+	if _, err := GenerateGraph(unknown, GraphOptions{}); err == nil {
+		t.Error("Expected unsupported kind error")
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	v := map[string]any{"b": []any{int64(1), 2.5, true, nil}, "a": map[string]any{}}
+	want := "map[string]any{\n\t\"a\": map[string]any{},\n\t\"b\": []any{\n\t\t1,\n\t\t2.5,\n\t\ttrue,\n\t\tnil,\n\t},\n}"
+	if got := literal(v, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("literal() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,12 +1,42 @@
 package compiler
 
 import (
+	"fmt"
+
 	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
 )
 
+// Option customizes Compile.
+type Option func(*options)
+
+type options struct {
+	signer signing.Signer
+}
+
+// WithSigner signs the compiled payload with s, so an engine configured
+// with a trust set including s can verify it before applying.
+func WithSigner(s signing.Signer) Option {
+	return func(o *options) {
+		o.signer = s
+	}
+}
+
 // Compile turns a GraphBuilder into a serialized binary payload.
 // This decouples the DSL formulation from the final gob encoding if needed.
-func Compile(g *dsl.GraphBuilder) ([]byte, error) {
+// Graphs that fail validation never reach the encoder.
+func Compile(g *dsl.GraphBuilder, opts ...Option) ([]byte, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
 	dag := g.Build()
-	return dag.Serialize()
+	if err := Validate(dag); err != nil {
+		return nil, fmt.Errorf("invalid graph: %w", err)
+	}
+	payload, err := dag.Serialize()
+	if err != nil || o.signer == nil {
+		return payload, err
+	}
+	return signing.Sign(payload, o.signer)
 }
@@ -1,9 +1,12 @@
 package compiler
 
 import (
+	"crypto/ed25519"
 	"testing"
 
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
 	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
 )
 
 func TestCompile(t *testing.T) {
@@ -21,3 +24,81 @@ func TestCompile(t *testing.T) {
 		t.Fatal("Compiled payload is empty")
 	}
 }
+
+func TestCompile_Signed(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	graph := dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080))
+
+	payload, err := Compile(graph, WithSigner(signing.NewEd25519Signer("ci", private)))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	inner, signer, err := signing.Verify(payload, signing.TrustSet{"ci": public})
+	if err != nil || signer != "ci" {
+		t.Fatalf("Verify = %q, %v", signer, err)
+	}
+	unsigned, _ := Compile(graph)
+	if string(inner) != string(unsigned) {
+		t.Error("expected the signed payload to wrap the unsigned one")
+	}
+	if dag, err := ast.Deserialize(payload); err != nil || dag.Nodes["svc"] == nil {
+		t.Errorf("expected the signed payload to deserialize, got %v", err)
+	}
+}
+
+func TestCompile_ValidationErrors(t *testing.T) {
+	tests := map[string]*dsl.GraphBuilder{
+		"unnamed multi-port":    dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080).Port("admin", 81, 8081)),
+		"duplicate names":       dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080).PortName("http").Port("http", 81, 8081)),
+		"bad protocol":          dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080).Protocol("HTTP")),
+		"nodePort on ClusterIP": dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080).NodePort(30080)),
+		"headless LoadBalancer": dsl.NewGraph().Add(dsl.NewService("svc", 80, 8080).Type(dsl.LoadBalancer).Headless()),
+		"external without host": dsl.NewGraph().Add(dsl.NewExternalService("svc", "")),
+		"missing container port": func() *dsl.GraphBuilder {
+			svc := dsl.NewService("svc", 80, 8080).PortName("http").PortTo("metrics", 9090, "metrics")
+			dep := dsl.NewDeployment("dep", "nginx").Port("http", 8080).AttachedTo(svc)
+			return dsl.NewGraph().Add(svc).Add(dep)
+		}(),
+		"long container port name": dsl.NewGraph().Add(dsl.NewDeployment("dep", "nginx").Port("much-too-long-port", 8080)),
+		"custom without kind":      dsl.NewGraph().Add(dsl.NewCustom("example.com/v1", "", "w")),
+		"unencodable custom spec":  dsl.NewGraph().Add(dsl.NewCustom("example.com/v1", "Widget", "w").Spec(func() {})),
+		"unknown dependency":       dsl.NewGraph().Add(dsl.NewDeployment("dep", "nginx").AttachedTo(dsl.NewService("svc", 80, 8080))),
+		"dependency cycle": func() *dsl.GraphBuilder {
+			a := dsl.NewCustom("example.com/v1", "Widget", "a")
+			b := dsl.NewCustom("example.com/v1", "Widget", "b").DependsOn(a)
+			a.DependsOn(b)
+			return dsl.NewGraph().Add(a).Add(b)
+		}(),
+	}
+	for name, graph := range tests {
+		if _, err := Compile(graph); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestCompile_NamedTargetPort(t *testing.T) {
+	svc := dsl.NewService("svc", 80, 8080).PortName("http").PortTo("metrics", 9090, "metrics")
+	dep := dsl.NewDeployment("dep", "nginx").Port("http", 8080).Port("metrics", 9090).AttachedTo(svc)
+
+	if _, err := Compile(dsl.NewGraph().Add(svc).Add(dep)); err != nil {
+		t.Fatalf("Expected named target port to validate, got %v", err)
+	}
+}
+
+func TestCompile_SharedNames(t *testing.T) {
+	svc := dsl.NewService("web", 80, 8080).PortName("http").PortTo("metrics", 9090, "metrics")
+	dep := dsl.NewDeployment("web", "nginx").Port("http", 8080).Port("metrics", 9090).AttachedTo(svc)
+
+	// Whichever is added first keeps the bare name; the dependency must
+	// still reach the Service rather than the Deployment itself.
+	for _, graph := range []*dsl.GraphBuilder{dsl.NewGraph().Add(dep).Add(svc), dsl.NewGraph().Add(svc).Add(dep)} {
+		if _, err := Compile(graph); err != nil {
+			t.Errorf("Expected shared names to compile, got %v", err)
+		}
+	}
+	bare := dsl.NewDeployment("web", "nginx").Port("http", 8080).AttachedTo(svc)
+	if _, err := Compile(dsl.NewGraph().Add(bare).Add(svc)); err == nil {
+		t.Error("Expected the missing container port to be reported for a Deployment added first")
+	}
+}
@@ -0,0 +1,198 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Layout selects how RenderFiles splits manifests into files.
+type Layout int
+
+const (
+	// SingleFile writes one multi-document manifests.yaml.
+	SingleFile Layout = iota
+	// FilePerResource writes one <kind>-<namespace>-<name>.yaml per object.
+	FilePerResource
+	// Kustomize writes one file per object plus a kustomization.yaml listing them.
+	Kustomize
+)
+
+// kindOrder applies foundational kinds before the workloads that use them,
+// the same order kubectl users expect from Helm.
+var kindOrder = map[string]int{
+	"Namespace": 1, "CustomResourceDefinition": 2, "ServiceAccount": 3,
+	"Secret": 4, "ConfigMap": 5, "PersistentVolumeClaim": 6,
+	"ClusterRole": 7, "ClusterRoleBinding": 8, "Role": 9, "RoleBinding": 10,
+	"Service": 11, "Deployment": 12, "StatefulSet": 13, "Job": 14, "CronJob": 15,
+	"Ingress": 16,
+}
+
+// ManifestOption customizes how manifests are rendered.
+type ManifestOption func(*manifestOptions)
+
+type manifestOptions struct {
+	stateKey string
+}
+
+// WithStateKey stamps every object with the ownership metadata the engine
+// sets when it applies the graph under stateKey, including the hash of the
+// payload the graph compiles to. Without it, manifests applied by another
+// tool are refused by the engine with ErrNotOwned until they are imported.
+func WithStateKey(stateKey string) ManifestOption {
+	return func(o *manifestOptions) {
+		o.stateKey = stateKey
+	}
+}
+
+// Objects renders every node of the DAG into the objects the engine would
+// send, sorted by kind order, namespace and name.
+func Objects(dag *ast.DAG, opts ...ManifestOption) ([]*unstructured.Unstructured, error) {
+	var o manifestOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var payloadHash string
+	if o.stateKey != "" {
+		payload, err := dag.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		payloadHash = render.PayloadHash(payload)
+	}
+	objs := make([]*unstructured.Unstructured, 0, len(dag.Nodes))
+	for key, node := range dag.Nodes {
+		obj, err := render.Object(node)
+		if err != nil {
+			return nil, err
+		}
+		if o.stateKey != "" {
+			render.Ownership{
+				StateKey:    o.stateKey,
+				Node:        key,
+				PayloadHash: payloadHash,
+				NodeHash:    node.Hash(),
+				Protected:   node.Protected,
+			}.Stamp(obj)
+		}
+		objs = append(objs, obj)
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		a, b := objs[i], objs[j]
+		if oa, ob := rank(a.GetKind()), rank(b.GetKind()); oa != ob {
+			return oa < ob
+		}
+		if a.GetKind() != b.GetKind() {
+			return a.GetKind() < b.GetKind()
+		}
+		if a.GetNamespace() != b.GetNamespace() {
+			return a.GetNamespace() < b.GetNamespace()
+		}
+		return a.GetName() < b.GetName()
+	})
+	return objs, nil
+}
+
+func rank(kind string) int {
+	if r, ok := kindOrder[kind]; ok {
+		return r
+	}
+	return len(kindOrder) + 1
+}
+
+// RenderYAML renders the DAG as a deterministic multi-document YAML stream.
+func RenderYAML(dag *ast.DAG, opts ...ManifestOption) ([]byte, error) {
+	objs, err := Objects(dag, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for i, obj := range objs {
+		doc, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(doc)
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderJSON renders the DAG as an indented v1 List.
+func RenderJSON(dag *ast.DAG, opts ...ManifestOption) ([]byte, error) {
+	objs, err := Objects(dag, opts...)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]map[string]any, len(objs))
+	for i, obj := range objs {
+		items[i] = obj.Object
+	}
+	list := map[string]any{"apiVersion": "v1", "kind": "List", "items": items}
+	out, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// RenderFiles renders the DAG into file names and contents for the layout.
+func RenderFiles(dag *ast.DAG, layout Layout, opts ...ManifestOption) (map[string][]byte, error) {
+	if layout == SingleFile {
+		out, err := RenderYAML(dag, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{"manifests.yaml": out}, nil
+	}
+
+	objs, err := Objects(dag, opts...)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(objs)+1)
+	var resources []string
+	for _, obj := range objs {
+		name := fileName(obj)
+		if _, taken := files[name]; taken {
+			return nil, fmt.Errorf("two objects render to %s", name)
+		}
+		doc, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = doc
+		resources = append(resources, name)
+	}
+	if layout == Kustomize {
+		kustomization, err := yaml.Marshal(map[string]any{
+			"apiVersion": "kustomize.config.k8s.io/v1beta1",
+			"kind":       "Kustomization",
+			"resources":  resources,
+		})
+		if err != nil {
+			return nil, err
+		}
+		files["kustomization.yaml"] = kustomization
+	}
+	return files, nil
+}
+
+func fileName(obj *unstructured.Unstructured) string {
+	parts := []string{strings.ToLower(obj.GetKind())}
+	if ns := obj.GetNamespace(); ns != "" {
+		parts = append(parts, ns)
+	}
+	parts = append(parts, obj.GetName())
+	return strings.Join(parts, "-") + ".yaml"
+}
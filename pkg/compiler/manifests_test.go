@@ -0,0 +1,151 @@
+package compiler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+)
+
+func manifestGraph() *ast.DAG {
+	svc := dsl.NewService("api", 80, 8080).Label("team", "edge")
+	dep := dsl.NewDeployment("api", "nginx:1.27").Replicas(2).AttachedTo(svc)
+	cm := dsl.NewCustom("v1", "ConfigMap", "settings").Set("data", map[string]string{"mode": "fast"})
+	ns := dsl.NewCustom("v1", "Namespace", "prod").ClusterScoped()
+	return dsl.NewGraph().Add(dep).Add(svc).Add(cm).Add(ns).Build()
+}
+
+func TestRenderYAML(t *testing.T) {
+	dag := manifestGraph()
+	out, err := RenderYAML(dag)
+	if err != nil {
+		t.Fatalf("RenderYAML failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		again, _ := RenderYAML(manifestGraph())
+		if string(again) != string(out) {
+			t.Fatalf("RenderYAML is not deterministic:\n%s\n---\n%s", out, again)
+		}
+	}
+
+	docs := strings.Split(string(out), "---\n")
+	if len(docs) != 4 {
+		t.Fatalf("Expected 4 documents, got %d:\n%s", len(docs), out)
+	}
+	for i, kind := range []string{"Namespace", "ConfigMap", "Service", "Deployment"} {
+		if !strings.Contains(docs[i], "kind: "+kind) {
+			t.Errorf("Document %d should be a %s:\n%s", i, kind, docs[i])
+		}
+	}
+	for _, noise := range []string{"creationTimestamp", "status", "resources: {}", "null"} {
+		if strings.Contains(string(out), noise) {
+			t.Errorf("Rendered YAML contains %q:\n%s", noise, out)
+		}
+	}
+	if !strings.Contains(docs[3], "replicas: 2") || !strings.Contains(docs[3], dsl.DeploymentSelectorLabel+": api") {
+		t.Errorf("Unexpected deployment document:\n%s", docs[3])
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	out, err := RenderJSON(manifestGraph())
+	if err != nil {
+		t.Fatalf("RenderJSON failed: %v", err)
+	}
+	var list struct {
+		Kind  string           `json:"kind"`
+		Items []map[string]any `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		t.Fatalf("RenderJSON produced invalid JSON: %v", err)
+	}
+	if list.Kind != "List" || len(list.Items) != 4 {
+		t.Errorf("Unexpected list: %s", out)
+	}
+}
+
+func TestRenderFiles(t *testing.T) {
+	dag := manifestGraph()
+
+	single, err := RenderFiles(dag, SingleFile)
+	if err != nil || len(single) != 1 || single["manifests.yaml"] == nil {
+		t.Fatalf("Unexpected single-file layout: %v %v", single, err)
+	}
+
+	files, err := RenderFiles(dag, FilePerResource)
+	if err != nil {
+		t.Fatalf("RenderFiles failed: %v", err)
+	}
+	for _, name := range []string{"namespace-prod.yaml", "configmap-default-settings.yaml", "service-default-api.yaml", "deployment-default-api.yaml"} {
+		if files[name] == nil {
+			t.Errorf("Missing %s in %v", name, files)
+		}
+	}
+	if _, ok := files["kustomization.yaml"]; ok {
+		t.Errorf("Per-resource layout must not write a kustomization")
+	}
+
+	kustomized, err := RenderFiles(dag, Kustomize)
+	if err != nil {
+		t.Fatalf("RenderFiles failed: %v", err)
+	}
+	k := string(kustomized["kustomization.yaml"])
+	if !strings.Contains(k, "kind: Kustomization") || !strings.Contains(k, "- namespace-prod.yaml") {
+		t.Errorf("Unexpected kustomization:\n%s", k)
+	}
+}
+
+func TestObjects_WithStateKey(t *testing.T) {
+	dag := manifestGraph()
+
+	plain, err := Objects(dag)
+	if err != nil {
+		t.Fatalf("Objects failed: %v", err)
+	}
+	for _, obj := range plain {
+		if _, ok := obj.GetAnnotations()[render.StackAnnotation]; ok {
+			t.Errorf("Expected %s/%s to carry no ownership without a state key", obj.GetKind(), obj.GetName())
+		}
+	}
+
+	payload, err := dag.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamped, err := Objects(dag, WithStateKey("web"))
+	if err != nil {
+		t.Fatalf("Objects failed: %v", err)
+	}
+	keys := map[string]string{"Namespace": "prod", "ConfigMap": "settings", "Service": "Service/default/api", "Deployment": "Deployment/default/api"}
+	for _, obj := range stamped {
+		annotations := obj.GetAnnotations()
+		if obj.GetLabels()[render.StackLabel] != "web" || annotations[render.StackAnnotation] != "web" {
+			t.Errorf("Expected %s to be owned by web, got %v %v", obj.GetKind(), obj.GetLabels(), annotations)
+		}
+		if key := keys[obj.GetKind()]; annotations[render.NodeAnnotation] != key || annotations[render.NodeHashAnnotation] != dag.Nodes[key].Hash() {
+			t.Errorf("Expected %s to name node %s and its hash, got %v", obj.GetKind(), key, annotations)
+		}
+		if annotations[render.PayloadHashAnnotation] != render.PayloadHash(payload) {
+			t.Errorf("Expected %s to carry the payload hash, got %v", obj.GetKind(), annotations)
+		}
+	}
+	if out, _ := RenderYAML(dag, WithStateKey("web")); !strings.Contains(string(out), render.StackAnnotation+": web") {
+		t.Errorf("Expected rendered YAML to carry the ownership:\n%s", out)
+	}
+}
+
+func TestRenderYAML_UnsupportedKind(t *testing.T) {
+	dag := &ast.DAG{Nodes: map[string]*ast.Node{"x": {Kind: "Mystery", Name: "x"}}}
+	if _, err := RenderYAML(dag); err == nil {
+		t.Error("Expected error for unsupported kind")
+	}
+	if _, err := RenderJSON(dag); err == nil {
+		t.Error("Expected error for unsupported kind")
+	}
+	if _, err := RenderFiles(dag, Kustomize); err == nil {
+		t.Error("Expected error for unsupported kind")
+	}
+}
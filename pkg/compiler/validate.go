@@ -0,0 +1,186 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+var validProtocols = map[string]bool{"TCP": true, "UDP": true, "SCTP": true}
+
+// Validate checks the cross-resource invariants that the builders alone
+// cannot enforce, returning every violation found in the graph.
+func Validate(dag *ast.DAG) error {
+	errs := validateDependencies(dag)
+	for _, node := range dag.Nodes {
+		switch node.Kind {
+		case "Service":
+			errs = append(errs, validateService(dag, node)...)
+		case "Deployment":
+			errs = append(errs, validateDeployment(node)...)
+		case "Custom":
+			errs = append(errs, validateCustom(node)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateDependencies ensures every dependency names another node of the
+// graph and that they can all be applied in order.
+func validateDependencies(dag *ast.DAG) []error {
+	var errs []error
+	for key, node := range dag.Nodes {
+		for _, dep := range node.Dependencies {
+			if _, ok := dag.Nodes[dep]; !ok {
+				errs = append(errs, fmt.Errorf("%s %s: unknown dependency %q", strings.ToLower(node.Kind), node.Name, dep))
+			} else if dep == key {
+				errs = append(errs, fmt.Errorf("%s %s: depends on itself", strings.ToLower(node.Kind), node.Name))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if _, err := dag.TopologicalOrder(); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func validateService(dag *ast.DAG, node *ast.Node) []error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("service %s: "+format, append([]any{node.Name}, args...)...))
+	}
+
+	spec, err := ast.SpecOf[*ast.ServiceSpec](node)
+	if err != nil {
+		return []error{err}
+	}
+	svcType, ports, clusterIP := spec.Type, spec.Ports, spec.ClusterIP
+
+	switch svcType {
+	case "", "ClusterIP", "NodePort", "LoadBalancer":
+		if len(ports) == 0 && clusterIP != "None" {
+			fail("at least one port is required")
+		}
+	case "ExternalName":
+		if spec.ExternalName == "" {
+			fail("ExternalName services require a host")
+		}
+		if clusterIP == "None" {
+			fail("ExternalName services cannot be headless")
+		}
+	default:
+		fail("unsupported service type %q", svcType)
+	}
+	if clusterIP == "None" && (svcType == "NodePort" || svcType == "LoadBalancer") {
+		fail("%s services cannot be headless", svcType)
+	}
+
+	names := make(map[string]bool)
+	for _, p := range ports {
+		if len(ports) > 1 && p.Name == "" {
+			fail("port %d must be named when exposing multiple ports", p.Port)
+		}
+		if p.Name != "" && names[p.Name] {
+			fail("duplicate port name %q", p.Name)
+		}
+		names[p.Name] = true
+		if p.Port < 1 || p.Port > 65535 {
+			fail("port %d out of range", p.Port)
+		}
+		if p.TargetPort < 0 || p.TargetPort > 65535 {
+			fail("targetPort %d of port %d out of range", p.TargetPort, p.Port)
+		}
+		if !validProtocols[p.Protocol] {
+			fail("port %d has unsupported protocol %q", p.Port, p.Protocol)
+		}
+		if p.NodePort != 0 && svcType != "NodePort" && svcType != "LoadBalancer" {
+			fail("port %d sets nodePort on a %s service", p.Port, svcType)
+		}
+		if p.TargetPortName != "" {
+			errs = append(errs, validateNamedTarget(dag, node, p)...)
+		}
+	}
+	return errs
+}
+
+// validateNamedTarget ensures every Deployment attached to the Service opens
+// the container port referenced by name.
+func validateNamedTarget(dag *ast.DAG, svc *ast.Node, port ast.ServicePort) []error {
+	var errs []error
+	for _, node := range dag.Nodes {
+		if node.Kind != "Deployment" || node.Namespace != svc.Namespace || !dependsOn(dag, node, svc) {
+			continue
+		}
+		if !hasContainerPort(node, port.TargetPortName) {
+			errs = append(errs, fmt.Errorf("service %s: port %d targets container port %q which deployment %s does not declare",
+				svc.Name, port.Port, port.TargetPortName, node.Name))
+		}
+	}
+	return errs
+}
+
+func validateDeployment(node *ast.Node) []error {
+	spec, err := ast.SpecOf[*ast.DeploymentSpec](node)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	if spec.Image == "" {
+		errs = append(errs, fmt.Errorf("deployment %s: image is required", node.Name))
+	}
+	names := make(map[string]bool)
+	for _, p := range spec.Ports {
+		if p.Name == "" || len(p.Name) > 15 {
+			errs = append(errs, fmt.Errorf("deployment %s: container port name %q must be 1-15 characters", node.Name, p.Name))
+		}
+		if names[p.Name] {
+			errs = append(errs, fmt.Errorf("deployment %s: duplicate container port name %q", node.Name, p.Name))
+		}
+		names[p.Name] = true
+		if p.Port < 1 || p.Port > 65535 {
+			errs = append(errs, fmt.Errorf("deployment %s: container port %d out of range", node.Name, p.Port))
+		}
+	}
+	return errs
+}
+
+func validateCustom(node *ast.Node) []error {
+	spec, err := ast.SpecOf[*ast.CustomSpec](node)
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	if spec.Error != "" {
+		errs = append(errs, fmt.Errorf("custom %s: %s", node.Name, spec.Error))
+	}
+	if spec.APIVersion == "" || spec.Kind == "" {
+		errs = append(errs, fmt.Errorf("custom %s: apiVersion and kind are required", node.Name))
+	}
+	return errs
+}
+
+// dependsOn reports whether node depends on target.
+func dependsOn(dag *ast.DAG, node, target *ast.Node) bool {
+	return slices.ContainsFunc(node.Dependencies, func(dep string) bool {
+		return dag.Nodes[dep] == target
+	})
+}
+
+func hasContainerPort(node *ast.Node, name string) bool {
+	spec, err := ast.SpecOf[*ast.DeploymentSpec](node)
+	if err != nil {
+		return false
+	}
+	for _, p := range spec.Ports {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
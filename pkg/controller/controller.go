@@ -0,0 +1,424 @@
+// Package controller runs kube-goAT in-cluster, continuously re-applying
+// the graphs recorded in state so drift is corrected without waiting for
+// the next deploy.
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// PausedAnnotation on a state Secret or ConfigMap stops the controller from
+// touching the graph it records until the annotation is removed or set to
+// another value.
+const PausedAnnotation = "kube-goat.io/paused"
+
+// Event reasons recorded on managed objects.
+const (
+	ReasonDriftCorrected = "DriftCorrected"
+	ReasonApplyFailed    = "ApplyFailed"
+)
+
+const (
+	// DefaultResync is how often every key is reconciled without a watch event.
+	DefaultResync = 5 * time.Minute
+
+	defaultBaseDelay = time.Second
+	defaultMaxDelay  = 5 * time.Minute
+)
+
+// Controller reconciles a fixed set of state keys. Each key is re-applied
+// when a watched object it manages changes, when its state Secret changes,
+// and every resync period; failing keys are retried with exponential
+// backoff.
+type Controller struct {
+	engine *engine.Engine
+	client kubernetes.Interface
+	store  state.Store
+	keys   []string
+
+	namespace string
+	resync    time.Duration
+	baseDelay time.Duration
+	maxDelay  time.Duration
+	recorder  record.EventRecorder
+	lease     *leaseConfig
+	// clusterKinds are the cluster-scoped kinds GoatStacks may apply.
+	clusterKinds map[string]bool
+
+	queue workqueue.TypedRateLimitingInterface[string]
+
+	mu     sync.Mutex
+	owners map[string]string // object identity to state key
+}
+
+type leaseConfig struct {
+	namespace, name, identity string
+}
+
+// New creates a Controller re-applying keys from store through eng.
+func New(eng *engine.Engine, store state.Store, keys []string, opts ...Option) *Controller {
+	c := &Controller{
+		engine:    eng,
+		client:    eng.GetClient(),
+		store:     store,
+		keys:      keys,
+		resync:    DefaultResync,
+		baseDelay: defaultBaseDelay,
+		maxDelay:  defaultMaxDelay,
+		owners:    make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.queue = workqueue.NewTypedRateLimitingQueue(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[string](c.baseDelay, c.maxDelay))
+	if c.recorder == nil {
+		broadcaster := record.NewBroadcaster()
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.client.CoreV1().Events("")})
+		c.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kube-goat"})
+	}
+	return c
+}
+
+// Run reconciles until ctx is cancelled, first acquiring the leader lease
+// when leader election is enabled.
+func (c *Controller) Run(ctx context.Context) error {
+	return c.lead(ctx, c.run)
+}
+
+// lead calls run directly, or while holding the lease when leader election
+// is enabled.
+func (c *Controller) lead(ctx context.Context, run func(context.Context) error) error {
+	if c.lease == nil {
+		return run(ctx)
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: c.lease.namespace, Name: c.lease.name},
+		Client:     c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.lease.identity},
+	}
+	var runErr error
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("[Controller] Acquired lease %s/%s as %s", c.lease.namespace, c.lease.name, c.lease.identity)
+				runErr = run(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("[Controller] Lost lease %s/%s", c.lease.namespace, c.lease.name)
+			},
+		},
+	})
+	return runErr
+}
+
+func (c *Controller) run(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(c.namespace))
+	handler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(_, obj any) { c.enqueueOwner(obj) },
+		DeleteFunc: c.enqueueOwner,
+	}
+	if _, err := factory.Core().V1().Services().Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+	if _, err := factory.Apps().V1().Deployments().Informer().AddEventHandler(handler); err != nil {
+		return err
+	}
+	if ns, ok := stateNamespace(c.store); ok {
+		objects := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(ns))
+		informer := objects.Core().V1().Secrets().Informer()
+		if _, ok := c.store.(*state.ConfigMapStore); ok {
+			informer = objects.Core().V1().ConfigMaps().Informer()
+		}
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(_, obj any) { c.enqueueStateKey(obj) },
+		})
+		if err != nil {
+			return err
+		}
+		objects.Start(ctx.Done())
+		objects.WaitForCacheSync(ctx.Done())
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	c.loop(ctx, c.enqueueAll, c.Reconcile)
+	return nil
+}
+
+// loop enqueues everything every resync period and works the queue until
+// ctx is cancelled.
+func (c *Controller) loop(ctx context.Context, enqueueAll func(), reconcile func(context.Context, string) error) {
+	go func() {
+		ticker := time.NewTicker(c.resync)
+		defer ticker.Stop()
+		for {
+			enqueueAll()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		c.queue.ShutDown()
+	}()
+
+	for c.processNext(ctx, reconcile) {
+	}
+}
+
+func (c *Controller) enqueueAll() {
+	for _, key := range c.keys {
+		c.queue.Add(key)
+	}
+}
+
+// enqueueOwner queues the state key managing a changed object, if any.
+func (c *Controller) enqueueOwner(obj any) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	var kind string
+	switch obj.(type) {
+	case *corev1.Service:
+		kind = "Service"
+	case *appsv1.Deployment:
+		kind = "Deployment"
+	default:
+		return
+	}
+	meta := obj.(metav1.Object)
+	c.mu.Lock()
+	key, owned := c.owners[kind+"/"+meta.GetNamespace()+"/"+meta.GetName()]
+	c.mu.Unlock()
+	if owned {
+		c.queue.Add(key)
+	}
+}
+
+// enqueueStateKey queues a key whose state Secret changed, which includes
+// new payloads and the pause annotation.
+func (c *Controller) enqueueStateKey(obj any) {
+	meta, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+	for _, key := range c.keys {
+		if key == meta.GetName() {
+			c.queue.Add(key)
+		}
+	}
+}
+
+func (c *Controller) processNext(ctx context.Context, reconcile func(context.Context, string) error) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	err := reconcile(ctx, key)
+	switch {
+	case errors.Is(err, state.ErrNotFound):
+		// Retrying cannot bring deleted state back; a new payload is
+		// queued by its watch event.
+		log.Printf("[Controller] Nothing to reconcile for %s: %v", key, err)
+	case err != nil:
+		log.Printf("[Controller] Reconciling %s failed (retry %d): %v", key, c.queue.NumRequeues(key)+1, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// Reconcile re-applies the graph recorded under key if any object drifted
+// or the last apply left failures, recording an Event on every object whose
+// drift it corrected. Paused keys are skipped. A key with no recorded state
+// stops being tracked and returns an error wrapping state.ErrNotFound,
+// which the work queue does not retry. Re-applying holds the store's lock
+// on key when the store is a state.Locker, and is abandoned with
+// state.ErrConflict if another apply recorded new state since it was loaded.
+func (c *Controller) Reconcile(ctx context.Context, key string) (err error) {
+	paused, err := c.paused(ctx, key)
+	if err != nil {
+		return err
+	}
+	if paused {
+		log.Printf("[Controller] %s is paused", key)
+		return nil
+	}
+
+	payload, err := c.store.Load(ctx, key)
+	if errors.Is(err, state.ErrNotFound) {
+		c.track(key, &ast.DAG{})
+	}
+	if err != nil {
+		return fmt.Errorf("load state %s: %w", key, err)
+	}
+	dag, err := ast.Deserialize(payload)
+	if errors.Is(err, ast.ErrNewerSchema) {
+		return fmt.Errorf("state %s: %w", key, err)
+	} else if err != nil {
+		return fmt.Errorf("state %s: %w: %v", key, engine.ErrCorruptState, err)
+	}
+	c.track(key, dag)
+
+	report, err := c.engine.DetectDrift(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !report.HasDrift() && len(dag.Status) == 0 {
+		return nil
+	}
+
+	ctx, unlock, err := state.Lock(ctx, c.store, key)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, unlock()) }()
+	// Re-applying what a concurrent apply replaced would undo it.
+	if current, err := c.store.Load(ctx, key); err != nil {
+		return fmt.Errorf("load state %s: %w", key, err)
+	} else if !bytes.Equal(current, payload) {
+		return fmt.Errorf("state %s: %w", key, state.ErrConflict)
+	}
+
+	log.Printf("[Controller] Correcting %d drifted objects in %s", len(report.Objects), key)
+	if len(dag.SignedPayload) > 0 {
+		// Re-apply the graph as signed rather than as recorded, which a
+		// failed run may have left short of it.
+		payload = dag.SignedPayload
+	}
+	applyErr := c.engine.Apply(ctx, payload, key)
+	failed := make(map[string]bool)
+	var nodeErrs *engine.ApplyError
+	if errors.As(applyErr, &nodeErrs) {
+		versions := apiVersions(dag)
+		for _, ne := range nodeErrs.Errors {
+			id := ne.Kind + "/" + ne.Namespace + "/" + ne.Name
+			failed[id] = true
+			c.recorder.Eventf(reference(versions[id], ne.Kind, ne.Namespace, ne.Name), corev1.EventTypeWarning, ReasonApplyFailed,
+				"kube-goat could not %s %s for %s: %v", ne.Op, ne.Name, key, ne.Err)
+		}
+	}
+	for _, obj := range report.Objects {
+		if failed[obj.Kind+"/"+obj.Namespace+"/"+obj.Name] {
+			continue
+		}
+		c.recorder.Eventf(reference(obj.APIVersion, obj.Kind, obj.Namespace, obj.Name), corev1.EventTypeNormal, ReasonDriftCorrected,
+			"kube-goat restored %s from %s", describeDrift(obj), key)
+	}
+	return applyErr
+}
+
+// paused reports whether the key's state Secret or ConfigMap carries
+// PausedAnnotation. Only state kept in the cluster can be paused.
+func (c *Controller) paused(ctx context.Context, key string) (bool, error) {
+	ns, ok := stateNamespace(c.store)
+	if !ok {
+		return false, nil
+	}
+	var obj metav1.Object
+	var err error
+	if _, ok := c.store.(*state.ConfigMapStore); ok {
+		obj, err = c.client.CoreV1().ConfigMaps(ns).Get(ctx, key, metav1.GetOptions{})
+	} else {
+		obj, err = c.client.CoreV1().Secrets(ns).Get(ctx, key, metav1.GetOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		// Missing state is reported by the store.
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("load state %s: %w", key, err)
+	}
+	return obj.GetAnnotations()[PausedAnnotation] == "true", nil
+}
+
+// stateNamespace returns the namespace of the Secrets or ConfigMaps holding
+// state, for stores kept in the cluster.
+func stateNamespace(store state.Store) (string, bool) {
+	switch s := store.(type) {
+	case *state.KubernetesStore:
+		return s.Namespace(), true
+	case *state.ConfigMapStore:
+		return s.Namespace(), true
+	}
+	return "", false
+}
+
+// track remembers which key manages each object so watch events can be
+// routed back to it.
+func (c *Controller) track(key string, dag *ast.DAG) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, owner := range c.owners {
+		if owner == key {
+			delete(c.owners, id)
+		}
+	}
+	for _, node := range dag.Nodes {
+		c.owners[node.Identity()] = key
+	}
+}
+
+// apiVersions maps the identity of every node of dag to the apiVersion of
+// its object, which Events need to be attached to it.
+func apiVersions(dag *ast.DAG) map[string]string {
+	versions := make(map[string]string, len(dag.Nodes))
+	for _, node := range dag.Nodes {
+		if obj, err := render.Object(node); err == nil {
+			versions[node.Identity()] = obj.GetAPIVersion()
+		}
+	}
+	return versions
+}
+
+func reference(apiVersion, kind, namespace, name string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{APIVersion: apiVersion, Kind: kind, Namespace: namespace, Name: name}
+}
+
+func describeDrift(obj engine.ObjectDrift) string {
+	if obj.Missing {
+		return "the deleted object"
+	}
+	paths := make([]string, len(obj.Fields))
+	for i, f := range obj.Fields {
+		paths[i] = f.Path
+	}
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func setup(t *testing.T) (*fake.Clientset, *state.KubernetesStore, *engine.Engine) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	store := state.NewKubernetesStore(client, "goat")
+	eng := engine.NewEngineForClients(client, nil, nil, store)
+
+	payload, err := dsl.NewGraph().
+		Add(dsl.NewDeployment("web", "nginx:1.27").Replicas(3)).
+		Build().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Apply(context.Background(), payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	return client, store, eng
+}
+
+func scaleWeb(t *testing.T, client *fake.Clientset, replicas int32) {
+	t.Helper()
+	ctx := context.Background()
+	dep, err := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep.Spec.Replicas = &replicas
+	if _, err := client.AppsV1().Deployments("default").Update(ctx, dep, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func replicas(t *testing.T, client *fake.Clientset) int32 {
+	t.Helper()
+	dep, err := client.AppsV1().Deployments("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *dep.Spec.Replicas
+}
+
+func TestReconcile_CorrectsDrift(t *testing.T) {
+	client, store, eng := setup(t)
+	recorder := record.NewFakeRecorder(10)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(recorder))
+	ctx := context.Background()
+
+	// Nothing drifted: no writes.
+	client.ClearActions()
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected %s %s without drift", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	scaleWeb(t, client, 1)
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := replicas(t, client); got != 3 {
+		t.Errorf("expected replicas restored to 3, got %d", got)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, ReasonDriftCorrected) || !strings.Contains(event, "spec.replicas") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a DriftCorrected event")
+	}
+}
+
+// racingStore runs beforeLock, standing in for another apply, just before
+// its lock is taken.
+type racingStore struct {
+	*state.KubernetesStore
+	beforeLock func()
+}
+
+func (s *racingStore) Lock(ctx context.Context, key string) (func() error, error) {
+	if s.beforeLock != nil {
+		s.beforeLock()
+		s.beforeLock = nil
+	}
+	return s.KubernetesStore.Lock(ctx, key)
+}
+
+func TestReconcile_ConcurrentApply(t *testing.T) {
+	client, store, eng := setup(t)
+	racing := &racingStore{KubernetesStore: store}
+	c := New(eng, racing, []string{"env"}, WithEventRecorder(record.NewFakeRecorder(10)))
+	ctx := context.Background()
+
+	scaleWeb(t, client, 1)
+	racing.beforeLock = func() {
+		payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx:1.27").Replicas(5)).Build().Serialize()
+		if err := eng.Apply(ctx, payload, "env"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Reconcile(ctx, "env"); !errors.Is(err, state.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if got := replicas(t, client); got != 5 {
+		t.Errorf("the concurrent apply was undone, replicas = %d", got)
+	}
+}
+
+func TestReconcile_Paused(t *testing.T) {
+	client, store, eng := setup(t)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(record.NewFakeRecorder(10)))
+	ctx := context.Background()
+
+	secret, _ := client.CoreV1().Secrets("goat").Get(ctx, "env", metav1.GetOptions{})
+	secret.Annotations = map[string]string{PausedAnnotation: "true"}
+	client.CoreV1().Secrets("goat").Update(ctx, secret, metav1.UpdateOptions{})
+
+	scaleWeb(t, client, 1)
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := replicas(t, client); got != 1 {
+		t.Errorf("paused key was reconciled, replicas = %d", got)
+	}
+}
+
+func TestReconcile_PausedConfigMap(t *testing.T) {
+	client, _, _ := setup(t)
+	store := state.NewConfigMapStore(client, "goat")
+	eng := engine.NewEngineForClients(client, nil, nil, store)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(record.NewFakeRecorder(10)))
+	ctx := context.Background()
+
+	payload, _ := state.NewKubernetesStore(client, "goat").Load(ctx, "env")
+	if err := store.Save(ctx, "env", payload); err != nil {
+		t.Fatal(err)
+	}
+	cm, _ := client.CoreV1().ConfigMaps("goat").Get(ctx, "env", metav1.GetOptions{})
+	cm.Annotations[PausedAnnotation] = "true"
+	client.CoreV1().ConfigMaps("goat").Update(ctx, cm, metav1.UpdateOptions{})
+
+	scaleWeb(t, client, 1)
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if got := replicas(t, client); got != 1 {
+		t.Errorf("paused key was reconciled, replicas = %d", got)
+	}
+}
+
+func TestProcessNext_RetriesFailedCreates(t *testing.T) {
+	client, store, eng := setup(t)
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	c := New(eng, store, []string{"env"}, WithEventRecorder(recorder), WithBackoff(time.Hour, time.Hour))
+	ctx := context.Background()
+
+	// The create fails in the apply and in the first retry.
+	failures := 2
+	client.PrependReactor("create", "deployments", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 && action.(ktesting.CreateAction).GetObject().(metav1.Object).GetName() == "api" {
+			failures--
+			return true, nil, errors.New("simulated CREATE error")
+		}
+		return false, nil, nil
+	})
+	payload, _ := dsl.NewGraph().
+		Add(dsl.NewDeployment("web", "nginx:1.27").Replicas(3)).
+		Add(dsl.NewDeployment("api", "nginx:1.27")).
+		Build().Serialize()
+	if err := eng.Apply(ctx, payload, "env"); err == nil {
+		t.Fatal("expected the create to fail")
+	}
+
+	c.queue.Add("env")
+	c.processNext(ctx, c.Reconcile)
+	if n := c.queue.NumRequeues("env"); n != 1 {
+		t.Fatalf("expected the failed create to be retried with backoff, got %d requeues", n)
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, ReasonApplyFailed) || !strings.Contains(event, "apiVersion=apps/v1") {
+			t.Errorf("expected an ApplyFailed event attached to the Deployment, got %q", event)
+		}
+	default:
+		t.Error("expected an ApplyFailed event")
+	}
+
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("expected the retry to create the Deployment, got %v", err)
+	}
+	if _, err := client.AppsV1().Deployments("default").Get(ctx, "api", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the retry to create the Deployment, got %v", err)
+	}
+	if report, _ := eng.DetectDrift(ctx, "env"); report.Checked != 2 {
+		t.Errorf("expected both Deployments to be recorded, checked %d", report.Checked)
+	}
+}
+
+func TestProcessNext_BacksOff(t *testing.T) {
+	client, store, eng := setup(t)
+	recorder := record.NewFakeRecorder(10)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(recorder), WithBackoff(time.Hour, time.Hour))
+	ctx := context.Background()
+
+	scaleWeb(t, client, 1)
+	client.PrependReactor("update", "deployments", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("simulated UPDATE error")
+	})
+	c.queue.Add("env")
+	c.processNext(ctx, c.Reconcile)
+	if n := c.queue.NumRequeues("env"); n != 1 {
+		t.Errorf("expected the failed key to be requeued with backoff, got %d requeues", n)
+	}
+	if c.queue.Len() != 0 {
+		t.Error("expected the retry to wait for the backoff delay")
+	}
+	if event := <-recorder.Events; !strings.Contains(event, ReasonApplyFailed) {
+		t.Errorf("expected an ApplyFailed event, got %q", event)
+	}
+}
+
+func TestProcessNext_ForgetsDeletedState(t *testing.T) {
+	client, store, eng := setup(t)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(record.NewFakeRecorder(10)), WithBackoff(time.Hour, time.Hour))
+	ctx := context.Background()
+
+	if err := c.Reconcile(ctx, "env"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if err := store.Delete(ctx, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reconcile(ctx, "env"); !errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected state.ErrNotFound, got %v", err)
+	}
+
+	c.queue.Add("env")
+	c.processNext(ctx, c.Reconcile)
+	if n := c.queue.NumRequeues("env"); n != 0 {
+		t.Errorf("expected deleted state not to be retried, got %d requeues", n)
+	}
+	if len(c.owners) != 0 {
+		t.Errorf("expected the deleted key's objects to be untracked, got %v", c.owners)
+	}
+	if got := replicas(t, client); got != 3 {
+		t.Errorf("expected the objects to be left alone, replicas = %d", got)
+	}
+}
+
+func TestRun_ReactsToWatchEvents(t *testing.T) {
+	client, store, eng := setup(t)
+	c := New(eng, store, []string{"env"}, WithEventRecorder(record.NewFakeRecorder(100)), WithResync(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run failed: %v", err)
+		}
+	}()
+
+	// Wait for the initial reconcile to learn which objects "env" manages.
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.owners["Deployment/default/web"] == "env", nil
+	})
+	if err != nil {
+		t.Fatal("controller never reconciled env")
+	}
+
+	scaleWeb(t, client, 1)
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return replicas(t, client) == 3, nil
+	})
+	if err != nil {
+		t.Error("drift was not corrected from the watch event")
+	}
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"time"
+
+	"k8s.io/client-go/tools/record"
+)
+
+// Option customizes a Controller.
+type Option func(*Controller)
+
+// WithNamespace limits the watched Services and Deployments to one
+// namespace. By default all namespaces are watched.
+func WithNamespace(ns string) Option {
+	return func(c *Controller) {
+		c.namespace = ns
+	}
+}
+
+// WithResync sets how often every key is reconciled without a watch event.
+func WithResync(d time.Duration) Option {
+	return func(c *Controller) {
+		c.resync = d
+	}
+}
+
+// WithBackoff sets the first and the longest delay before retrying a key
+// whose reconciliation failed. Delays double on every consecutive failure.
+func WithBackoff(base, max time.Duration) Option {
+	return func(c *Controller) {
+		c.baseDelay, c.maxDelay = base, max
+	}
+}
+
+// WithLeaderElection makes Run wait for the named Lease so only one replica
+// reconciles at a time.
+func WithLeaderElection(namespace, name, identity string) Option {
+	return func(c *Controller) {
+		c.lease = &leaseConfig{namespace: namespace, name: name, identity: identity}
+	}
+}
+
+// WithEventRecorder replaces the recorder publishing Events to the API server.
+func WithEventRecorder(r record.EventRecorder) Option {
+	return func(c *Controller) {
+		c.recorder = r
+	}
+}
+
+// WithClusterScopedKinds lets GoatStacks apply cluster-scoped objects of
+// the given kinds, written as "Kind" for the core group and "Kind.group"
+// otherwise, such as "Namespace" or "ClusterRole.rbac.authorization.k8s.io".
+// GoatStacks may only apply namespaced objects in their own namespace;
+// every other cluster-scoped object is refused.
+func WithClusterScopedKinds(kinds ...string) Option {
+	return func(c *Controller) {
+		if c.clusterKinds == nil {
+			c.clusterKinds = make(map[string]bool)
+		}
+		for _, kind := range kinds {
+			c.clusterKinds[kind] = true
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// StackCRD is the CustomResourceDefinition of GoatStack.
+//
+//go:embed goatstack-crd.yaml
+var StackCRD []byte
+
+// StackResource identifies GoatStack custom resources. A GoatStack's
+// spec.payload holds a graph compiled with compiler.Compile.
+var StackResource = schema.GroupVersionResource{Group: "kube-goat.io", Version: "v1alpha1", Resource: "goatstacks"}
+
+// StackKind is the kind of StackResource.
+const StackKind = "GoatStack"
+
+// StackFinalizer keeps a GoatStack around until everything it applied has
+// been destroyed.
+const StackFinalizer = "kube-goat.io/destroy"
+
+// Reasons of the Ready condition and of the Events recorded on a GoatStack.
+const (
+	ReasonApplied        = "Applied"
+	ReasonInvalidPayload = "InvalidPayload"
+	ReasonForbidden      = "Forbidden"
+	ReasonDestroyFailed  = "DestroyFailed"
+)
+
+// Phases of a node in a GoatStack's status.
+const (
+	PhaseApplied      = "Applied"
+	PhaseFailed       = "Failed"
+	PhaseSkipped      = "Skipped"
+	PhaseDeleteFailed = "DeleteFailed"
+)
+
+// StackStatus is the status the operator writes to a GoatStack.
+type StackStatus struct {
+	ObservedGeneration  int64              `json:"observedGeneration,omitempty"`
+	LastAppliedRevision string             `json:"lastAppliedRevision,omitempty"`
+	Nodes               []StackNodeStatus  `json:"nodes,omitempty"`
+	Conditions          []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// StackNodeStatus is the outcome of the last apply for one node.
+type StackNodeStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Phase     string `json:"phase"`
+	Message   string `json:"message,omitempty"`
+}
+
+// StackController applies the payloads published in GoatStack resources,
+// prunes nodes removed from them and destroys everything a GoatStack
+// applied when it is deleted. State is kept in the engine's store under
+// StackStateKey. It shares its options with Controller; WithNamespace
+// limits the watched GoatStacks.
+//
+// The operator applies payloads with its own permissions, so a GoatStack
+// may only manage namespaced objects in its own namespace, and
+// cluster-scoped objects of the kinds allowed with WithClusterScopedKinds.
+// Payloads reaching further are refused before anything is applied.
+type StackController struct {
+	*Controller
+	dyn dynamic.Interface
+}
+
+// NewStackController creates a StackController applying GoatStacks through
+// eng. dyn must be able to read and update GoatStacks.
+func NewStackController(eng *engine.Engine, dyn dynamic.Interface, opts ...Option) *StackController {
+	return &StackController{Controller: New(eng, nil, nil, opts...), dyn: dyn}
+}
+
+// StackStateKey is the state key a GoatStack's graph is recorded under.
+func StackStateKey(namespace, name string) string {
+	return "goatstack." + namespace + "." + name
+}
+
+// Run reconciles GoatStacks until ctx is cancelled, first acquiring the
+// leader lease when leader election is enabled.
+func (s *StackController) Run(ctx context.Context) error {
+	return s.lead(ctx, s.run)
+}
+
+func (s *StackController) run(ctx context.Context) error {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(s.dyn, 0, s.namespace, nil)
+	informer := factory.ForResource(StackResource).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.enqueue,
+		UpdateFunc: func(old, obj any) {
+			// Status writes bump the resourceVersion but not the
+			// generation; only spec, metadata and deletion matter.
+			o, n := old.(metav1.Object), obj.(metav1.Object)
+			if o.GetGeneration() != n.GetGeneration() || n.GetDeletionTimestamp() != nil ||
+				!reflect.DeepEqual(o.GetAnnotations(), n.GetAnnotations()) {
+				s.enqueue(obj)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	s.loop(ctx, func() {
+		for _, obj := range informer.GetStore().List() {
+			s.enqueue(obj)
+		}
+	}, s.Reconcile)
+	return nil
+}
+
+func (s *StackController) enqueue(obj any) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	s.queue.Add(key)
+}
+
+// Reconcile applies the GoatStack named by key ("namespace/name") and
+// writes the outcome to its status. A stack whose payload was already
+// applied is re-applied only when its objects drifted.
+func (s *StackController) Reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	stacks := s.dyn.Resource(StackResource).Namespace(namespace)
+	stack, err := stacks.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	stateKey := StackStateKey(namespace, name)
+
+	if stack.GetDeletionTimestamp() != nil {
+		return s.finalize(ctx, stacks, stack, stateKey)
+	}
+	if !slices.Contains(stack.GetFinalizers(), StackFinalizer) {
+		stack.SetFinalizers(append(stack.GetFinalizers(), StackFinalizer))
+		if stack, err = stacks.Update(ctx, stack, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+	if stack.GetAnnotations()[PausedAnnotation] == "true" {
+		log.Printf("[Controller] GoatStack %s is paused", key)
+		return nil
+	}
+
+	status, err := stackStatus(stack)
+	if err != nil {
+		return err
+	}
+	next := *status
+	next.ObservedGeneration = stack.GetGeneration()
+	next.Conditions = slices.Clone(status.Conditions)
+
+	payload, dag, err := stackPayload(stack)
+	if err != nil {
+		next.Nodes = nil
+		setReady(&next, stack.GetGeneration(), metav1.ConditionFalse, ReasonInvalidPayload, err.Error())
+		// Retrying cannot fix the payload; the next spec change will.
+		return s.writeStatus(ctx, stacks, stack, status, &next)
+	}
+	revision := payloadRevision(payload)
+	if err := s.checkScope(namespace, dag); err != nil {
+		var forbidden *scopeError
+		if !errors.As(err, &forbidden) {
+			// The scope of a kind could not be looked up, e.g. its CRD
+			// is not installed yet.
+			setReady(&next, stack.GetGeneration(), metav1.ConditionFalse, ReasonApplyFailed, err.Error())
+			return errors.Join(err, s.writeStatus(ctx, stacks, stack, status, &next))
+		}
+		next.Nodes = nil
+		setReady(&next, stack.GetGeneration(), metav1.ConditionFalse, ReasonForbidden, err.Error())
+		s.recorder.Eventf(stack, corev1.EventTypeWarning, ReasonForbidden, "Refusing revision %s: %v", revision, err)
+		// Retrying cannot widen the stack's scope; the next spec change
+		// may narrow the payload.
+		return s.writeStatus(ctx, stacks, stack, status, &next)
+	}
+
+	var drifted []engine.ObjectDrift
+	if status.ObservedGeneration == stack.GetGeneration() && status.LastAppliedRevision == revision &&
+		apimeta.IsStatusConditionTrue(status.Conditions, "Ready") {
+		report, err := s.engine.DetectDrift(ctx, stateKey)
+		if err != nil {
+			return err
+		}
+		if !report.HasDrift() {
+			return nil
+		}
+		drifted = report.Objects
+	}
+
+	log.Printf("[Controller] Applying GoatStack %s revision %s", key, revision)
+	applyErr := s.engine.Apply(ctx, payload, stateKey)
+	var nodeErrs *engine.ApplyError
+	if applyErr != nil && !errors.As(applyErr, &nodeErrs) {
+		// Nothing was attempted, e.g. the state could not be read.
+		setReady(&next, stack.GetGeneration(), metav1.ConditionFalse, ReasonApplyFailed, applyErr.Error())
+		return errors.Join(applyErr, s.writeStatus(ctx, stacks, stack, status, &next))
+	}
+
+	next.Nodes = nodeStatuses(dag, nodeErrs)
+	if applyErr != nil {
+		setReady(&next, stack.GetGeneration(), metav1.ConditionFalse, ReasonApplyFailed, applyErr.Error())
+		s.recorder.Eventf(stack, corev1.EventTypeWarning, ReasonApplyFailed, "Applying revision %s failed: %v", revision, applyErr)
+	} else {
+		next.LastAppliedRevision = revision
+		setReady(&next, stack.GetGeneration(), metav1.ConditionTrue, ReasonApplied, fmt.Sprintf("Applied revision %s", revision))
+		for _, obj := range drifted {
+			s.recorder.Eventf(stack, corev1.EventTypeNormal, ReasonDriftCorrected,
+				"Restored %s of %s %s/%s", describeDrift(obj), obj.Kind, obj.Namespace, obj.Name)
+		}
+	}
+	return errors.Join(applyErr, s.writeStatus(ctx, stacks, stack, status, &next))
+}
+
+// finalize destroys what a deleted GoatStack applied, then releases it.
+func (s *StackController) finalize(ctx context.Context, stacks dynamic.ResourceInterface, stack *unstructured.Unstructured, stateKey string) error {
+	if !slices.Contains(stack.GetFinalizers(), StackFinalizer) {
+		return nil
+	}
+	if err := s.engine.Destroy(ctx, stateKey); err != nil && !stateMissing(err) {
+		s.recorder.Eventf(stack, corev1.EventTypeWarning, ReasonDestroyFailed, "Destroying the stack failed: %v", err)
+		return err
+	}
+	stack.SetFinalizers(slices.DeleteFunc(stack.GetFinalizers(), func(f string) bool { return f == StackFinalizer }))
+	_, err := stacks.Update(ctx, stack, metav1.UpdateOptions{})
+	return err
+}
+
+// scopeError lists the nodes of a GoatStack's payload outside what it may
+// manage.
+type scopeError struct {
+	nodes []string
+}
+
+func (e *scopeError) Error() string {
+	return "payload reaches outside the GoatStack: " + strings.Join(e.nodes, "; ")
+}
+
+// checkScope returns a *scopeError if dag manages namespaced objects
+// outside namespace or cluster-scoped objects of kinds not allowed with
+// WithClusterScopedKinds.
+func (s *StackController) checkScope(namespace string, dag *ast.DAG) error {
+	keys := make([]string, 0, len(dag.Nodes))
+	for key := range dag.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var refused []string
+	for _, key := range keys {
+		node := dag.Nodes[key]
+		namespaced, err := s.engine.Namespaced(node)
+		if err != nil {
+			return err
+		}
+		switch kind := groupKind(node); {
+		case namespaced && node.Namespace != namespace:
+			refused = append(refused, fmt.Sprintf("%s %s/%s is not in namespace %s", node.ObjectKind(), node.Namespace, node.Name, namespace))
+		case !namespaced && !s.clusterKinds[kind]:
+			refused = append(refused, fmt.Sprintf("cluster-scoped %s %s is not an allowed kind", kind, node.Name))
+		}
+	}
+	if len(refused) > 0 {
+		return &scopeError{nodes: refused}
+	}
+	return nil
+}
+
+// groupKind names the kind of the object a node manages as "Kind" or
+// "Kind.group".
+func groupKind(node *ast.Node) string {
+	spec, ok := node.Spec.(*ast.CustomSpec)
+	if !ok || node.Kind != "Custom" {
+		return node.Kind
+	}
+	gv, err := schema.ParseGroupVersion(spec.APIVersion)
+	if err != nil {
+		return spec.Kind
+	}
+	return gv.WithKind(spec.Kind).GroupKind().String()
+}
+
+// stateMissing reports whether err means nothing was ever recorded.
+func stateMissing(err error) bool {
+	return errors.Is(err, state.ErrNotFound)
+}
+
+func setReady(status *StackStatus, generation int64, ready metav1.ConditionStatus, reason, message string) {
+	apimeta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               "Ready",
+		Status:             ready,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// writeStatus updates the status subresource when it changed.
+func (s *StackController) writeStatus(ctx context.Context, stacks dynamic.ResourceInterface, stack *unstructured.Unstructured, old, next *StackStatus) error {
+	if reflect.DeepEqual(old, next) {
+		return nil
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(next)
+	if err != nil {
+		return err
+	}
+	stack = stack.DeepCopy()
+	stack.Object["status"] = obj
+	_, err = stacks.UpdateStatus(ctx, stack, metav1.UpdateOptions{})
+	return err
+}
+
+func stackStatus(stack *unstructured.Unstructured) (*StackStatus, error) {
+	status := &StackStatus{}
+	raw, ok := stack.Object["status"].(map[string]any)
+	if !ok {
+		return status, nil
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, status); err != nil {
+		return nil, fmt.Errorf("GoatStack %s/%s: status: %w", stack.GetNamespace(), stack.GetName(), err)
+	}
+	return status, nil
+}
+
+// stackPayload decodes spec.payload, which the API server stores base64
+// encoded.
+func stackPayload(stack *unstructured.Unstructured) ([]byte, *ast.DAG, error) {
+	encoded, _, err := unstructured.NestedString(stack.Object, "spec", "payload")
+	if err != nil {
+		return nil, nil, fmt.Errorf("spec.payload: %w", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spec.payload: %w", err)
+	}
+	dag, err := ast.Deserialize(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spec.payload: %w", err)
+	}
+	return payload, dag, nil
+}
+
+// payloadRevision identifies a payload by the start of its SHA-256 digest.
+func payloadRevision(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:6])
+}
+
+// nodeStatuses lists every node of dag, and every removed node whose
+// deletion failed, with the outcome recorded in errs.
+func nodeStatuses(dag *ast.DAG, errs *engine.ApplyError) []StackNodeStatus {
+	failed := make(map[string]*engine.NodeError)
+	var deletes []StackNodeStatus
+	if errs != nil {
+		for _, ne := range errs.Errors {
+			if ne.Op == "delete" {
+				deletes = append(deletes, StackNodeStatus{Kind: ne.Kind, Namespace: ne.Namespace, Name: ne.Name,
+					Phase: PhaseDeleteFailed, Message: ne.Err.Error()})
+				continue
+			}
+			failed[ne.Kind+"/"+ne.Namespace+"/"+ne.Name] = ne
+		}
+	}
+
+	nodes := make([]StackNodeStatus, 0, len(dag.Nodes)+len(deletes))
+	for _, node := range dag.Nodes {
+		status := StackNodeStatus{Kind: node.ObjectKind(), Namespace: node.Namespace, Name: node.Name, Phase: PhaseApplied}
+		if ne, ok := failed[node.Identity()]; ok {
+			status.Phase, status.Message = PhaseFailed, ne.Err.Error()
+			if errors.Is(ne, engine.ErrDependencyFailed) {
+				status.Phase = PhaseSkipped
+			}
+		}
+		nodes = append(nodes, status)
+	}
+	nodes = append(nodes, deletes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return nodes
+}
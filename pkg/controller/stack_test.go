@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/engine"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+)
+
+type stackFixture struct {
+	client   *fake.Clientset
+	dyn      *dynamicfake.FakeDynamicClient
+	recorder *record.FakeRecorder
+	ctrl     *StackController
+}
+
+func newStackFixture(t *testing.T, objects ...runtime.Object) *stackFixture {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{StackResource: StackKind + "List"}, objects...)
+	eng := engine.NewEngineForClients(client, nil, nil, state.NewKubernetesStore(client, "goat"))
+	recorder := record.NewFakeRecorder(10)
+	return &stackFixture{client: client, dyn: dyn, recorder: recorder,
+		ctrl: NewStackController(eng, dyn, WithEventRecorder(recorder))}
+}
+
+func stackPayloadFor(t *testing.T, g *dsl.GraphBuilder) []byte {
+	t.Helper()
+	payload, err := g.Build().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func newStack(name string, generation int64, payload []byte) *unstructured.Unstructured {
+	stack := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": StackResource.GroupVersion().String(),
+		"kind":       StackKind,
+		"metadata":   map[string]any{"name": name, "namespace": "apps"},
+		"spec":       map[string]any{"payload": base64.StdEncoding.EncodeToString(payload)},
+	}}
+	stack.SetGeneration(generation)
+	return stack
+}
+
+func (f *stackFixture) get(t *testing.T, name string) (*unstructured.Unstructured, *StackStatus) {
+	t.Helper()
+	stack, err := f.dyn.Resource(StackResource).Namespace("apps").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := stackStatus(stack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stack, status
+}
+
+func (f *stackFixture) update(t *testing.T, stack *unstructured.Unstructured) {
+	t.Helper()
+	if _, err := f.dyn.Resource(StackResource).Namespace("apps").Update(context.Background(), stack, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func webGraph() *dsl.GraphBuilder {
+	svc := dsl.NewService("web", 80, 8080).Namespace("apps")
+	return dsl.NewGraph().Add(svc).Add(dsl.NewDeployment("web", "nginx:1.27").Namespace("apps").AttachedTo(svc))
+}
+
+func TestStackReconcile_AppliesAndPrunes(t *testing.T) {
+	payload := stackPayloadFor(t, webGraph())
+	f := newStackFixture(t, newStack("web", 1, payload))
+	ctx := context.Background()
+
+	if err := f.ctrl.Reconcile(ctx, "apps/web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	stack, status := f.get(t, "web")
+	if got := stack.GetFinalizers(); len(got) != 1 || got[0] != StackFinalizer {
+		t.Errorf("expected finalizer %s, got %v", StackFinalizer, got)
+	}
+	if !apimeta.IsStatusConditionTrue(status.Conditions, "Ready") {
+		t.Errorf("expected Ready, got %+v", status.Conditions)
+	}
+	if status.ObservedGeneration != 1 || status.LastAppliedRevision != payloadRevision(payload) {
+		t.Errorf("unexpected generation/revision: %+v", status)
+	}
+	if len(status.Nodes) != 2 || status.Nodes[0].Phase != PhaseApplied || status.Nodes[1].Phase != PhaseApplied {
+		t.Errorf("expected two applied nodes, got %+v", status.Nodes)
+	}
+	if _, err := f.client.AppsV1().Deployments("apps").Get(ctx, "web", metav1.GetOptions{}); err != nil {
+		t.Errorf("Deployment was not created: %v", err)
+	}
+
+	// Up to date and not drifted: nothing is written.
+	f.client.ClearActions()
+	f.dyn.ClearActions()
+	if err := f.ctrl.Reconcile(ctx, "apps/web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	for _, action := range append(f.client.Actions(), f.dyn.Actions()...) {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected %s %s on an up-to-date stack", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+
+	// Publishing a payload without the Service prunes it.
+	pruned := stackPayloadFor(t, dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx:1.27").Namespace("apps")))
+	unstructured.SetNestedField(stack.Object, base64.StdEncoding.EncodeToString(pruned), "spec", "payload")
+	stack.SetGeneration(2)
+	f.update(t, stack)
+	if err := f.ctrl.Reconcile(ctx, "apps/web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if _, err := f.client.CoreV1().Services("apps").Get(ctx, "web", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the removed Service to be pruned, got %v", err)
+	}
+	_, status = f.get(t, "web")
+	if status.ObservedGeneration != 2 || status.LastAppliedRevision != payloadRevision(pruned) || len(status.Nodes) != 1 {
+		t.Errorf("unexpected status after pruning: %+v", status)
+	}
+}
+
+func TestStackReconcile_ReportsNodeFailures(t *testing.T) {
+	f := newStackFixture(t, newStack("web", 1, stackPayloadFor(t, webGraph())))
+	f.client.PrependReactor("create", "services", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("simulated CREATE error")
+	})
+
+	err := f.ctrl.Reconcile(context.Background(), "apps/web")
+	var applyErr *engine.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected an ApplyError to trigger a retry, got %v", err)
+	}
+	_, status := f.get(t, "web")
+	ready := apimeta.FindStatusCondition(status.Conditions, "Ready")
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != ReasonApplyFailed {
+		t.Errorf("expected Ready=False/%s, got %+v", ReasonApplyFailed, ready)
+	}
+	if status.LastAppliedRevision != "" {
+		t.Errorf("a failed apply must not record a revision, got %q", status.LastAppliedRevision)
+	}
+	phases := map[string]string{}
+	for _, n := range status.Nodes {
+		phases[n.Kind] = n.Phase
+	}
+	if phases["Service"] != PhaseFailed || phases["Deployment"] != PhaseSkipped {
+		t.Errorf("unexpected node phases %v", phases)
+	}
+	if event := <-f.recorder.Events; !strings.Contains(event, ReasonApplyFailed) {
+		t.Errorf("expected an ApplyFailed event, got %q", event)
+	}
+}
+
+func TestStackReconcile_InvalidPayload(t *testing.T) {
+	f := newStackFixture(t, newStack("web", 1, []byte("not a graph")))
+	if err := f.ctrl.Reconcile(context.Background(), "apps/web"); err != nil {
+		t.Fatalf("an invalid payload should not be retried, got %v", err)
+	}
+	_, status := f.get(t, "web")
+	ready := apimeta.FindStatusCondition(status.Conditions, "Ready")
+	if ready == nil || ready.Reason != ReasonInvalidPayload {
+		t.Errorf("expected Ready reason %s, got %+v", ReasonInvalidPayload, ready)
+	}
+}
+
+func TestStackReconcile_RefusesOtherNamespaces(t *testing.T) {
+	payload := stackPayloadFor(t, dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx:1.27").Namespace("kube-system")))
+	f := newStackFixture(t, newStack("web", 1, payload))
+	if err := f.ctrl.Reconcile(context.Background(), "apps/web"); err != nil {
+		t.Fatalf("a forbidden payload should not be retried, got %v", err)
+	}
+	if _, err := f.client.AppsV1().Deployments("kube-system").Get(context.Background(), "web", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected nothing applied outside the stack's namespace, got %v", err)
+	}
+	_, status := f.get(t, "web")
+	ready := apimeta.FindStatusCondition(status.Conditions, "Ready")
+	if ready == nil || ready.Reason != ReasonForbidden || !strings.Contains(ready.Message, "kube-system") {
+		t.Errorf("expected Ready reason %s naming the namespace, got %+v", ReasonForbidden, ready)
+	}
+	if event := <-f.recorder.Events; !strings.Contains(event, ReasonForbidden) {
+		t.Errorf("expected a Forbidden event, got %q", event)
+	}
+}
+
+func TestStackReconcile_ClusterScopedKinds(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvr.GroupVersion().WithKind("ClusterRole"), apimeta.RESTScopeRoot)
+	role := dsl.NewCustom("rbac.authorization.k8s.io/v1", "ClusterRole", "reader").ClusterScoped()
+	payload := stackPayloadFor(t, dsl.NewGraph().Add(role))
+
+	for _, allowed := range []bool{false, true} {
+		client := fake.NewSimpleClientset()
+		dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{StackResource: StackKind + "List", gvr: "ClusterRoleList"}, newStack("web", 1, payload))
+		eng := engine.NewEngineForClients(client, dyn, mapper, state.NewKubernetesStore(client, "goat"))
+		opts := []Option{WithEventRecorder(record.NewFakeRecorder(10))}
+		if allowed {
+			opts = append(opts, WithClusterScopedKinds("ClusterRole.rbac.authorization.k8s.io"))
+		}
+		if err := NewStackController(eng, dyn, opts...).Reconcile(context.Background(), "apps/web"); err != nil {
+			t.Fatalf("allowed=%v: Reconcile failed: %v", allowed, err)
+		}
+		_, err := dyn.Resource(gvr).Get(context.Background(), "reader", metav1.GetOptions{})
+		if allowed && err != nil {
+			t.Errorf("expected the allowed ClusterRole to be applied, got %v", err)
+		}
+		if !allowed && !apierrors.IsNotFound(err) {
+			t.Errorf("expected the ClusterRole to be refused, got %v", err)
+		}
+	}
+}
+
+func TestStackReconcile_DestroysOnDeletion(t *testing.T) {
+	f := newStackFixture(t, newStack("web", 1, stackPayloadFor(t, webGraph())))
+	ctx := context.Background()
+	if err := f.ctrl.Reconcile(ctx, "apps/web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	stack, _ := f.get(t, "web")
+	now := metav1.Now()
+	stack.SetDeletionTimestamp(&now)
+	f.update(t, stack)
+	if err := f.ctrl.Reconcile(ctx, "apps/web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if _, err := f.client.AppsV1().Deployments("apps").Get(ctx, "web", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected the Deployment to be destroyed, got %v", err)
+	}
+	if stack, _ = f.get(t, "web"); len(stack.GetFinalizers()) != 0 {
+		t.Errorf("expected the finalizer to be released, got %v", stack.GetFinalizers())
+	}
+}
+
+func TestStackCRD(t *testing.T) {
+	var crd struct {
+		Spec struct {
+			Group string `json:"group"`
+			Names struct {
+				Kind   string `json:"kind"`
+				Plural string `json:"plural"`
+			} `json:"names"`
+			Versions []struct {
+				Name string `json:"name"`
+			} `json:"versions"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal(StackCRD, &crd); err != nil {
+		t.Fatal(err)
+	}
+	if crd.Spec.Group != StackResource.Group || crd.Spec.Names.Plural != StackResource.Resource ||
+		crd.Spec.Names.Kind != StackKind || crd.Spec.Versions[0].Name != StackResource.Version {
+		t.Errorf("CRD does not match StackResource: %+v", crd.Spec)
+	}
+}
@@ -23,13 +23,68 @@ func (g *GraphBuilder) Add(b Builder) *GraphBuilder {
 }
 
 // Build generates the final acyclic graph representing the infrastructure.
+// Nodes are keyed by name; resources sharing a name (typically a Service
+// and its Deployment) are all keyed by their identity instead, so their
+// keys do not depend on the order they were added in.
+// Dependencies on resources in the graph name their keys, so they never
+// resolve to a namesake; dependencies on resources left out of the graph
+// keep their name, which the compiler rejects as unknown.
 func (g *GraphBuilder) Build() *ast.DAG {
 	dag := &ast.DAG{
 		Nodes: make(map[string]*ast.Node),
 	}
+	var resources []Builder
+	built := make(map[Builder]*ast.Node, len(g.resources))
+	named := make(map[string]int, len(g.resources))
 	for _, res := range g.resources {
+		if _, added := built[res]; added {
+			continue
+		}
 		node := res.Build()
-		dag.Nodes[node.Name] = node
+		resources = append(resources, res)
+		built[res] = node
+		named[node.Name]++
+	}
+	keys := make(map[Builder]string, len(resources))
+	nodes := make(map[string]Builder, len(resources))
+	for _, res := range resources {
+		node := built[res]
+		key := node.Name
+		if named[key] > 1 {
+			key = node.Identity()
+		}
+		dag.Nodes[key] = node
+		keys[res] = key
+		nodes[key] = res
+	}
+	for key, node := range dag.Nodes {
+		res, ok := nodes[key].(dependent)
+		if !ok {
+			continue
+		}
+		for i, dep := range res.dependencies() {
+			if depKey, ok := keys[dep]; ok {
+				node.Dependencies[i] = depKey
+			}
+		}
 	}
 	return dag
 }
+
+// dependent is implemented by the builders that record the resources they
+// depend on, in the order of ast.Node.Dependencies.
+type dependent interface {
+	dependencies() []Builder
+}
+
+// names returns the names of the given resources.
+func names(deps []Builder) []string {
+	if len(deps) == 0 {
+		return nil
+	}
+	names := make([]string, len(deps))
+	for i, dep := range deps {
+		names[i] = dep.GetName()
+	}
+	return names
+}
@@ -3,6 +3,8 @@ package dsl
 import (
 	"reflect"
 	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
 )
 
 func TestServiceDSL(t *testing.T) {
@@ -22,13 +24,12 @@ func TestServiceDSL(t *testing.T) {
 		t.Errorf("Expected Namespace test-ns")
 	}
 
-	props := node.Properties
-	if props["port"] != int32(80) {
-		t.Errorf("Expected port 80")
+	spec := node.Spec.(*ast.ServiceSpec)
+	if ports := spec.Ports; len(ports) != 1 || ports[0].Port != 80 || ports[0].TargetPort != 8080 {
+		t.Errorf("Expected port 80 -> 8080, got %v", spec.Ports)
 	}
 
-	labels, ok := props["labels"].(map[string]string)
-	if !ok || labels["key"] != "val" {
+	if spec.Labels["key"] != "val" {
 		t.Errorf("Labels map missing or incorrect")
 	}
 }
@@ -56,14 +57,45 @@ func TestDeploymentDSL(t *testing.T) {
 		t.Errorf("Expected dependency on link-svc, got %v", node.Dependencies)
 	}
 
-	props := node.Properties
-	if props["replicas"] != int32(2) {
+	spec := node.Spec.(*ast.DeploymentSpec)
+	if spec.Replicas != 2 {
 		t.Errorf("Expected 2 replicas")
 	}
 
-	labels, ok := props["labels"].(map[string]string)
-	if !ok || labels["app"] != "test" {
-		t.Errorf("Labels map missing or attached incorrectly")
+	podLabels := spec.PodLabels
+	if podLabels[ServiceSelectorPrefix+"link-svc"] != "true" || podLabels[DeploymentSelectorLabel] != "my-dep" {
+		t.Errorf("Pod labels missing or attached incorrectly: %v", spec.PodLabels)
+	}
+	if labels := spec.Labels; labels["app"] != "" {
+		t.Errorf("Service metadata labels must not leak into the deployment: %v", labels)
+	}
+}
+
+func TestSelectorsIgnoreMetadataLabels(t *testing.T) {
+	svc := NewService("api", 80, 8080)
+	dep := NewDeployment("web", "nginx").AttachedTo(svc)
+	depSpec := func() *ast.DeploymentSpec { return dep.Build().Spec.(*ast.DeploymentSpec) }
+	svcSpec := func() *ast.ServiceSpec { return svc.Build().Spec.(*ast.ServiceSpec) }
+	before := depSpec().Selector
+	svcSelector := svcSpec().Selector
+
+	// Cosmetic labels added after attaching must not move any selector.
+	svc.Label("team", "x")
+	dep.Label("tier", "frontend")
+	if !reflect.DeepEqual(before, depSpec().Selector) {
+		t.Errorf("Deployment selector changed after adding a label")
+	}
+	if !reflect.DeepEqual(svcSelector, svcSpec().Selector) {
+		t.Errorf("Service selector changed after adding a label")
+	}
+
+	// Explicit selectors are resolved at Build time on both sides.
+	svc.Selector("app", "api")
+	if depSpec().PodLabels["app"] != "api" {
+		t.Errorf("Attached deployment pods should carry the explicit service selector")
+	}
+	if sel := svcSpec().Selector; !reflect.DeepEqual(sel, map[string]string{"app": "api"}) {
+		t.Errorf("Unexpected explicit selector: %v", sel)
 	}
 }
 
@@ -84,3 +116,120 @@ func TestGraphBuilder(t *testing.T) {
 		t.Errorf("Missing dep1 in DAG")
 	}
 }
+
+func TestGraphBuilder_SharedNames(t *testing.T) {
+	svc := NewService("web", 80, 8080)
+	web := NewDeployment("web", "nginx").AttachedTo(svc)
+
+	// Both are keyed by their identity whatever order they are added in,
+	// so the Deployment's dependency never resolves to itself.
+	for _, dag := range []*ast.DAG{NewGraph().Add(web).Add(svc).Build(), NewGraph().Add(svc).Add(web).Build()} {
+		if len(dag.Nodes) != 2 || dag.Nodes["Service/default/web"] == nil {
+			t.Fatalf("Expected both resources keyed by identity, got %v", dag.Nodes)
+		}
+		if got := dag.Nodes["Deployment/default/web"].Dependencies; !reflect.DeepEqual(got, []string{"Service/default/web"}) {
+			t.Errorf("Expected dependency on the Service's key, got %v", got)
+		}
+		if _, err := dag.TopologicalOrder(); err != nil {
+			t.Errorf("Expected an acyclic graph, got %v", err)
+		}
+	}
+
+	// Resources left out of the graph keep their name.
+	dag := NewGraph().Add(web).Build()
+	if got := dag.Nodes["web"].Dependencies; !reflect.DeepEqual(got, []string{"web"}) {
+		t.Errorf("Expected the bare name of the missing Service, got %v", got)
+	}
+}
+
+func TestServiceDSL_PortsAndTypes(t *testing.T) {
+	svc := NewService("multi", 80, 8080).PortName("http").
+		PortTo("metrics", 9090, "metrics").
+		Port("dns", 53, 53).Protocol("UDP").NodePort(30053).
+		Type(NodePort).
+		StickySessions(600)
+
+	spec := svc.Build().Spec.(*ast.ServiceSpec)
+	ports := spec.Ports
+	want := []ast.ServicePort{
+		{Name: "http", Protocol: "TCP", Port: 80, TargetPort: 8080},
+		{Name: "metrics", Protocol: "TCP", Port: 9090, TargetPortName: "metrics"},
+		{Name: "dns", Protocol: "UDP", Port: 53, TargetPort: 53, NodePort: 30053},
+	}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("Unexpected ports: %+v", ports)
+	}
+	if spec.Type != "NodePort" || spec.SessionAffinity != "ClientIP" || spec.SessionAffinityTimeout != 600 {
+		t.Errorf("Unexpected service spec: %+v", spec)
+	}
+
+	headless := NewService("db", 5432, 5432).Headless().Build().Spec.(*ast.ServiceSpec)
+	if headless.ClusterIP != "None" {
+		t.Errorf("Expected headless service, got %+v", headless)
+	}
+
+	ext := NewExternalService("upstream", "api.example.com").Build().Spec.(*ast.ServiceSpec)
+	if ext.Type != "ExternalName" || ext.ExternalName != "api.example.com" || ext.Selector != nil {
+		t.Errorf("Unexpected external service spec: %+v", ext)
+	}
+}
+
+func TestCustomDSL(t *testing.T) {
+	type issuerRef struct {
+		Name string `json:"name"`
+	}
+	type certSpec struct {
+		SecretName string    `json:"secretName"`
+		DNSNames   []string  `json:"dnsNames"`
+		Replicas   int32     `json:"replicas"`
+		IssuerRef  issuerRef `json:"issuerRef"`
+		Optional   *string   `json:"optional"`
+	}
+
+	svc := NewService("web", 80, 8080)
+	cert := NewCustom("cert-manager.io/v1", "Certificate", "web-tls").
+		Namespace("prod").
+		Label("team", "edge").
+		Spec(certSpec{SecretName: "web-tls", DNSNames: []string{"example.com"}, Replicas: 2, IssuerRef: issuerRef{Name: "le"}}).
+		DependsOn(svc)
+
+	node := cert.Build()
+	if node.Kind != "Custom" || node.Namespace != "prod" || !reflect.DeepEqual(node.Dependencies, []string{"web"}) {
+		t.Fatalf("Unexpected node: %+v", node)
+	}
+	spec := node.Spec.(*ast.CustomSpec)
+	content := spec.Content
+	want := map[string]any{
+		"secretName": "web-tls",
+		"dnsNames":   []any{"example.com"},
+		"replicas":   int64(2),
+		"issuerRef":  map[string]any{"name": "le"},
+	}
+	if !reflect.DeepEqual(content["spec"], want) {
+		t.Errorf("Unexpected spec: %#v", content["spec"])
+	}
+	if spec.Kind != "Certificate" || spec.APIVersion != "cert-manager.io/v1" {
+		t.Errorf("Unexpected type meta: %+v", spec)
+	}
+
+	// Map specs, extra fields and cluster scope round-trip through gob.
+	role := NewCustom("rbac.authorization.k8s.io/v1", "ClusterRole", "reader").
+		ClusterScoped().
+		Set("rules", []map[string]any{{"verbs": []string{"get"}}})
+	dag := NewGraph().Add(cert).Add(role).Build()
+	payload, err := dag.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize failed: %v", err)
+	}
+	if _, err := ast.Deserialize(payload); err != nil {
+		t.Fatalf("Deserialize failed: %v", err)
+	}
+	if dag.Nodes["reader"].Namespace != "" {
+		t.Errorf("Expected cluster-scoped resource")
+	}
+
+	bad := NewCustom("example.com/v1", "Widget", "w").Spec(map[string]any{"ch": make(chan int)}).Build()
+	if bad.Spec.(*ast.CustomSpec).Error == "" {
+		t.Errorf("Expected unencodable spec to be reported")
+	}
+}
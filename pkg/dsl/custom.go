@@ -0,0 +1,175 @@
+package dsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+// Custom builds any resource the DSL has no dedicated builder for, such as
+// cert-manager Certificates, Prometheus ServiceMonitors or in-house CRDs.
+type Custom struct {
+	apiVersion  string
+	kind        string
+	name        string
+	namespace   string
+	spec        any
+	fields      map[string]any
+	labels      map[string]string
+	annotations map[string]string
+	dependsOn   []Builder
+	protected   bool
+}
+
+// NewCustom enforces compile-time validation for required fields: apiVersion, kind, name.
+func NewCustom(apiVersion, kind, name string) *Custom {
+	return &Custom{
+		apiVersion:  apiVersion,
+		kind:        kind,
+		name:        name,
+		namespace:   "default",
+		fields:      make(map[string]any),
+		labels:      make(map[string]string),
+		annotations: make(map[string]string),
+	}
+}
+
+// Spec sets the resource spec from a typed struct (encoded through its json
+// tags) or a map. Typed structs give compile-time checking of the spec.
+func (c *Custom) Spec(spec any) *Custom {
+	c.spec = spec
+	return c
+}
+
+// Set assigns a top-level field other than spec, e.g. "data" or "rules".
+func (c *Custom) Set(field string, value any) *Custom {
+	c.fields[field] = value
+	return c
+}
+
+func (c *Custom) Label(key, value string) *Custom {
+	c.labels[key] = value
+	return c
+}
+
+func (c *Custom) Annotation(key, value string) *Custom {
+	c.annotations[key] = value
+	return c
+}
+
+func (c *Custom) Namespace(ns string) *Custom {
+	c.namespace = ns
+	return c
+}
+
+// ClusterScoped marks the resource as living outside any namespace.
+func (c *Custom) ClusterScoped() *Custom {
+	c.namespace = ""
+	return c
+}
+
+// DependsOn orders this resource after the given resources.
+func (c *Custom) DependsOn(deps ...Builder) *Custom {
+	for _, dep := range deps {
+		c.dependsOn = append(c.dependsOn, dep)
+	}
+	return c
+}
+
+func (c *Custom) GetName() string {
+	return c.name
+}
+
+func (c *Custom) dependencies() []Builder {
+	return c.dependsOn
+}
+
+// Protect keeps the engine from ever deleting the resource, whether by Destroy
+// or because it was removed from the graph.
+func (c *Custom) Protect() *Custom {
+	c.protected = true
+	return c
+}
+
+// Build compiles the declarative builder into a graph Node. The spec is
+// normalized to plain JSON values so it serializes like any other property.
+// A spec that cannot be encoded is recorded as the node's Error and
+// rejected by the compiler.
+func (c *Custom) Build() *ast.Node {
+	spec := &ast.CustomSpec{
+		APIVersion:  c.apiVersion,
+		Kind:        c.kind,
+		Labels:      c.labels,
+		Annotations: c.annotations,
+	}
+	content := make(map[string]any, len(c.fields)+1)
+	for k, v := range c.fields {
+		content[k] = v
+	}
+	if c.spec != nil {
+		content["spec"] = c.spec
+	}
+	if len(content) > 0 {
+		normalized, err := toJSONValue(content)
+		if err != nil {
+			spec.Error = fmt.Sprintf("invalid %s spec: %v", c.kind, err)
+		} else {
+			spec.Content = normalized
+		}
+	}
+
+	return &ast.Node{
+		Kind:         "Custom",
+		Name:         c.name,
+		Namespace:    c.namespace,
+		Dependencies: names(c.dependsOn),
+		Spec:         spec,
+		Protected:    c.protected,
+	}
+}
+
+// toJSONValue round-trips v through encoding/json, yielding only maps,
+// slices, strings, bools, int64 and float64. Null map entries are dropped
+// because gob cannot encode nil interface values.
+func toJSONValue(v any) (map[string]any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var out map[string]any
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return normalizeJSON(out).(map[string]any), nil
+}
+
+func normalizeJSON(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, e := range t {
+			if e == nil {
+				delete(t, k)
+				continue
+			}
+			t[k] = normalizeJSON(e)
+		}
+	case []any:
+		for i, e := range t {
+			t[i] = normalizeJSON(e)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		if f, err := t.Float64(); err == nil && !math.IsInf(f, 0) {
+			return f
+		}
+		return t.String()
+	}
+	return v
+}
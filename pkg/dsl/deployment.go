@@ -8,7 +8,11 @@ type Deployment struct {
 	image     string
 	replicas  int32
 	labels    map[string]string
-	dependsOn []string
+	selector  map[string]string
+	ports     []ast.ContainerPort
+	attached  []*Service
+	dependsOn []Builder
+	protected bool
 }
 
 // NewDeployment enforces compile-time validation for required fields: name, image.
@@ -19,6 +23,7 @@ func NewDeployment(name, image string) *Deployment {
 		image:     image,
 		replicas:  1,
 		labels:    make(map[string]string),
+		selector:  make(map[string]string),
 	}
 }
 
@@ -27,23 +32,47 @@ func (d *Deployment) Replicas(n int32) *Deployment {
 	return d
 }
 
+// Label adds a metadata label to the Deployment and its pods. Labels never
+// take part in the Deployment's selector.
 func (d *Deployment) Label(key, value string) *Deployment {
 	d.labels[key] = value
 	return d
 }
 
+// Selector pins spec.selector to the given label instead of the generated
+// one, e.g. to keep the immutable selector of a Deployment created outside
+// kube-goAT. The label is stamped onto the pods as well.
+func (d *Deployment) Selector(key, value string) *Deployment {
+	d.selector[key] = value
+	return d
+}
+
+// Port opens a named TCP container port that Services can target by name.
+func (d *Deployment) Port(name string, port int32) *Deployment {
+	d.ports = append(d.ports, ast.ContainerPort{Name: name, Port: port, Protocol: "TCP"})
+	return d
+}
+
 func (d *Deployment) Namespace(ns string) *Deployment {
 	d.namespace = ns
 	return d
 }
 
 // AttachedTo is an idiomatic way to reference another resource.
-// It implicitly links labels and enforces graph dependencies.
+// It stamps the Service's selector labels onto the pods and enforces graph
+// dependencies. The selector is resolved at Build time, so the Service may
+// still be modified after attaching.
 func (d *Deployment) AttachedTo(svc *Service) *Deployment {
-	for k, v := range svc.labels {
-		d.labels[k] = v
+	d.attached = append(d.attached, svc)
+	d.dependsOn = append(d.dependsOn, svc)
+	return d
+}
+
+// DependsOn orders this Deployment after the given resources.
+func (d *Deployment) DependsOn(deps ...Builder) *Deployment {
+	for _, dep := range deps {
+		d.dependsOn = append(d.dependsOn, dep)
 	}
-	d.dependsOn = append(d.dependsOn, svc.GetName())
 	return d
 }
 
@@ -51,17 +80,46 @@ func (d *Deployment) GetName() string {
 	return d.name
 }
 
+func (d *Deployment) dependencies() []Builder {
+	return d.dependsOn
+}
+
+// Protect keeps the engine from ever deleting the Deployment, whether by Destroy
+// or because it was removed from the graph.
+func (d *Deployment) Protect() *Deployment {
+	d.protected = true
+	return d
+}
+
 // Build compiles the declarative builder into a graph Node.
 func (d *Deployment) Build() *ast.Node {
+	selector := map[string]string{DeploymentSelectorLabel: d.name}
+	if len(d.selector) > 0 {
+		selector = copyLabels(d.selector)
+	}
+	podLabels := copyLabels(d.labels)
+	for _, svc := range d.attached {
+		for k, v := range svc.selectorLabels() {
+			podLabels[k] = v
+		}
+	}
+	for k, v := range selector {
+		podLabels[k] = v
+	}
+
 	return &ast.Node{
 		Kind:         "Deployment",
 		Name:         d.name,
 		Namespace:    d.namespace,
-		Dependencies: d.dependsOn,
-		Properties: map[string]any{
-			"image":    d.image,
-			"replicas": d.replicas,
-			"labels":   d.labels,
+		Dependencies: names(d.dependsOn),
+		Spec: &ast.DeploymentSpec{
+			Image:     d.image,
+			Replicas:  d.replicas,
+			Labels:    d.labels,
+			Selector:  selector,
+			PodLabels: podLabels,
+			Ports:     append([]ast.ContainerPort(nil), d.ports...),
 		},
+		Protected: d.protected,
 	}
 }
@@ -0,0 +1,20 @@
+package dsl
+
+// Selector labels generated from the graph. They only depend on resource
+// names, so cosmetic metadata labels never change routing or a Deployment's
+// immutable spec.selector.
+const (
+	// DeploymentSelectorLabel identifies the pods owned by a Deployment.
+	DeploymentSelectorLabel = "kube-goat.io/deployment"
+	// ServiceSelectorPrefix is joined with a Service name to form the label
+	// its attached Deployments stamp onto their pods.
+	ServiceSelectorPrefix = "svc.kube-goat.io/"
+)
+
+func copyLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
@@ -2,50 +2,194 @@ package dsl
 
 import "github.com/arpanpathak/kube-goAT/pkg/ast"
 
+// ServiceType mirrors the Kubernetes Service types supported by the DSL.
+type ServiceType string
+
+const (
+	ClusterIP    ServiceType = "ClusterIP"
+	NodePort     ServiceType = "NodePort"
+	LoadBalancer ServiceType = "LoadBalancer"
+	ExternalName ServiceType = "ExternalName"
+)
+
 type Service struct {
-	name       string
-	namespace  string
-	port       int32
-	targetPort int32
-	labels     map[string]string
+	name            string
+	namespace       string
+	serviceType     ServiceType
+	headless        bool
+	externalName    string
+	stickySessions  bool
+	affinityTimeout int32
+	ports           []ast.ServicePort
+	labels          map[string]string
+	selector        map[string]string
+	dependsOn       []Builder
+	protected       bool
 }
 
 // NewService enforces compile-time validation for required fields: name, port, targetPort.
 func NewService(name string, port, targetPort int32) *Service {
 	return &Service{
-		name:       name,
-		namespace:  "default",
-		port:       port,
-		targetPort: targetPort,
-		labels:     make(map[string]string),
+		name:        name,
+		namespace:   "default",
+		serviceType: ClusterIP,
+		ports:       []ast.ServicePort{{Protocol: "TCP", Port: port, TargetPort: targetPort}},
+		labels:      make(map[string]string),
+		selector:    make(map[string]string),
+	}
+}
+
+// NewExternalService creates an ExternalName Service aliasing the given DNS name.
+func NewExternalService(name, host string) *Service {
+	return &Service{
+		name:         name,
+		namespace:    "default",
+		serviceType:  ExternalName,
+		externalName: host,
+		labels:       make(map[string]string),
+		selector:     make(map[string]string),
 	}
 }
 
 // Label adds a key-value pair. Method is terse to be less bloated.
+// Labels are metadata only and never take part in pod selection.
 func (s *Service) Label(key, value string) *Service {
 	s.labels[key] = value
 	return s
 }
 
+// Selector routes the Service to pods carrying the given label instead of
+// the generated selector. Use it to target pods managed outside the graph.
+func (s *Service) Selector(key, value string) *Service {
+	s.selector[key] = value
+	return s
+}
+
 func (s *Service) Namespace(ns string) *Service {
 	s.namespace = ns
 	return s
 }
 
+// Port exposes an additional named TCP port forwarding to a numeric targetPort.
+func (s *Service) Port(name string, port, targetPort int32) *Service {
+	s.ports = append(s.ports, ast.ServicePort{Name: name, Protocol: "TCP", Port: port, TargetPort: targetPort})
+	return s
+}
+
+// PortTo exposes an additional named TCP port forwarding to a named container
+// port. The name is checked against the attached Deployment when compiling.
+func (s *Service) PortTo(name string, port int32, containerPort string) *Service {
+	s.ports = append(s.ports, ast.ServicePort{Name: name, Protocol: "TCP", Port: port, TargetPortName: containerPort})
+	return s
+}
+
+// PortName names the most recently added port.
+func (s *Service) PortName(name string) *Service {
+	if p := s.lastPort(); p != nil {
+		p.Name = name
+	}
+	return s
+}
+
+// Protocol sets the protocol (TCP, UDP or SCTP) of the most recently added port.
+func (s *Service) Protocol(protocol string) *Service {
+	if p := s.lastPort(); p != nil {
+		p.Protocol = protocol
+	}
+	return s
+}
+
+// NodePort pins the node port of the most recently added port.
+func (s *Service) NodePort(n int32) *Service {
+	if p := s.lastPort(); p != nil {
+		p.NodePort = n
+	}
+	return s
+}
+
+func (s *Service) Type(t ServiceType) *Service {
+	s.serviceType = t
+	return s
+}
+
+// Headless renders the Service with clusterIP None for direct pod DNS records.
+func (s *Service) Headless() *Service {
+	s.headless = true
+	return s
+}
+
+// StickySessions enables ClientIP session affinity. A zero timeout keeps the
+// Kubernetes default.
+func (s *Service) StickySessions(timeoutSeconds int32) *Service {
+	s.stickySessions = true
+	s.affinityTimeout = timeoutSeconds
+	return s
+}
+
+// DependsOn orders this Service after the given resources.
+func (s *Service) DependsOn(deps ...Builder) *Service {
+	for _, dep := range deps {
+		s.dependsOn = append(s.dependsOn, dep)
+	}
+	return s
+}
+
 func (s *Service) GetName() string {
 	return s.name
 }
 
+func (s *Service) dependencies() []Builder {
+	return s.dependsOn
+}
+
+// selectorLabels returns the explicit selector, or the stable label that
+// attached Deployments stamp onto their pods.
+func (s *Service) selectorLabels() map[string]string {
+	if len(s.selector) > 0 {
+		return copyLabels(s.selector)
+	}
+	return map[string]string{ServiceSelectorPrefix + s.name: "true"}
+}
+
+func (s *Service) lastPort() *ast.ServicePort {
+	if len(s.ports) == 0 {
+		return nil
+	}
+	return &s.ports[len(s.ports)-1]
+}
+
+// Protect keeps the engine from ever deleting the Service, whether by Destroy
+// or because it was removed from the graph.
+func (s *Service) Protect() *Service {
+	s.protected = true
+	return s
+}
+
 // Build compiles the declarative builder into a graph Node.
 func (s *Service) Build() *ast.Node {
+	spec := &ast.ServiceSpec{
+		Type:   string(s.serviceType),
+		Ports:  append([]ast.ServicePort(nil), s.ports...),
+		Labels: s.labels,
+	}
+	if s.headless {
+		spec.ClusterIP = "None"
+	}
+	if s.serviceType == ExternalName {
+		spec.ExternalName = s.externalName
+	} else {
+		spec.Selector = s.selectorLabels()
+	}
+	if s.stickySessions {
+		spec.SessionAffinity = "ClientIP"
+		spec.SessionAffinityTimeout = s.affinityTimeout
+	}
 	return &ast.Node{
-		Kind:      "Service",
-		Name:      s.name,
-		Namespace: s.namespace,
-		Properties: map[string]any{
-			"port":       s.port,
-			"targetPort": s.targetPort,
-			"labels":     s.labels,
-		},
+		Kind:         "Service",
+		Name:         s.name,
+		Namespace:    s.namespace,
+		Dependencies: names(s.dependsOn),
+		Spec:         spec,
+		Protected:    s.protected,
 	}
 }
@@ -0,0 +1,288 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// serverFields are metadata fields populated by the API server that never
+// belong in a declared graph.
+var serverFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"}
+
+// clusterScopedKinds lists well-known kinds that live outside namespaces.
+var clusterScopedKinds = map[string]bool{
+	"Namespace": true, "Node": true, "PersistentVolume": true, "StorageClass": true,
+	"ClusterRole": true, "ClusterRoleBinding": true, "CustomResourceDefinition": true,
+	"PriorityClass": true, "IngressClass": true, "RuntimeClass": true, "APIService": true,
+	"ValidatingWebhookConfiguration": true, "MutatingWebhookConfiguration": true,
+	"ClusterIssuer": true,
+}
+
+// FromYAML parses multi-document Kubernetes YAML (or JSON) into a graph.
+// Services and Deployments become typed builders when the builder renders
+// back to exactly the same object; anything else becomes a Custom resource,
+// so only server-populated fields and status are ever dropped.
+func FromYAML(r io.Reader) (*GraphBuilder, error) {
+	dec := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objects []*unstructured.Unstructured
+	for {
+		var obj map[string]any
+		if err := dec.Decode(&obj); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decode manifest: %w", err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		u := &unstructured.Unstructured{Object: obj}
+		if u.IsList() {
+			err := u.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objects = append(objects, u)
+	}
+
+	graph := NewGraph()
+	var services []*Service
+	var deployments []*Deployment
+	for _, u := range objects {
+		if err := cleanMetadata(u); err != nil {
+			return nil, err
+		}
+		switch gvk := u.GroupVersionKind(); {
+		case gvk.Group == "" && gvk.Version == "v1" && gvk.Kind == "Service":
+			if svc := importService(u); svc != nil {
+				services = append(services, svc)
+				graph.Add(svc)
+				continue
+			}
+		case gvk.Group == "apps" && gvk.Version == "v1" && gvk.Kind == "Deployment":
+			if dep := importDeployment(u); dep != nil {
+				deployments = append(deployments, dep)
+				graph.Add(dep)
+				continue
+			}
+		}
+		graph.Add(importCustom(u))
+	}
+
+	// Restore AttachedTo links: a Deployment whose pods already carry a
+	// Service's selector labels is attached to it.
+	for _, dep := range deployments {
+		pods := dep.Build().Spec.(*ast.DeploymentSpec).PodLabels
+		for _, svc := range services {
+			if svc.namespace == dep.namespace && svc.serviceType != ExternalName && subset(svc.selectorLabels(), pods) {
+				dep.AttachedTo(svc)
+			}
+		}
+	}
+	return graph, nil
+}
+
+// cleanMetadata drops status and server-populated metadata and rejects
+// objects carrying metadata the builders cannot express.
+func cleanMetadata(u *unstructured.Unstructured) error {
+	if u.GetAPIVersion() == "" || u.GetKind() == "" || u.GetName() == "" {
+		return fmt.Errorf("manifest %s/%s: apiVersion, kind and metadata.name are required", u.GetKind(), u.GetName())
+	}
+	delete(u.Object, "status")
+	for _, f := range serverFields {
+		unstructured.RemoveNestedField(u.Object, "metadata", f)
+	}
+	if annotations := u.GetAnnotations(); annotations != nil {
+		delete(annotations, corev1.LastAppliedConfigAnnotation)
+		u.SetAnnotations(annotations)
+		if len(annotations) == 0 {
+			unstructured.RemoveNestedField(u.Object, "metadata", "annotations")
+		}
+	}
+	meta, _, _ := unstructured.NestedMap(u.Object, "metadata")
+	for field := range meta {
+		switch field {
+		case "name", "namespace", "labels", "annotations":
+		default:
+			return fmt.Errorf("manifest %s/%s: metadata.%s is not supported", u.GetKind(), u.GetName(), field)
+		}
+	}
+	return nil
+}
+
+func importService(u *unstructured.Unstructured) *Service {
+	var in corev1.Service
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(u.Object, &in, true); err != nil {
+		return nil
+	}
+	normalizeService(&in)
+
+	var svc *Service
+	switch {
+	case in.Spec.Type == corev1.ServiceTypeExternalName:
+		svc = NewExternalService(in.Name, in.Spec.ExternalName)
+	case len(in.Spec.Ports) == 0:
+		return nil
+	default:
+		first := in.Spec.Ports[0]
+		svc = NewService(in.Name, first.Port, first.TargetPort.IntVal)
+		if first.Name != "" {
+			svc.PortName(first.Name)
+		}
+		svc.Protocol(string(first.Protocol)).NodePort(first.NodePort)
+		for _, p := range in.Spec.Ports[1:] {
+			if p.TargetPort.Type == intstr.String {
+				svc.PortTo(p.Name, p.Port, p.TargetPort.StrVal)
+			} else {
+				svc.Port(p.Name, p.Port, p.TargetPort.IntVal)
+			}
+			svc.Protocol(string(p.Protocol)).NodePort(p.NodePort)
+		}
+		svc.Type(ServiceType(in.Spec.Type))
+		if in.Spec.ClusterIP == corev1.ClusterIPNone {
+			svc.Headless()
+		}
+		for k, v := range in.Spec.Selector {
+			svc.Selector(k, v)
+		}
+	}
+	if in.Spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		var timeout int32
+		if cfg := in.Spec.SessionAffinityConfig; cfg != nil && cfg.ClientIP != nil && cfg.ClientIP.TimeoutSeconds != nil {
+			timeout = *cfg.ClientIP.TimeoutSeconds
+		}
+		svc.StickySessions(timeout)
+	}
+	svc.Namespace(in.Namespace)
+	for k, v := range in.Labels {
+		svc.Label(k, v)
+	}
+
+	out, err := render.Service(svc.Build())
+	if err != nil || !equality.Semantic.DeepEqual(out, &in) {
+		return nil
+	}
+	return svc
+}
+
+// normalizeService applies the API server defaults the builders render
+// explicitly, so equivalent manifests compare equal.
+func normalizeService(svc *corev1.Service) {
+	if svc.Namespace == "" {
+		svc.Namespace = "default"
+	}
+	if svc.Spec.Type == "" {
+		svc.Spec.Type = corev1.ServiceTypeClusterIP
+	}
+	if svc.Spec.SessionAffinity == corev1.ServiceAffinityNone {
+		svc.Spec.SessionAffinity = ""
+	}
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		if p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal == 0 {
+			p.TargetPort = intstr.FromInt32(p.Port)
+		}
+	}
+}
+
+func importDeployment(u *unstructured.Unstructured) *Deployment {
+	var in appsv1.Deployment
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(u.Object, &in, true); err != nil {
+		return nil
+	}
+	if len(in.Spec.Template.Spec.Containers) != 1 || in.Spec.Selector == nil {
+		return nil
+	}
+	normalizeDeployment(&in)
+
+	container := in.Spec.Template.Spec.Containers[0]
+	dep := NewDeployment(in.Name, container.Image).
+		Replicas(*in.Spec.Replicas).
+		Namespace(in.Namespace)
+	for _, p := range container.Ports {
+		dep.Port(p.Name, p.ContainerPort)
+	}
+	for k, v := range in.Labels {
+		dep.Label(k, v)
+	}
+	for k, v := range in.Spec.Selector.MatchLabels {
+		dep.Selector(k, v)
+	}
+
+	out, err := render.Deployment(dep.Build())
+	if err != nil || !equality.Semantic.DeepEqual(out, &in) {
+		return nil
+	}
+	return dep
+}
+
+func normalizeDeployment(dep *appsv1.Deployment) {
+	if dep.Namespace == "" {
+		dep.Namespace = "default"
+	}
+	if dep.Spec.Replicas == nil {
+		one := int32(1)
+		dep.Spec.Replicas = &one
+	}
+	for i := range dep.Spec.Template.Spec.Containers {
+		for j := range dep.Spec.Template.Spec.Containers[i].Ports {
+			if p := &dep.Spec.Template.Spec.Containers[i].Ports[j]; p.Protocol == "" {
+				p.Protocol = corev1.ProtocolTCP
+			}
+		}
+	}
+}
+
+func importCustom(u *unstructured.Unstructured) *Custom {
+	c := NewCustom(u.GetAPIVersion(), u.GetKind(), u.GetName())
+	switch {
+	case u.GetNamespace() != "":
+		c.Namespace(u.GetNamespace())
+	case clusterScopedKinds[u.GetKind()]:
+		c.ClusterScoped()
+	}
+	for k, v := range u.GetLabels() {
+		c.Label(k, v)
+	}
+	for k, v := range u.GetAnnotations() {
+		c.Annotation(k, v)
+	}
+	for field, value := range u.Object {
+		switch field {
+		case "apiVersion", "kind", "metadata":
+		case "spec":
+			c.Spec(value)
+		default:
+			c.Set(field, value)
+		}
+	}
+	return c
+}
+
+func subset(sub, set map[string]string) bool {
+	for k, v := range sub {
+		if set[k] != v {
+			return false
+		}
+	}
+	return true
+}
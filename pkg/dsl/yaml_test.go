@@ -0,0 +1,154 @@
+package dsl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+const manifests = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    team: edge
+  resourceVersion: "42"
+spec:
+  selector:
+    app: web
+  ports:
+  - name: http
+    port: 80
+    targetPort: 8080
+  - name: metrics
+    port: 9090
+    targetPort: metrics
+status:
+  loadBalancer: {}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: web
+  template:
+    metadata:
+      labels:
+        app: web
+    spec:
+      containers:
+      - name: app
+        image: ghcr.io/acme/web:1.2
+        ports:
+        - name: http
+          containerPort: 8080
+        - name: metrics
+          containerPort: 9090
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: worker
+  spec:
+    selector:
+      matchLabels:
+        app: worker
+    template:
+      metadata:
+        labels:
+          app: worker
+      spec:
+        containers:
+        - name: worker
+          image: ghcr.io/acme/worker:1.2
+          env:
+          - name: QUEUE
+            value: jobs
+- apiVersion: rbac.authorization.k8s.io/v1
+  kind: ClusterRole
+  metadata:
+    name: reader
+    annotations:
+      kubectl.kubernetes.io/last-applied-configuration: "{}"
+      owner: platform
+  rules:
+  - verbs: ["get"]
+    resources: ["pods"]
+`
+
+func TestFromYAML(t *testing.T) {
+	graph, err := FromYAML(strings.NewReader(manifests))
+	if err != nil {
+		t.Fatalf("FromYAML failed: %v", err)
+	}
+	// The web Service and Deployment share a name and must both survive.
+	dag := graph.Build()
+	if len(dag.Nodes) != 4 || dag.Nodes["Deployment/prod/web"] == nil {
+		t.Fatalf("Expected 4 distinct nodes, got %v", dag.Nodes)
+	}
+
+	svc, ok := graph.resources[0].(*Service)
+	if !ok {
+		t.Fatalf("Expected typed Service, got %T", graph.resources[0])
+	}
+	if !reflect.DeepEqual(svc.selector, map[string]string{"app": "web"}) || len(svc.ports) != 2 || svc.namespace != "prod" {
+		t.Errorf("Unexpected imported service: %+v", svc)
+	}
+
+	web, ok := graph.resources[1].(*Deployment)
+	if !ok {
+		t.Fatalf("Expected typed Deployment, got %T", graph.resources[1])
+	}
+	if web.replicas != 3 || !reflect.DeepEqual(web.dependsOn, []Builder{svc}) {
+		t.Errorf("Expected deployment attached to its service: %+v", web)
+	}
+
+	// Lossy shapes fall back to Custom nodes that keep every field.
+	worker, ok := graph.resources[2].(*Custom)
+	if !ok || worker.namespace != "default" {
+		t.Fatalf("Expected worker to import as a namespaced Custom resource, got %T", graph.resources[2])
+	}
+	spec := worker.Build().Spec.(*ast.CustomSpec).Content["spec"]
+	if !strings.Contains(fmt.Sprint(spec), "QUEUE") {
+		t.Errorf("Expected env to be preserved: %v", spec)
+	}
+
+	role := graph.resources[3].(*Custom).Build()
+	roleSpec := role.Spec.(*ast.CustomSpec)
+	if role.Namespace != "" || roleSpec.Annotations["owner"] != "platform" {
+		t.Errorf("Unexpected ClusterRole import: %+v", role)
+	}
+	if _, ok := roleSpec.Annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		t.Errorf("Expected last-applied annotation to be dropped")
+	}
+	if _, ok := roleSpec.Content["rules"]; !ok {
+		t.Errorf("Expected rules to be preserved")
+	}
+}
+
+func TestFromYAML_Errors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"missing name":     "apiVersion: v1\nkind: ConfigMap\n",
+		"owner references": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: x\n  ownerReferences: []\n",
+		"malformed":        "apiVersion: v1\nkind: [",
+	} {
+		if _, err := FromYAML(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+)
+
+// checkpointSaveTimeout bounds how long saving a checkpoint may take once
+// the apply that records it is cancelled or timed out.
+const checkpointSaveTimeout = 30 * time.Second
+
+// checkpoint tracks what exists in the cluster while Apply runs. Changes
+// are saved in batches: at once when a node fails, so state records the
+// failure and everything done before it, and by flush at the end, so N
+// nodes do not cost N writes. An interrupted Apply may leave objects it
+// created unrecorded; they carry this stack's ownership metadata, so the
+// next Apply takes them over, and objects it deleted but still records are
+// simply not found again.
+type checkpoint struct {
+	store    func(ctx context.Context, dag *ast.DAG) error
+	nodes    map[string]*ast.Node // by identity
+	keys     map[string]string    // identity to DAG key
+	statuses map[string]ast.NodeStatus
+	signer   string // of the payload being applied
+	signed   []byte // the payload being applied, if its signer is verified
+	// dirty is set when the tracked state differs from what was last
+	// saved.
+	dirty bool
+}
+
+// newCheckpoint starts from the previous state: everything it records is
+// assumed to exist until Apply deletes it. Checkpoints are saved in the
+// given payload format.
+func (e *Engine) newCheckpoint(stateKey string, oldDag *ast.DAG, format string) *checkpoint {
+	c := &checkpoint{
+		store: func(ctx context.Context, dag *ast.DAG) error {
+			return e.saveDAG(ctx, stateKey, dag, format)
+		},
+		nodes:    make(map[string]*ast.Node),
+		keys:     make(map[string]string),
+		statuses: make(map[string]ast.NodeStatus),
+	}
+	if oldDag != nil {
+		for key, node := range oldDag.Nodes {
+			c.nodes[node.Identity()] = node
+			c.keys[node.Identity()] = key
+		}
+		for id, status := range oldDag.Status {
+			c.statuses[id] = status
+		}
+	}
+	return c
+}
+
+// loadState returns the payload recorded under stateKey, and whether there
+// is one. Failing to read it for any other reason than nothing being
+// recorded is an error: carrying on without it would forget, and later
+// overwrite, every resource it records.
+func (e *Engine) loadState(ctx context.Context, stateKey string) ([]byte, bool, error) {
+	data, err := e.store.Load(ctx, stateKey)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("load state %s: %w", stateKey, err)
+	}
+	return data, true, nil
+}
+
+// unlocked releases the state lock taken for an operation, returning the
+// operation's error unchanged unless unlocking fails too.
+func unlocked(err error, unlock func() error) error {
+	if unlockErr := unlock(); unlockErr != nil {
+		return errors.Join(err, unlockErr)
+	}
+	return err
+}
+
+// stateFormat returns the payload format to record state in: the format
+// existing state is already in, so converted state stays converted, or
+// else the format of the applied payload.
+func stateFormat(existing, payload []byte) string {
+	data := payload
+	if existing != nil {
+		data = existing
+	}
+	format, err := ast.PayloadFormat(data)
+	if err != nil {
+		return ast.FormatGob
+	}
+	return format
+}
+
+// applied records that the cluster now matches node.
+func (c *checkpoint) applied(key string, node *ast.Node) {
+	id := node.Identity()
+	_, failed := c.statuses[id]
+	if old, ok := c.nodes[id]; ok && !failed && c.keys[id] == key && old.Protected == node.Protected && old.Hash() == node.Hash() {
+		c.nodes[id] = node
+		return
+	}
+	c.nodes[id], c.keys[id] = node, key
+	delete(c.statuses, id)
+	c.dirty = true
+}
+
+// deleted records that a node's object is gone.
+func (c *checkpoint) deleted(node *ast.Node) {
+	id := node.Identity()
+	delete(c.nodes, id)
+	delete(c.keys, id)
+	delete(c.statuses, id)
+	c.dirty = true
+}
+
+// failed records a failed operation, keeping whatever version of the node
+// is already recorded, and saves the checkpoint unless the same failure is
+// already recorded. A node whose object was never created is recorded too,
+// as a failed create, so the graph re-applied from state still creates it.
+func (c *checkpoint) failed(ctx context.Context, nodeErr *NodeError, key string, node *ast.Node) error {
+	id := node.Identity()
+	status := ast.NodeStatus{Op: nodeErr.Op, Error: nodeErr.Err.Error()}
+	if old, recorded := c.nodes[id]; !recorded || c.statuses[id].Op == "create" {
+		status.Op = "create"
+		if !recorded || c.keys[id] != key || old.Hash() != node.Hash() {
+			c.nodes[id], c.keys[id] = node, key
+			c.dirty = true
+		}
+	}
+	if old, ok := c.statuses[id]; !ok || old != status {
+		c.statuses[id] = status
+		c.dirty = true
+	}
+	return c.flush(ctx)
+}
+
+// createFailed reports whether the last operation on a node of dag was a
+// failed create: state records such nodes only so they are created when
+// it is applied again, and their objects do not exist.
+func createFailed(dag *ast.DAG, node *ast.Node) bool {
+	return dag.Status[node.Identity()].Op == "create"
+}
+
+// flush saves the checkpoint if it changed since it was last saved.
+func (c *checkpoint) flush(ctx context.Context) error {
+	if !c.dirty {
+		return nil
+	}
+	if err := c.save(ctx); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// save records the checkpoint even when ctx is done: an apply cancelled
+// after creating objects must still record them.
+func (c *checkpoint) save(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), checkpointSaveTimeout)
+	defer cancel()
+	if err := c.store(ctx, c.dag()); err != nil {
+		return fmt.Errorf("save state checkpoint: %w", err)
+	}
+	return nil
+}
+
+// dag builds the recorded graph. Keys follow the graph that produced each
+// node, falling back to identities on collision, and dependencies are
+// trimmed to the other recorded keys.
+func (c *checkpoint) dag() *ast.DAG {
+	ids := make([]string, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	dag := &ast.DAG{Nodes: make(map[string]*ast.Node, len(ids)), Signer: c.signer, SignedPayload: c.signed}
+	for _, id := range ids {
+		key := c.keys[id]
+		if _, taken := dag.Nodes[key]; taken {
+			key = id
+		}
+		dag.Nodes[key] = c.nodes[id]
+	}
+	for key, node := range dag.Nodes {
+		var deps []string
+		for _, dep := range node.Dependencies {
+			if _, ok := dag.Nodes[dep]; ok && dep != key {
+				deps = append(deps, dep)
+			}
+		}
+		trimmed := *node
+		trimmed.Dependencies = deps
+		dag.Nodes[key] = &trimmed
+	}
+	for id, status := range c.statuses {
+		if _, ok := c.nodes[id]; !ok {
+			continue
+		}
+		if dag.Status == nil {
+			dag.Status = make(map[string]ast.NodeStatus)
+		}
+		dag.Status[id] = status
+	}
+	return dag
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+// countingStore counts the payloads saved to the store it wraps.
+type countingStore struct {
+	state.Store
+	saves int
+}
+
+func (c *countingStore) Save(ctx context.Context, key string, data []byte) error {
+	c.saves++
+	return c.Store.Save(ctx, key, data)
+}
+
+func TestEngineApply_RecordsProgress(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := &countingStore{Store: newLocalStore(t)}
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	client.PrependReactor("create", "deployments", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("simulated CREATE error")
+	})
+
+	// The failure is saved together with the Service applied before it,
+	// rather than every node costing a write of its own.
+	api := dsl.NewService("api", 80, 8080)
+	db := dsl.NewService("db", 5432, 5432)
+	payload := mustSerialize(t, dsl.NewGraph().Add(api).Add(db).Add(dsl.NewDeployment("web", "nginx").AttachedTo(api)))
+	if err := eng.Apply(ctx, payload, "env"); err == nil {
+		t.Fatal("expected the deployment to fail")
+	}
+	if store.saves != 1 {
+		t.Errorf("expected one save for the failed run, got %d", store.saves)
+	}
+
+	plan, err := eng.Plan(ctx, payload, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range plan.Changes {
+		want := map[string]Action{"api": ActionNoop, "db": ActionNoop, "web": ActionCreate}[c.Name]
+		if c.Action != want {
+			t.Errorf("%s: expected %s after partial apply, got %s", c.Name, want, c.Action)
+		}
+	}
+
+	// Once the Deployment succeeds, the run ends with a single save, and
+	// re-applying the recorded payload writes nothing.
+	client.ReactionChain = client.ReactionChain[1:]
+	store.saves = 0
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if store.saves != 1 {
+		t.Errorf("expected one save for the successful run and none for the no-op, got %d", store.saves)
+	}
+}
+
+// cancellableStore refuses to save once the context is done, the way a
+// store talking to a remote backend would.
+type cancellableStore struct{ state.Store }
+
+func (c cancellableStore) Save(ctx context.Context, key string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.Store.Save(ctx, key, data)
+}
+
+func TestEngineApply_RecordsProgressWhenCancelled(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: cancellableStore{store}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The apply is cancelled after the Service was created.
+	client.PrependReactor("create", "deployments", func(ktesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return true, nil, ctx.Err()
+	})
+	api := dsl.NewService("api", 80, 8080)
+	payload := mustSerialize(t, dsl.NewGraph().Add(api).Add(dsl.NewDeployment("web", "nginx").AttachedTo(api)))
+	if err := eng.Apply(ctx, payload, "env"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancellation to be reported, got %v", err)
+	}
+
+	data, err := store.Load(context.Background(), "env")
+	if err != nil {
+		t.Fatalf("expected the checkpoint to be saved despite the cancellation, got %v", err)
+	}
+	recorded, _ := ast.Deserialize(data)
+	if recorded.Nodes["api"] == nil || len(recorded.Status) != 1 {
+		t.Errorf("expected the created Service and the failed Deployment to be recorded, got %v %+v", recorded.Nodes, recorded.Status)
+	}
+}
+
+func TestEnginePlan_RetriesFailedNodes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatal(err)
+	}
+	client.PrependReactor("update", "services", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("simulated UPDATE error")
+	})
+	// Re-applying an unchanged node is skipped, so change the port.
+	payload = mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 81, 8080)))
+	if err := eng.Apply(ctx, payload, "env"); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+
+	plan, err := eng.Plan(ctx, payload, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Changes) != 1 || plan.Changes[0].Action != ActionUpdate || plan.Changes[0].LastError == "" {
+		t.Errorf("expected the failed node to be retried, got %+v", plan.Changes)
+	}
+}
+
+func TestEngineApply_KeepsStateFormat(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	if err := eng.Apply(ctx, mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080))), "env"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := state.Convert(ctx, store, ast.FormatJSON, "env"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The payload is gob, but the converted state must stay JSON.
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 81, 8080)))
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := store.Load(ctx, "env")
+	if format, _ := ast.PayloadFormat(data); format != ast.FormatJSON {
+		t.Errorf("expected state to stay json after Apply, got %q", format)
+	}
+	dag, err := ast.Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec, _ := ast.SpecOf[*ast.ServiceSpec](dag.Nodes["api"]); spec == nil || spec.Ports[0].Port != 81 {
+		t.Errorf("expected the applied graph to be recorded, got %+v", dag.Nodes["api"])
+	}
+}
@@ -0,0 +1,189 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultDeleteTimeout bounds how long Destroy waits for one object's
+// finalizers to clear.
+const DefaultDeleteTimeout = 5 * time.Minute
+
+const deletePollInterval = time.Second
+
+var errUnsupportedKind = errors.New("unsupported node kind")
+
+// Destroy deletes every object recorded under stateKey, dependents before
+// their dependencies, waiting for each to disappear. Protected nodes, and
+// anything they depend on, are left in place and stay in state; once
+// nothing is left the state entry is deleted. A failed Destroy records
+// what is left so running it again resumes where it stopped. The store's
+// lock on stateKey is held throughout when the store is a state.Locker.
+func (e *Engine) Destroy(ctx context.Context, stateKey string) error {
+	ctx, unlock, err := state.Lock(ctx, e.store, stateKey)
+	if err != nil {
+		return err
+	}
+	return unlocked(e.destroy(ctx, stateKey), unlock)
+}
+
+func (e *Engine) destroy(ctx context.Context, stateKey string) error {
+	data, err := e.store.Load(ctx, stateKey)
+	if err != nil {
+		return fmt.Errorf("load state %s: %w", stateKey, err)
+	}
+	dag, err := ast.Deserialize(data)
+	if err != nil {
+		return stateError(stateKey, err)
+	}
+	format := stateFormat(data, nil)
+	order, err := dag.TopologicalOrder()
+	if err != nil {
+		return fmt.Errorf("state %s: %w", stateKey, err)
+	}
+
+	remaining := &ast.DAG{Nodes: maps.Clone(dag.Nodes), Status: maps.Clone(dag.Status)}
+	keep := make(map[string]bool)
+	for i := len(order) - 1; i >= 0; i-- {
+		key := order[i]
+		node := dag.Nodes[key]
+		if createFailed(dag, node) {
+			// Nothing was created.
+			delete(remaining.Nodes, key)
+			delete(remaining.Status, node.Identity())
+			continue
+		}
+		if node.Protected || keep[key] {
+			log.Printf("[Engine] Keeping protected resource or its dependency: %s (%s)", node.Name, node.ObjectKind())
+			for _, dep := range node.Dependencies {
+				keep[dep] = true
+			}
+			continue
+		}
+		if err := e.destroyNode(ctx, node, stateKey); errors.Is(err, errUnsupportedKind) {
+			log.Printf("[WARNING] Unsupported node kind: %s", node.Kind)
+		} else if err != nil {
+			if remaining.Status == nil {
+				remaining.Status = make(map[string]ast.NodeStatus)
+			}
+			remaining.Status[node.Identity()] = ast.NodeStatus{Op: "delete", Error: err.Error()}
+			if saveErr := e.saveDAG(ctx, stateKey, remaining, format); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+			return err
+		}
+		delete(remaining.Nodes, key)
+		delete(remaining.Status, node.Identity())
+	}
+
+	if len(remaining.Nodes) > 0 {
+		return e.saveDAG(ctx, stateKey, remaining, format)
+	}
+	return e.store.Delete(ctx, stateKey)
+}
+
+func (e *Engine) saveDAG(ctx context.Context, stateKey string, dag *ast.DAG, format string) error {
+	payload, err := dag.SerializeAs(format)
+	if err != nil {
+		return err
+	}
+	return e.store.Save(ctx, stateKey, payload)
+}
+
+// destroyNode deletes a node's object in the foreground, so its dependents
+// such as ReplicaSets and Pods go first, and waits until it is gone.
+func (e *Engine) destroyNode(ctx context.Context, node *ast.Node, stateKey string) error {
+	foreground := metav1.DeletePropagationForeground
+	log.Printf("[Engine] Destroying %s: %s", node.ObjectKind(), node.Name)
+	if err := e.deleteNode(ctx, node, stateKey, metav1.DeleteOptions{PropagationPolicy: &foreground}); err != nil {
+		return fmt.Errorf("delete %s %s/%s: %w", node.ObjectKind(), node.Namespace, node.Name, err)
+	}
+
+	timeout := e.deleteTimeout
+	if timeout == 0 {
+		timeout = DefaultDeleteTimeout
+	}
+	err := wait.PollUntilContextTimeout(ctx, deletePollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		exists, err := e.exists(ctx, node)
+		return !exists, err
+	})
+	if err != nil {
+		return fmt.Errorf("%s %s/%s still present after %s, finalizers may be blocking deletion: %w",
+			node.ObjectKind(), node.Namespace, node.Name, timeout, err)
+	}
+	return nil
+}
+
+// deleteNode deletes the object a node recorded under stateKey manages; an
+// object that is already gone is not an error. An object re-created under
+// the same name by another stack or tool is refused with ErrNotOwned, and
+// the delete is conditional on the UID of the object checked, so one
+// swapped in meanwhile is not removed either.
+func (e *Engine) deleteNode(ctx context.Context, node *ast.Node, stateKey string, opts metav1.DeleteOptions) error {
+	live, err := e.getObject(ctx, node)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := e.checkOwner(live, owner{stateKey: stateKey, recorded: true}); err != nil {
+		return err
+	}
+	if uid := live.GetUID(); uid != "" {
+		opts.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+	switch node.Kind {
+	case "Service":
+		err = e.client.CoreV1().Services(node.Namespace).Delete(ctx, node.Name, opts)
+	case "Deployment":
+		err = e.client.AppsV1().Deployments(node.Namespace).Delete(ctx, node.Name, opts)
+	case "Custom":
+		res, resErr := e.customResource(node)
+		if resErr != nil {
+			return resErr
+		}
+		err = res.Delete(ctx, node.Name, opts)
+	}
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// exists reports whether the object a node manages is still present.
+func (e *Engine) exists(ctx context.Context, node *ast.Node) (bool, error) {
+	_, err := e.getObject(ctx, node)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// getObject gets the object a node manages.
+func (e *Engine) getObject(ctx context.Context, node *ast.Node) (metav1.Object, error) {
+	switch node.Kind {
+	case "Service":
+		return e.client.CoreV1().Services(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
+	case "Deployment":
+		return e.client.AppsV1().Deployments(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
+	case "Custom":
+		res, err := e.customResource(node)
+		if err != nil {
+			return nil, err
+		}
+		return res.Get(ctx, node.Name, metav1.GetOptions{})
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedKind, node.Kind)
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func newLocalStore(t *testing.T) *state.LocalStore {
+	t.Helper()
+	store, err := state.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func applyGraph(t *testing.T, eng *Engine, g *dsl.GraphBuilder) {
+	t.Helper()
+	payload, err := g.Build().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Apply(context.Background(), payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+}
+
+func TestEngineDestroy(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	api := dsl.NewService("api", 80, 8080)
+	applyGraph(t, eng, dsl.NewGraph().Add(api).Add(dsl.NewDeployment("web", "nginx").AttachedTo(api)))
+
+	var deleted []string
+	client.PrependReactor("delete", "*", func(action ktesting.Action) (bool, runtime.Object, error) {
+		deleted = append(deleted, action.(ktesting.DeleteAction).GetName())
+		return false, nil, nil
+	})
+	if err := eng.Destroy(ctx, "env"); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if len(deleted) != 2 || deleted[0] != "web" || deleted[1] != "api" {
+		t.Errorf("expected dependents deleted first, got %v", deleted)
+	}
+	if _, err := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected service to be gone, got %v", err)
+	}
+	if _, err := store.Load(ctx, "env"); err == nil {
+		t.Error("expected state entry to be deleted")
+	}
+	if err := eng.Destroy(ctx, "env"); err == nil {
+		t.Error("expected an error destroying missing state")
+	}
+}
+
+func TestEngineDestroy_Protected(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	db := dsl.NewService("db", 5432, 5432)
+	applyGraph(t, eng, dsl.NewGraph().
+		Add(db).
+		Add(dsl.NewDeployment("postgres", "postgres:16").AttachedTo(db).Protect()).
+		Add(dsl.NewDeployment("web", "nginx")))
+
+	if err := eng.Destroy(ctx, "env"); err != nil {
+		t.Fatalf("Destroy failed: %v", err)
+	}
+	if _, err := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected web to be deleted, got %v", err)
+	}
+	for _, get := range []func() error{
+		func() error {
+			_, err := client.AppsV1().Deployments("default").Get(ctx, "postgres", metav1.GetOptions{})
+			return err
+		},
+		func() error {
+			_, err := client.CoreV1().Services("default").Get(ctx, "db", metav1.GetOptions{})
+			return err
+		},
+	} {
+		if err := get(); err != nil {
+			t.Errorf("protected resource or its dependency was deleted: %v", err)
+		}
+	}
+
+	data, err := store.Load(ctx, "env")
+	if err != nil {
+		t.Fatalf("expected state to keep protected nodes: %v", err)
+	}
+	left, _ := ast.Deserialize(data)
+	if len(left.Nodes) != 2 || left.Nodes["web"] != nil {
+		t.Errorf("unexpected remaining state: %v", left.Nodes)
+	}
+}
+
+func TestEngineDestroy_FinalizerTimeout(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store, deleteTimeout: 10 * time.Millisecond}
+	ctx := context.Background()
+
+	applyGraph(t, eng, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)).Add(dsl.NewDeployment("stuck", "nginx")))
+	// A finalizer that never clears: the delete is accepted but the object stays.
+	client.PrependReactor("delete", "deployments", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, nil
+	})
+
+	err := eng.Destroy(ctx, "env")
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	data, _ := store.Load(ctx, "env")
+	left, _ := ast.Deserialize(data)
+	if left.Nodes["stuck"] == nil {
+		t.Error("expected the stuck deployment to remain in state")
+	}
+
+	client.ReactionChain = client.ReactionChain[1:]
+	if err := eng.Destroy(ctx, "env"); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if _, err := store.Load(ctx, "env"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("expected the retry to delete the state, got %v", err)
+	}
+}
+
+func TestEngine_HoldsStateLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := NewEngineForClients(client, nil, nil, store)
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+
+	unlock, err := store.Lock(context.Background(), "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := eng.Apply(ctx, payload, "env"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Apply to wait for the lock, got %v", err)
+	}
+	if _, err := eng.Import(ctx, payload, "env"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Import to wait for the lock, got %v", err)
+	}
+	if err := eng.Destroy(ctx, "env"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected Destroy to wait for the lock, got %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected nothing to be sent to the cluster, got %v", client.Actions())
+	}
+	unlock()
+
+	if err := eng.Apply(context.Background(), payload, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Destroy(context.Background(), "env"); err != nil {
+		t.Fatal(err)
+	}
+}
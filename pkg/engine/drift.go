@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// FieldDiff is one owned field whose live value differs from the recorded one.
+type FieldDiff struct {
+	Path     string `json:"path"`
+	Expected any    `json:"expected"`
+	Actual   any    `json:"actual"`
+}
+
+// ObjectDrift lists the drifted fields of one object, or marks it missing.
+type ObjectDrift struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Missing    bool        `json:"missing,omitempty"`
+	Fields     []FieldDiff `json:"fields,omitempty"`
+}
+
+// DriftReport is the result of DetectDrift. Objects holds only objects
+// that drifted.
+type DriftReport struct {
+	StateKey string        `json:"stateKey"`
+	Checked  int           `json:"checked"`
+	Objects  []ObjectDrift `json:"drifted"`
+}
+
+// HasDrift reports whether any object drifted.
+func (r *DriftReport) HasDrift() bool {
+	return len(r.Objects) > 0
+}
+
+// String renders the report as text.
+func (r *DriftReport) String() string {
+	var b strings.Builder
+	for _, obj := range r.Objects {
+		if obj.Missing {
+			fmt.Fprintf(&b, "%s %s/%s: missing from the cluster\n", obj.Kind, obj.Namespace, obj.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s/%s:\n", obj.Kind, obj.Namespace, obj.Name)
+		for _, f := range obj.Fields {
+			fmt.Fprintf(&b, "  %s: expected %s, got %s\n", f.Path, describe(f.Expected), describe(f.Actual))
+		}
+	}
+	fmt.Fprintf(&b, "Drift: %d of %d objects drifted.\n", len(r.Objects), r.Checked)
+	return b.String()
+}
+
+// JSON renders the report as indented JSON.
+func (r *DriftReport) JSON() ([]byte, error) {
+	if r.Objects == nil {
+		r.Objects = []ObjectDrift{}
+	}
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func describe(v any) string {
+	if v == nil {
+		return "<unset>"
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(out)
+}
+
+// DetectDrift renders the DAG recorded under stateKey, fetches each live
+// object and reports the fields that differ. Only fields kube-goAT sets are
+// compared: defaults and fields added by the API server or other
+// controllers are ignored, as are extra labels and annotations.
+func (e *Engine) DetectDrift(ctx context.Context, stateKey string) (*DriftReport, error) {
+	data, err := e.store.Load(ctx, stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("load state %s: %w", stateKey, err)
+	}
+	dag, err := ast.Deserialize(data)
+	if err != nil {
+		return nil, stateError(stateKey, err)
+	}
+
+	keys := make([]string, 0, len(dag.Nodes))
+	for key := range dag.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	report := &DriftReport{StateKey: stateKey}
+	for _, key := range keys {
+		node := dag.Nodes[key]
+		if createFailed(dag, node) {
+			// Its object was never created; re-applying state retries it.
+			continue
+		}
+		desired, err := render.Object(node)
+		if err != nil {
+			return nil, err
+		}
+		report.Checked++
+		drift := ObjectDrift{APIVersion: desired.GetAPIVersion(), Kind: node.ObjectKind(), Namespace: node.Namespace, Name: node.Name}
+
+		obj, err := e.getObject(ctx, node)
+		if apierrors.IsNotFound(err) {
+			drift.Missing = true
+			report.Objects = append(report.Objects, drift)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("get %s %s/%s: %w", drift.Kind, node.Namespace, node.Name, err)
+		}
+		live, err := plain(obj)
+		if err != nil {
+			return nil, err
+		}
+		if dep, ok := obj.(*appsv1.Deployment); ok {
+			if err := keptSelector(desired, node, dep); err != nil {
+				return nil, err
+			}
+		}
+
+		drift.Fields = diffOwned("", desired.Object, live)
+		if len(drift.Fields) > 0 {
+			report.Objects = append(report.Objects, drift)
+		}
+	}
+	return report, nil
+}
+
+// plain returns a live object as plain JSON values.
+func plain(obj metav1.Object) (map[string]any, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.Object, nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// keptSelector updates the desired object of a Deployment node that Apply
+// keeps on its legacy selector to expect that selector, so it is not
+// reported as drift.
+func keptSelector(desired *unstructured.Unstructured, node *ast.Node, live *appsv1.Deployment) error {
+	dep, err := render.Deployment(node)
+	if err != nil {
+		return err
+	}
+	selector := legacySelector(dep, live)
+	if selector == nil {
+		return nil
+	}
+	keepSelector(dep, selector)
+	if err := unstructured.SetNestedStringMap(desired.Object, dep.Spec.Selector.MatchLabels, "spec", "selector", "matchLabels"); err != nil {
+		return err
+	}
+	return unstructured.SetNestedStringMap(desired.Object, dep.Spec.Template.Labels, "spec", "template", "metadata", "labels")
+}
+
+// diffOwned walks the desired value and compares every leaf with the live
+// value at the same path. Lists are compared element by element.
+func diffOwned(path string, desired, live any) []FieldDiff {
+	switch d := desired.(type) {
+	case map[string]any:
+		l, ok := live.(map[string]any)
+		if !ok {
+			return []FieldDiff{{Path: path, Expected: desired, Actual: live}}
+		}
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var diffs []FieldDiff
+		for _, k := range keys {
+			diffs = append(diffs, diffOwned(joinPath(path, k), d[k], l[k])...)
+		}
+		return diffs
+	case []any:
+		l, ok := live.([]any)
+		if !ok || len(l) != len(d) {
+			return []FieldDiff{{Path: path, Expected: desired, Actual: live}}
+		}
+		var diffs []FieldDiff
+		for i := range d {
+			diffs = append(diffs, diffOwned(fmt.Sprintf("%s[%d]", path, i), d[i], l[i])...)
+		}
+		return diffs
+	default:
+		if !sameScalar(desired, live) {
+			return []FieldDiff{{Path: path, Expected: desired, Actual: live}}
+		}
+		return nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// sameScalar compares JSON scalars, treating integers and floats with the
+// same value as equal.
+func sameScalar(a, b any) bool {
+	if fa, ok := number(a); ok {
+		fb, ok := number(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEngineDetectDrift(t *testing.T) {
+	certGVK := schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+	certGVR := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(certGVK, meta.RESTScopeNamespace)
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{certGVR: "CertificateList"})
+
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, dynamic: dyn, mapper: mapper, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	api := dsl.NewService("api", 80, 8080)
+	applyGraph(t, eng, dsl.NewGraph().
+		Add(api).
+		Add(dsl.NewService("db", 5432, 5432)).
+		Add(dsl.NewDeployment("web", "nginx:1.27").Replicas(2).AttachedTo(api)).
+		Add(dsl.NewCustom("cert-manager.io/v1", "Certificate", "tls").Spec(map[string]any{"secretName": "tls", "duration": 24})))
+
+	report, err := eng.DetectDrift(ctx, "env")
+	if err != nil {
+		t.Fatalf("DetectDrift failed: %v", err)
+	}
+	if report.HasDrift() || report.Checked != 4 {
+		t.Fatalf("expected no drift right after apply, got %s", report)
+	}
+
+	// Server-side additions are not drift; hand edits to owned fields are.
+	dep, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	dep.Spec.Replicas = new(int32)
+	dep.Labels["added-by"] = "someone"
+	dep.Spec.Template.Spec.Containers[0].ImagePullPolicy = "Always"
+	client.AppsV1().Deployments("default").Update(ctx, dep, metav1.UpdateOptions{})
+	client.CoreV1().Services("default").Delete(ctx, "db", metav1.DeleteOptions{})
+	cert, _ := dyn.Resource(certGVR).Namespace("default").Get(ctx, "tls", metav1.GetOptions{})
+	unstructured.SetNestedField(cert.Object, "other", "spec", "secretName")
+	dyn.Resource(certGVR).Namespace("default").Update(ctx, cert, metav1.UpdateOptions{})
+
+	report, err = eng.DetectDrift(ctx, "env")
+	if err != nil {
+		t.Fatalf("DetectDrift failed: %v", err)
+	}
+	if len(report.Objects) != 3 {
+		t.Fatalf("expected 3 drifted objects, got %s", report)
+	}
+	text := report.String()
+	for _, want := range []string{
+		"Service default/db: missing from the cluster",
+		"spec.replicas: expected 2, got 0",
+		`spec.secretName: expected "tls", got "other"`,
+		"Drift: 3 of 4 objects drifted.",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("report missing %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "added-by") || strings.Contains(text, "imagePullPolicy") {
+		t.Errorf("fields kube-goAT does not own were reported:\n%s", text)
+	}
+
+	out, err := report.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DriftReport
+	if err := json.Unmarshal(out, &decoded); err != nil || len(decoded.Objects) != 3 {
+		t.Errorf("unexpected JSON report: %s", out)
+	}
+
+	if _, err := eng.DetectDrift(ctx, "missing"); err == nil {
+		t.Error("expected an error without state")
+	}
+}
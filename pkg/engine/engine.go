@@ -1,37 +1,88 @@
 package engine
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"time"
 
 	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
 	"github.com/arpanpathak/kube-goAT/pkg/state"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// ErrSelectorChanged is returned when a Deployment's immutable selector
+// differs from the live object and recreation was not enabled.
+var ErrSelectorChanged = errors.New("deployment selector changed")
+
+// ErrHeadlessChanged is returned when a Service would switch to or from
+// headless, which its immutable clusterIP does not allow in place.
+var ErrHeadlessChanged = errors.New("service headlessness changed")
+
 type Engine struct {
-	client kubernetes.Interface
-	store  state.Store
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+	mapper  meta.RESTMapper
+	store   state.Store
+
+	recreateOnSelectorChange bool
+	deleteTimeout            time.Duration
+	adopt                    bool
+	pruneKinds               []string
+	trust                    signing.Verifier
 }
 
-func NewEngine(kubeconfig string, store state.Store) (*Engine, error) {
+func NewEngine(kubeconfig string, store state.Store, opts ...Option) (*Engine, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
 	if err != nil {
 		return nil, err
 	}
+	return NewEngineForConfig(config, store, opts...)
+}
+
+// NewEngineForConfig creates an Engine from an already loaded REST config,
+// such as one resolved for a specific kubeconfig context.
+func NewEngineForConfig(config *rest.Config, store state.Store, opts ...Option) (*Engine, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
-	return &Engine{client: clientset, store: store}, nil
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	return NewEngineForClients(clientset, dyn, mapper, store, opts...), nil
+}
+
+// NewEngineForClients creates an Engine from existing clients, such as the
+// fake clientsets in tests. dyn and mapper may be nil when the graph has no
+// Custom nodes.
+func NewEngineForClients(client kubernetes.Interface, dyn dynamic.Interface, mapper meta.RESTMapper, store state.Store, opts ...Option) *Engine {
+	e := &Engine{client: client, dynamic: dyn, mapper: mapper, store: store}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // GetClient returns the underlying Kubernetes interface.
@@ -45,155 +96,459 @@ func (e *Engine) SetStore(store state.Store) {
 }
 
 // Apply takes a binary Gob AST, compares it to the tracked state, and creates/updates K8s resources.
+// Nodes are applied dependencies first; graphs with unknown or cyclic
+// dependencies are refused before anything is applied. A failing node
+// does not stop the others, except for its dependents, which are skipped;
+// every failure is returned together in an *ApplyError. State is saved
+// when a node fails and once at the end, and records what actually
+// exists, plus nodes whose create failed, with the failure of each node
+// whose last operation failed in DAG.Status. With WithTrust, payloads
+// whose signature does not verify are refused before anything is applied,
+// except unsigned payloads matching the signed payload recorded in state,
+// whose signature is verified again. The store's lock on stateKey is held
+// throughout when the store is a state.Locker.
 func (e *Engine) Apply(ctx context.Context, payload []byte, stateKey string) error {
+	ctx, unlock, err := state.Lock(ctx, e.store, stateKey)
+	if err != nil {
+		return err
+	}
+	return unlocked(e.apply(ctx, payload, stateKey), unlock)
+}
+
+func (e *Engine) apply(ctx context.Context, payload []byte, stateKey string) error {
+	signed := payload
+	payload, signer, unverified := e.openPayload(payload)
+	if unverified != nil && !errors.Is(unverified, signing.ErrUnsigned) {
+		return unverified
+	}
 	// Deserialization of the "RISC" binary instructions.
 	dag, err := ast.Deserialize(payload)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize AST: %w", err)
 	}
+	if signer == "" {
+		signed = nil
+	}
+	dag.Signer, dag.SignedPayload = signer, signed
+	order, err := dag.TopologicalOrder()
+	if err != nil {
+		return fmt.Errorf("invalid graph: %w", err)
+	}
 
-	// State Check Guardrails
+	// State Check Guardrails: proceeding without a readable state would
+	// forget every resource it records.
 	var oldDag *ast.DAG
-	existingState, err := e.store.Load(ctx, stateKey)
-	if err == nil {
+	existingState, found, err := e.loadState(ctx, stateKey)
+	if err != nil {
+		return err
+	}
+	if found {
 		log.Printf("[Engine] Loaded existing state for %s (%d bytes)", stateKey, len(existingState))
-		oldDag, _ = ast.Deserialize(existingState)
+		if oldDag, err = ast.Deserialize(existingState); err != nil {
+			return stateError(stateKey, err)
+		}
 	} else {
 		log.Printf("[Engine] No existing state found for %s, creating new.", stateKey)
 	}
+	if unverified != nil {
+		var ok bool
+		if signer, signed, ok = e.verifiedState(dag, oldDag); !ok {
+			return unverified
+		}
+		dag.Signer, dag.SignedPayload = signer, signed
+	}
+
+	var failures []*NodeError
+	// The checkpoint is saved on every new failure and before returning, so
+	// a failed run leaves state describing what actually exists.
+	format := stateFormat(existingState, payload)
+	progress := e.newCheckpoint(stateKey, oldDag, format)
+	progress.signer, progress.signed = signer, signed
+	fail := func(nodeErr *NodeError, key string, node *ast.Node) error {
+		failures = append(failures, nodeErr)
+		return progress.failed(ctx, nodeErr, key, node)
+	}
+	abort := func(err error) error {
+		if len(failures) > 0 {
+			return errors.Join(&ApplyError{Errors: failures}, err)
+		}
+		return err
+	}
 
-	// Deletion Loop: Track removed resources
+	// Deletion Loop: Track removed resources by the object they manage, so
+	// re-keyed nodes are not mistaken for removals.
 	if oldDag != nil {
-		for name, oldNode := range oldDag.Nodes {
-			if _, exists := dag.Nodes[name]; !exists {
-				log.Printf("[Engine] Deleting removed resource: %s (%s)", name, oldNode.Kind)
-				switch oldNode.Kind {
-				case "Service":
-					e.client.CoreV1().Services(oldNode.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
-				case "Deployment":
-					e.client.AppsV1().Deployments(oldNode.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		desired := make(map[string]bool, len(dag.Nodes))
+		for _, node := range dag.Nodes {
+			desired[node.Identity()] = true
+		}
+		for oldKey, oldNode := range oldDag.Nodes {
+			if desired[oldNode.Identity()] {
+				continue
+			}
+			if createFailed(oldDag, oldNode) {
+				progress.deleted(oldNode)
+				continue
+			}
+			if oldNode.Protected {
+				log.Printf("[Engine] Keeping protected resource removed from the graph: %s (%s)", oldNode.Name, oldNode.ObjectKind())
+				progress.deleted(oldNode)
+				continue
+			}
+			log.Printf("[Engine] Deleting removed resource: %s (%s)", oldNode.Name, oldNode.ObjectKind())
+			err := e.deleteNode(ctx, oldNode, stateKey, metav1.DeleteOptions{})
+			switch {
+			case errors.Is(err, errUnsupportedKind):
+				log.Printf("[WARNING] Unsupported node kind: %s", oldNode.Kind)
+				progress.deleted(oldNode)
+			case err != nil:
+				if err := fail(newNodeError("delete", oldNode, err), oldKey, oldNode); err != nil {
+					return abort(err)
 				}
+			default:
+				progress.deleted(oldNode)
 			}
 		}
 	}
 
 	// Execution Loop
-	for _, node := range dag.Nodes {
+	recorded := make(map[string]string) // identity to hash, empty if failed
+	if oldDag != nil {
+		for _, node := range oldDag.Nodes {
+			if createFailed(oldDag, node) {
+				continue
+			}
+			if _, failed := oldDag.Status[node.Identity()]; failed {
+				recorded[node.Identity()] = ""
+			} else {
+				recorded[node.Identity()] = node.Hash()
+			}
+		}
+	}
+	hash := payloadHash(payload)
+	failed := make(map[string]bool)
+	for _, key := range order {
+		node := dag.Nodes[key]
+		nodeHash := node.Hash()
+		oldHash, wasRecorded := recorded[node.Identity()]
+		own := owner{stateKey: stateKey, node: key, hash: hash, nodeHash: nodeHash, protect: node.Protected,
+			recorded: wasRecorded, unchanged: wasRecorded && oldHash == nodeHash}
+		if dep := failedDependency(node, failed); dep != "" {
+			failed[key] = true
+			if err := fail(newNodeError("apply", node, fmt.Errorf("%w: %s", ErrDependencyFailed, dep)), key, node); err != nil {
+				return abort(err)
+			}
+			continue
+		}
+		var op string
 		switch node.Kind {
 		case "Service":
-			if err := e.applyService(ctx, node); err != nil {
-				return err
-			}
+			op, err = e.applyService(ctx, node, own)
 		case "Deployment":
-			if err := e.applyDeployment(ctx, node); err != nil {
-				return err
-			}
+			op, err = e.applyDeployment(ctx, node, own)
+		case "Custom":
+			op, err = e.applyCustom(ctx, node, own)
 		default:
 			log.Printf("[WARNING] Unsupported node kind: %s", node.Kind)
+			op, err = "skip", nil
+		}
+		if err != nil {
+			failed[key] = true
+			if err := fail(newNodeError(op, node, err), key, node); err != nil {
+				return abort(err)
+			}
+			continue
 		}
+		progress.applied(key, node)
 	}
 
-	// Finalize State Record
-	return e.store.Save(ctx, stateKey, payload)
-}
-
-func (e *Engine) applyService(ctx context.Context, node *ast.Node) error {
-	var port, targetPort int32
-	if p, ok := node.Properties["port"].(int32); ok {
-		port = p
+	// Finalize State Record: a clean run records the payload itself,
+	// minus any failures carried over when re-applying recorded state and
+	// plus the verified signer. Runs that stop short save the checkpoint.
+	if len(failures) > 0 {
+		if err := progress.flush(ctx); err != nil {
+			return abort(err)
+		}
+		return &ApplyError{Errors: failures}
 	}
-	if p, ok := node.Properties["targetPort"].(int32); ok {
-		targetPort = p
+	if len(e.pruneKinds) > 0 {
+		pruneFailures, err := e.prune(ctx, dag, oldDag, stateKey)
+		if err == nil && len(pruneFailures) > 0 {
+			err = &ApplyError{Errors: pruneFailures}
+		}
+		if err != nil {
+			if saveErr := progress.flush(ctx); saveErr != nil {
+				return errors.Join(err, saveErr)
+			}
+			return err
+		}
+	}
+	if len(dag.Status) > 0 || dag.Signer != "" || stateFormat(nil, payload) != format {
+		dag.Status = nil
+		return e.saveDAG(ctx, stateKey, dag, format)
 	}
+	if found && bytes.Equal(existingState, payload) {
+		return nil
+	}
+	return e.store.Save(ctx, stateKey, payload)
+}
 
-	labels := make(map[string]string)
-	if l, ok := node.Properties["labels"].(map[string]string); ok {
-		for k, v := range l {
-			labels[k] = v
+func failedDependency(node *ast.Node, failed map[string]bool) string {
+	for _, dep := range node.Dependencies {
+		if failed[dep] {
+			return dep
 		}
 	}
+	return ""
+}
 
-	svc := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      node.Name,
-			Namespace: node.Namespace,
-			Labels:    labels,
-		},
-		Spec: corev1.ServiceSpec{
-			Selector: labels,
-			Ports: []corev1.ServicePort{
-				{Port: port, TargetPort: intstr.FromInt32(targetPort)},
-			},
-		},
+// applyService creates or updates a Service, returning which it attempted.
+func (e *Engine) applyService(ctx context.Context, node *ast.Node, own owner) (string, error) {
+	svc, err := render.Service(node)
+	if err != nil {
+		return "apply", err
 	}
+	own.stamp(svc)
 
 	existingSvc, err := e.client.CoreV1().Services(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
+	if apierrors.IsNotFound(err) {
 		_, err = e.client.CoreV1().Services(node.Namespace).Create(ctx, svc, metav1.CreateOptions{})
 		log.Printf("[Engine] Created Service: %s", node.Name)
-		return err
+		return "create", err
 	} else if err != nil {
-		return err
+		return "apply", err
+	}
+	if err := e.checkOwner(existingSvc, own); err != nil {
+		return "update", err
+	}
+	if own.upToDate(node, existingSvc) {
+		log.Printf("[Engine] Unchanged Service: %s", node.Name)
+		return "update", nil
 	}
 
 	// Upsert update logic to fix drift
 	svc.ResourceVersion = existingSvc.ResourceVersion
-	svc.Spec.ClusterIP = existingSvc.Spec.ClusterIP
+	if svc.Spec.Type != corev1.ServiceTypeExternalName && existingSvc.Spec.Type != corev1.ServiceTypeExternalName {
+		// clusterIP is immutable once allocated, so keep the allocated one
+		// unless the graph sets it; switching to or from "None" needs a
+		// new Service.
+		if headless(svc) != headless(existingSvc) {
+			return "update", fmt.Errorf("service %s/%s: %w from clusterIP %q to %q; delete it so it can be recreated",
+				svc.Namespace, svc.Name, ErrHeadlessChanged, existingSvc.Spec.ClusterIP, svc.Spec.ClusterIP)
+		}
+		if svc.Spec.ClusterIP == "" {
+			svc.Spec.ClusterIP = existingSvc.Spec.ClusterIP
+		}
+		if len(svc.Spec.ClusterIPs) == 0 {
+			svc.Spec.ClusterIPs = existingSvc.Spec.ClusterIPs
+		}
+	}
+	preserveNodePorts(svc, existingSvc)
 	_, err = e.client.CoreV1().Services(node.Namespace).Update(ctx, svc, metav1.UpdateOptions{})
 	log.Printf("[Engine] Updated Service: %s", node.Name)
-	return err
+	return "update", err
 }
 
-func (e *Engine) applyDeployment(ctx context.Context, node *ast.Node) error {
-	image := "nginx:latest"
-	if img, ok := node.Properties["image"].(string); ok {
-		image = img
-	}
+func headless(svc *corev1.Service) bool {
+	return svc.Spec.ClusterIP == corev1.ClusterIPNone
+}
 
-	var replicas int32 = 1
-	if r, ok := node.Properties["replicas"].(int32); ok {
-		replicas = r
+// preserveNodePorts keeps node ports the API server allocated for ports that
+// do not pin one explicitly, so every update doesn't reshuffle them.
+func preserveNodePorts(svc, existing *corev1.Service) {
+	if svc.Spec.Type != corev1.ServiceTypeNodePort && svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return
 	}
-
-	labels := make(map[string]string)
-	if l, ok := node.Properties["labels"].(map[string]string); ok {
-		for k, v := range l {
-			labels[k] = v
+	allocated := make(map[string]int32)
+	for _, p := range existing.Spec.Ports {
+		allocated[fmt.Sprintf("%s/%d/%s", p.Name, p.Port, p.Protocol)] = p.NodePort
+	}
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if p.NodePort == 0 {
+			p.NodePort = allocated[fmt.Sprintf("%s/%d/%s", p.Name, p.Port, p.Protocol)]
 		}
 	}
+}
 
-	dep := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      node.Name,
-			Namespace: node.Namespace,
-			Labels:    labels,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{MatchLabels: labels},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{Labels: labels},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{Name: "app", Image: image},
-					},
-				},
-			},
-		},
+// applyDeployment creates, updates or recreates a Deployment, returning
+// which it attempted.
+func (e *Engine) applyDeployment(ctx context.Context, node *ast.Node, own owner) (string, error) {
+	dep, err := render.Deployment(node)
+	if err != nil {
+		return "apply", err
 	}
+	own.stamp(dep)
 
 	existingDep, err := e.client.AppsV1().Deployments(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
+	if apierrors.IsNotFound(err) {
 		_, err = e.client.AppsV1().Deployments(node.Namespace).Create(ctx, dep, metav1.CreateOptions{})
 		log.Printf("[Engine] Created Deployment: %s", node.Name)
-		return err
+		return "create", err
 	} else if err != nil {
-		return err
+		return "apply", err
+	}
+	if err := e.checkOwner(existingDep, own); err != nil {
+		return "update", err
+	}
+	if own.upToDate(node, existingDep) {
+		log.Printf("[Engine] Unchanged Deployment: %s", node.Name)
+		return "update", nil
+	}
+
+	if selector := legacySelector(dep, existingDep); selector != nil {
+		keepSelector(dep, selector)
+	}
+	if !equality.Semantic.DeepEqual(existingDep.Spec.Selector, dep.Spec.Selector) {
+		return "update", e.recreateDeployment(ctx, dep, existingDep)
 	}
 
 	// Upsert logic for deep synchronization
 	dep.ResourceVersion = existingDep.ResourceVersion
 	_, err = e.client.AppsV1().Deployments(node.Namespace).Update(ctx, dep, metav1.UpdateOptions{})
 	log.Printf("[Engine] Updated Deployment: %s", node.Name)
+	return "update", err
+}
+
+// applyCustom creates or updates a custom resource, returning which it
+// attempted.
+func (e *Engine) applyCustom(ctx context.Context, node *ast.Node, own owner) (string, error) {
+	res, err := e.customResource(node)
+	if err != nil {
+		return "apply", err
+	}
+
+	obj, err := render.Custom(node)
+	if err != nil {
+		return "apply", err
+	}
+	own.stamp(obj)
+	kind := obj.GetKind()
+
+	existing, err := res.Get(ctx, node.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = res.Create(ctx, obj, metav1.CreateOptions{})
+		log.Printf("[Engine] Created %s: %s", kind, node.Name)
+		return "create", err
+	} else if err != nil {
+		return "apply", err
+	}
+	if err := e.checkOwner(existing, own); err != nil {
+		return "update", err
+	}
+	if own.upToDate(node, existing) {
+		log.Printf("[Engine] Unchanged %s: %s", kind, node.Name)
+		return "update", nil
+	}
+
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	_, err = res.Update(ctx, obj, metav1.UpdateOptions{})
+	log.Printf("[Engine] Updated %s: %s", kind, node.Name)
+	return "update", err
+}
+
+// customResource resolves the dynamic client for a Custom node through the
+// RESTMapper, scoping it to the node's namespace for namespaced kinds.
+func (e *Engine) customResource(node *ast.Node) (dynamic.ResourceInterface, error) {
+	if e.dynamic == nil {
+		return nil, fmt.Errorf("custom resource %s: engine has no dynamic client", node.Name)
+	}
+	mapping, err := e.customMapping(node)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return e.dynamic.Resource(mapping.Resource), nil
+	}
+	return e.dynamic.Resource(mapping.Resource).Namespace(node.Namespace), nil
+}
+
+func (e *Engine) customMapping(node *ast.Node) (*meta.RESTMapping, error) {
+	if e.mapper == nil {
+		return nil, fmt.Errorf("custom resource %s: engine has no dynamic client", node.Name)
+	}
+	spec, err := ast.SpecOf[*ast.CustomSpec](node)
+	if err != nil {
+		return nil, err
+	}
+	gv, err := schema.ParseGroupVersion(spec.APIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("custom resource %s: %w", node.Name, err)
+	}
+	mapping, err := e.mapper.RESTMapping(gv.WithKind(spec.Kind).GroupKind(), gv.Version)
+	if err != nil {
+		return nil, fmt.Errorf("custom resource %s: %w", node.Name, err)
+	}
+	return mapping, nil
+}
+
+// Namespaced reports whether the object a node manages lives in its
+// namespace. Services and Deployments always do; Custom nodes are looked
+// up in the RESTMapper, whatever namespace they declare.
+func (e *Engine) Namespaced(node *ast.Node) (bool, error) {
+	if node.Kind != "Custom" {
+		return true, nil
+	}
+	mapping, err := e.customMapping(node)
+	if err != nil {
+		return false, err
+	}
+	return mapping.Scope.Name() != meta.RESTScopeNameRoot, nil
+}
+
+// legacySelector returns the selector of a Deployment created before
+// selectors were generated from the graph, which selected its pods by all
+// of their labels, when dep would replace it with the generated one. It
+// returns nil for any other selector.
+func legacySelector(dep, existing *appsv1.Deployment) map[string]string {
+	if want := dep.Spec.Selector.MatchLabels; len(want) != 1 || want[dsl.DeploymentSelectorLabel] != dep.Name {
+		return nil
+	}
+	live := existing.Spec.Selector
+	if live == nil || len(live.MatchExpressions) > 0 || len(live.MatchLabels) == 0 {
+		return nil
+	}
+	if _, generated := live.MatchLabels[dsl.DeploymentSelectorLabel]; generated {
+		return nil
+	}
+	// It selects exactly its pods' labels until kube-goAT first updates
+	// it; from then on its pods carry the generated label too.
+	pods := existing.Spec.Template.Labels
+	if !maps.Equal(live.MatchLabels, pods) && pods[dsl.DeploymentSelectorLabel] != dep.Name {
+		return nil
+	}
+	return live.MatchLabels
+}
+
+// keepSelector makes dep keep a legacy selector rather than be refused or
+// recreated over it. Its pods keep the selected labels besides the
+// generated ones, so Services attached to it route to them either way.
+func keepSelector(dep *appsv1.Deployment, selector map[string]string) {
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: maps.Clone(selector)}
+	if dep.Spec.Template.Labels == nil {
+		dep.Spec.Template.Labels = make(map[string]string, len(selector))
+	}
+	maps.Copy(dep.Spec.Template.Labels, selector)
+}
+
+// recreateDeployment replaces a Deployment whose immutable selector changed.
+// Dependents are collected in the background so the new object can be
+// created straight away.
+func (e *Engine) recreateDeployment(ctx context.Context, dep, existing *appsv1.Deployment) error {
+	if !e.recreateOnSelectorChange {
+		return fmt.Errorf("deployment %s/%s: %w from %v to %v; enable WithRecreateOnSelectorChange to replace it",
+			dep.Namespace, dep.Name, ErrSelectorChanged, existing.Spec.Selector.MatchLabels, dep.Spec.Selector.MatchLabels)
+	}
+
+	background := metav1.DeletePropagationBackground
+	err := e.client.AppsV1().Deployments(dep.Namespace).Delete(ctx, dep.Name, metav1.DeleteOptions{
+		PropagationPolicy: &background,
+		Preconditions:     &metav1.Preconditions{UID: &existing.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = e.client.AppsV1().Deployments(dep.Namespace).Create(ctx, dep, metav1.CreateOptions{})
+	log.Printf("[Engine] Recreated Deployment with new selector: %s", dep.Name)
 	return err
 }
@@ -6,8 +6,8 @@ import (
 	"testing"
 
 	"github.com/arpanpathak/kube-goAT/pkg/dsl"
-	"github.com/arpanpathak/kube-goAT/pkg/state"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 	ktesting "k8s.io/client-go/testing"
@@ -18,7 +18,7 @@ func TestEngineApply_ServiceAPIError(t *testing.T) {
 	client.PrependReactor("get", "services", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
 		return true, nil, errors.New("simulated API error")
 	})
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 	eng := &Engine{client: client, store: store}
 
 	svc := dsl.NewService("test-svc", 80, 8080)
@@ -34,7 +34,7 @@ func TestEngineApply_ServiceCreateError(t *testing.T) {
 	client.PrependReactor("create", "services", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
 		return true, nil, errors.New("simulated Create error")
 	})
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 	eng := &Engine{client: client, store: store}
 
 	svc := dsl.NewService("test-svc", 80, 8080)
@@ -50,7 +50,7 @@ func TestEngineApply_DeploymentAPIError(t *testing.T) {
 	client.PrependReactor("get", "deployments", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
 		return true, nil, errors.New("simulated API error")
 	})
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 	eng := &Engine{client: client, store: store}
 
 	dep := dsl.NewDeployment("test-dep", "nginx")
@@ -66,7 +66,7 @@ func TestEngineApply_DeploymentCreateError(t *testing.T) {
 	client.PrependReactor("create", "deployments", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
 		return true, nil, errors.New("simulated Create error")
 	})
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 	eng := &Engine{client: client, store: store}
 
 	dep := dsl.NewDeployment("test-dep", "nginx")
@@ -76,3 +76,42 @@ func TestEngineApply_DeploymentCreateError(t *testing.T) {
 		t.Error("Expected simulated Create error")
 	}
 }
+
+func TestEngineApply_DependencyCycle(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+
+	a := dsl.NewService("a", 80, 8080)
+	b := dsl.NewService("b", 80, 8080).DependsOn(a)
+	a.DependsOn(b)
+	payload, _ := dsl.NewGraph().Add(a).Add(b).Build().Serialize()
+	if err := eng.Apply(context.Background(), payload, "key"); err == nil {
+		t.Fatal("Expected the dependency cycle to be refused")
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("Expected nothing applied, got %v", client.Actions())
+	}
+}
+
+func TestEngineApply_SharedNameDependencyFailed(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "services", func(action ktesting.Action) (handled bool, ret runtime.Object, err error) {
+		return true, nil, errors.New("simulated Create error")
+	})
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+
+	// The Service is keyed by its identity; its failure must still skip
+	// the Deployment attached to it.
+	svc := dsl.NewService("web", 80, 8080)
+	dep := dsl.NewDeployment("web", "nginx").AttachedTo(svc)
+	payload, _ := dsl.NewGraph().Add(dep).Add(svc).Build().Serialize()
+	err := eng.Apply(context.Background(), payload, "key")
+	if !errors.Is(err, ErrDependencyFailed) {
+		t.Fatalf("Expected the Deployment to be skipped, got %v", err)
+	}
+	if _, getErr := client.AppsV1().Deployments("default").Get(context.Background(), "web", metav1.GetOptions{}); getErr == nil {
+		t.Error("Expected the Deployment not to be created")
+	}
+}
@@ -3,13 +3,12 @@ package engine
 import (
 	"testing"
 
-	"github.com/arpanpathak/kube-goAT/pkg/state"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestEngineHelpers(t *testing.T) {
 	client := fake.NewSimpleClientset()
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 
 	eng := &Engine{client: client}
 
@@ -2,19 +2,28 @@ package engine
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/arpanpathak/kube-goAT/pkg/ast"
 	"github.com/arpanpathak/kube-goAT/pkg/dsl"
-	"github.com/arpanpathak/kube-goAT/pkg/state"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestEngineApply(t *testing.T) {
 	client := fake.NewSimpleClientset()
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 
 	// Create engine instance manually to bypass kubeconfig requirement for testing
 	eng := &Engine{
@@ -110,7 +119,7 @@ func TestEngineApply(t *testing.T) {
 
 func TestEngineApply_UnsupportedKind(t *testing.T) {
 	client := fake.NewSimpleClientset()
-	store := state.NewLocalStore(t.TempDir())
+	store := newLocalStore(t)
 	eng := &Engine{client: client, store: store}
 
 	dag := &ast.DAG{
@@ -126,3 +135,224 @@ func TestEngineApply_UnsupportedKind(t *testing.T) {
 		t.Errorf("Expected unsupported kinds to be skipped but apply failed: %v", err)
 	}
 }
+
+func TestEngineApply_ServiceTypes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	web := dsl.NewService("web", 80, 8080).PortName("http").
+		PortTo("metrics", 9090, "metrics").
+		Type(dsl.NodePort).
+		StickySessions(300)
+	db := dsl.NewService("db", 5432, 5432).Headless()
+	ext := dsl.NewExternalService("upstream", "api.example.com")
+
+	payload, _ := dsl.NewGraph().Add(web).Add(db).Add(ext).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "svc-types"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	s, _ := client.CoreV1().Services("default").Get(ctx, "web", metav1.GetOptions{})
+	if s.Spec.Type != corev1.ServiceTypeNodePort || len(s.Spec.Ports) != 2 {
+		t.Fatalf("Unexpected web service spec: %+v", s.Spec)
+	}
+	if s.Spec.Ports[1].TargetPort != intstr.FromString("metrics") {
+		t.Errorf("Expected named targetPort, got %v", s.Spec.Ports[1].TargetPort)
+	}
+	if s.Spec.SessionAffinity != corev1.ServiceAffinityClientIP || *s.Spec.SessionAffinityConfig.ClientIP.TimeoutSeconds != 300 {
+		t.Errorf("Expected ClientIP session affinity")
+	}
+
+	// Simulate the API server allocating a node port and make sure updates keep it.
+	s.Spec.Ports[0].NodePort = 31000
+	client.CoreV1().Services("default").Update(ctx, s, metav1.UpdateOptions{})
+	if err := eng.Apply(ctx, payload, "svc-types"); err != nil {
+		t.Fatalf("Second apply failed: %v", err)
+	}
+	s, _ = client.CoreV1().Services("default").Get(ctx, "web", metav1.GetOptions{})
+	if s.Spec.Ports[0].NodePort != 31000 {
+		t.Errorf("Expected allocated node port to be preserved, got %d", s.Spec.Ports[0].NodePort)
+	}
+
+	h, _ := client.CoreV1().Services("default").Get(ctx, "db", metav1.GetOptions{})
+	if h.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("Expected headless service, got clusterIP %q", h.Spec.ClusterIP)
+	}
+
+	x, _ := client.CoreV1().Services("default").Get(ctx, "upstream", metav1.GetOptions{})
+	if x.Spec.Type != corev1.ServiceTypeExternalName || x.Spec.ExternalName != "api.example.com" || x.Spec.Selector != nil {
+		t.Errorf("Unexpected external service spec: %+v", x.Spec)
+	}
+}
+
+func TestEngineApply_HeadlessChange(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	payload, _ := dsl.NewGraph().Add(dsl.NewService("db", 5432, 5432)).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "headless"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	// Simulate the API server allocating a cluster IP.
+	s, _ := client.CoreV1().Services("default").Get(ctx, "db", metav1.GetOptions{})
+	s.Spec.ClusterIP, s.Spec.ClusterIPs = "10.0.0.10", []string{"10.0.0.10"}
+	client.CoreV1().Services("default").Update(ctx, s, metav1.UpdateOptions{})
+
+	// Updates keep the allocated cluster IP.
+	payload, _ = dsl.NewGraph().Add(dsl.NewService("db", 5433, 5432)).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "headless"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	s, _ = client.CoreV1().Services("default").Get(ctx, "db", metav1.GetOptions{})
+	if s.Spec.ClusterIP != "10.0.0.10" || s.Spec.Ports[0].Port != 5433 {
+		t.Errorf("Expected the update to keep the cluster IP, got %+v", s.Spec)
+	}
+
+	// Going headless is refused rather than silently reverted.
+	payload, _ = dsl.NewGraph().Add(dsl.NewService("db", 5433, 5432).Headless()).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "headless"); !errors.Is(err, ErrHeadlessChanged) {
+		t.Fatalf("Expected ErrHeadlessChanged, got %v", err)
+	}
+
+	// Once the Service is deleted, it is recreated headless.
+	client.CoreV1().Services("default").Delete(ctx, "db", metav1.DeleteOptions{})
+	if err := eng.Apply(ctx, payload, "headless"); err != nil {
+		t.Fatalf("Recreate failed: %v", err)
+	}
+	s, _ = client.CoreV1().Services("default").Get(ctx, "db", metav1.GetOptions{})
+	if s.Spec.ClusterIP != corev1.ClusterIPNone {
+		t.Errorf("Expected a headless service, got clusterIP %q", s.Spec.ClusterIP)
+	}
+}
+
+func TestEngineApply_SelectorChange(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	// A Deployment created before selectors were generated from the graph,
+	// which selected its pods by all their labels, and the state recorded
+	// with it.
+	labels := map[string]string{"app": "web", "team": "x"}
+	client.AppsV1().Deployments("default").Create(ctx, &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+			},
+		},
+	}, metav1.CreateOptions{})
+	legacy := &ast.DAG{Nodes: map[string]*ast.Node{
+		"web": {Kind: "Deployment", Name: "web", Namespace: "default", Spec: &ast.DeploymentSpec{
+			Image:    "nginx",
+			Replicas: 1,
+			Labels:   labels,
+		}},
+	}}
+	payload, _ := legacy.Serialize()
+	eng.store.Save(ctx, "selectors", payload)
+
+	// It keeps its selector, and its pods get the generated labels too.
+	for _, team := range []string{"x", "y"} {
+		payload, _ = dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Label("team", team)).Build().Serialize()
+		if err := eng.Apply(ctx, payload, "selectors"); err != nil {
+			t.Fatalf("Expected the legacy selector to be kept, got %v", err)
+		}
+		d, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+		if !reflect.DeepEqual(d.Spec.Selector.MatchLabels, map[string]string{"app": "web", "team": "x"}) {
+			t.Errorf("Expected the legacy selector to be kept, got %v", d.Spec.Selector.MatchLabels)
+		}
+		if pods := d.Spec.Template.Labels; pods[dsl.DeploymentSelectorLabel] != "web" || pods["app"] != "web" || pods["team"] != "x" {
+			t.Errorf("Expected pods to carry both selectors, got %v", pods)
+		}
+		if report, err := eng.DetectDrift(ctx, "selectors"); err != nil || report.HasDrift() {
+			t.Errorf("Expected the kept selector not to drift, got %v %v", report, err)
+		}
+	}
+
+	payload, _ = dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Label("team", "x").Selector("tier", "web")).Build().Serialize()
+	err := eng.Apply(ctx, payload, "selectors")
+	if !errors.Is(err, ErrSelectorChanged) {
+		t.Fatalf("Expected ErrSelectorChanged, got %v", err)
+	}
+
+	WithRecreateOnSelectorChange()(eng)
+	if err := eng.Apply(ctx, payload, "selectors"); err != nil {
+		t.Fatalf("Recreate apply failed: %v", err)
+	}
+	d, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if !reflect.DeepEqual(d.Spec.Selector.MatchLabels, map[string]string{"tier": "web"}) {
+		t.Errorf("Expected the new selector after recreate, got %v", d.Spec.Selector.MatchLabels)
+	}
+	if d.Labels["team"] != "x" || d.Spec.Template.Labels["tier"] != "web" {
+		t.Errorf("Unexpected labels after recreate: %v / %v", d.Labels, d.Spec.Template.Labels)
+	}
+
+	// Cosmetic labels no longer touch the selector.
+	payload, _ = dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Label("team", "y").Selector("tier", "web")).Build().Serialize()
+	eng.recreateOnSelectorChange = false
+	if err := eng.Apply(ctx, payload, "selectors"); err != nil {
+		t.Fatalf("Label-only change should update in place: %v", err)
+	}
+}
+
+func TestEngineApply_CustomResources(t *testing.T) {
+	certGVK := schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+	certGVR := schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(certGVK, meta.RESTScopeNamespace)
+
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{certGVR: "CertificateList"})
+	eng := &Engine{client: fake.NewSimpleClientset(), dynamic: dyn, mapper: mapper, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	cert := dsl.NewCustom("cert-manager.io/v1", "Certificate", "web-tls").
+		Namespace("prod").
+		Spec(map[string]any{"secretName": "web-tls"})
+	payload, _ := dsl.NewGraph().Add(cert).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "custom"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	obj, err := dyn.Resource(certGVR).Namespace("prod").Get(ctx, "web-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected certificate to be created: %v", err)
+	}
+	if name, _, _ := unstructured.NestedString(obj.Object, "spec", "secretName"); name != "web-tls" {
+		t.Errorf("Unexpected spec: %v", obj.Object["spec"])
+	}
+
+	// Update in place, then prune it.
+	cert.Spec(map[string]any{"secretName": "rotated"})
+	payload, _ = dsl.NewGraph().Add(cert).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "custom"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	obj, _ = dyn.Resource(certGVR).Namespace("prod").Get(ctx, "web-tls", metav1.GetOptions{})
+	if name, _, _ := unstructured.NestedString(obj.Object, "spec", "secretName"); name != "rotated" {
+		t.Errorf("Expected updated spec, got %v", obj.Object["spec"])
+	}
+
+	payload, _ = dsl.NewGraph().Build().Serialize()
+	if err := eng.Apply(ctx, payload, "custom"); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if _, err := dyn.Resource(certGVR).Namespace("prod").Get(ctx, "web-tls", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected certificate to be deleted")
+	}
+
+	// Unknown kinds fail instead of being skipped.
+	widget := dsl.NewCustom("example.com/v1", "Widget", "w")
+	payload, _ = dsl.NewGraph().Add(widget).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "custom"); err == nil {
+		t.Errorf("Expected RESTMapping error for unknown kind")
+	}
+	eng.dynamic = nil
+	if err := eng.Apply(ctx, payload, "custom"); err == nil {
+		t.Errorf("Expected error without a dynamic client")
+	}
+}
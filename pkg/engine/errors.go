@@ -0,0 +1,72 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+// ErrCorruptState is returned when the recorded state cannot be decoded.
+// The engine refuses to continue rather than forget what it manages.
+var ErrCorruptState = errors.New("corrupt state")
+
+// ErrDependencyFailed is recorded for nodes skipped because a node they
+// depend on failed.
+var ErrDependencyFailed = errors.New("dependency failed")
+
+// NodeError records the failure of one operation on one object.
+type NodeError struct {
+	// Op is "create", "update", "delete", or "apply" when the failure
+	// happened before the engine could tell whether to create or update.
+	Op        string
+	Kind      string
+	Namespace string
+	Name      string
+	Err       error
+}
+
+// stateError reports state that cannot be decoded. State written by a newer
+// kube-goAT is intact and reported as ast.ErrNewerSchema instead.
+func stateError(stateKey string, err error) error {
+	if errors.Is(err, ast.ErrNewerSchema) {
+		return fmt.Errorf("state %s: %w", stateKey, err)
+	}
+	return fmt.Errorf("state %s: %w: %v", stateKey, ErrCorruptState, err)
+}
+
+func newNodeError(op string, node *ast.Node, err error) *NodeError {
+	return &NodeError{Op: op, Kind: node.ObjectKind(), Namespace: node.Namespace, Name: node.Name, Err: err}
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s %s %s/%s: %v", e.Op, e.Kind, e.Namespace, e.Name, e.Err)
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyError aggregates every node failure of one Apply. Use errors.As to
+// reach it, or to reach an individual NodeError.
+type ApplyError struct {
+	Errors []*NodeError
+}
+
+func (e *ApplyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d operation(s) failed:", len(e.Errors))
+	for _, err := range e.Errors {
+		fmt.Fprintf(&b, "\n  %v", err)
+	}
+	return b.String()
+}
+
+func (e *ApplyError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,202 @@
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestEngineApply_AggregatesFailures(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	applyGraph(t, eng, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)).Add(dsl.NewDeployment("old", "nginx")))
+
+	client.PrependReactor("delete", "deployments", func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("simulated DELETE error")
+	})
+	client.PrependReactor("create", "services", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if action.(ktesting.CreateAction).GetObject().(metav1.Object).GetName() == "new" {
+			return true, nil, errors.New("simulated CREATE error")
+		}
+		return false, nil, nil
+	})
+
+	next := dsl.NewService("new", 80, 8080)
+	payload, _ := dsl.NewGraph().
+		Add(dsl.NewService("api", 81, 8080)).
+		Add(next).
+		Add(dsl.NewDeployment("web", "nginx").AttachedTo(next)).
+		Build().Serialize()
+	err := eng.Apply(ctx, payload, "env")
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected an *ApplyError, got %v", err)
+	}
+	got := make(map[string]string)
+	for _, nodeErr := range applyErr.Errors {
+		got[nodeErr.Name] = nodeErr.Op
+	}
+	want := map[string]string{"old": "delete", "new": "create", "web": "apply"}
+	if len(got) != len(want) {
+		t.Fatalf("expected failures %v, got %v", want, err)
+	}
+	for name, op := range want {
+		if got[name] != op {
+			t.Errorf("%s: expected op %s, got %s", name, op, got[name])
+		}
+	}
+	if !errors.Is(err, ErrDependencyFailed) {
+		t.Error("expected the skipped dependent to wrap ErrDependencyFailed")
+	}
+	var nodeErr *NodeError
+	if !errors.As(err, &nodeErr) || nodeErr.Namespace != "default" {
+		t.Errorf("expected NodeErrors to carry the namespace, got %+v", nodeErr)
+	}
+
+	data, _ := store.Load(ctx, "env")
+	recorded, _ := ast.Deserialize(data)
+	if len(recorded.Nodes) != 4 || recorded.Nodes["api"] == nil || recorded.Nodes["old"] == nil {
+		t.Fatalf("expected state to hold api, the undeleted old and the failed creates, got %v", recorded.Nodes)
+	}
+	if ports := recorded.Nodes["api"].Spec.(*ast.ServiceSpec).Ports; ports[0].Port != 81 {
+		t.Error("expected the updated api to be recorded")
+	}
+	oldID := recorded.Nodes["old"].Identity()
+	if status := recorded.Status[oldID]; status.Op != "delete" || status.Error == "" {
+		t.Errorf("expected the failed delete to be recorded, got %+v", recorded.Status)
+	}
+	for _, name := range []string{"new", "web"} {
+		if status := recorded.Status[recorded.Nodes[name].Identity()]; status.Op != "create" {
+			t.Errorf("%s: expected a failed create to be recorded for retries, got %+v", name, status)
+		}
+	}
+	if len(recorded.Status) != 3 {
+		t.Errorf("expected 3 recorded failures, got %+v", recorded.Status)
+	}
+}
+
+func TestEngineApply_DeleteNotFound(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	ctx := context.Background()
+
+	applyGraph(t, eng, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	// Someone already removed it by hand.
+	client.CoreV1().Services("default").Delete(ctx, "api", metav1.DeleteOptions{})
+
+	if err := eng.Apply(ctx, mustSerialize(t, dsl.NewGraph()), "env"); err != nil {
+		t.Errorf("expected deleting a missing object to succeed, got %v", err)
+	}
+}
+
+func TestEngineApply_CorruptState(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+	store.Save(ctx, "env", []byte("garbage"))
+
+	err := eng.Apply(ctx, mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080))), "env")
+	if !errors.Is(err, ErrCorruptState) {
+		t.Fatalf("expected ErrCorruptState, got %v", err)
+	}
+	if data, _ := store.Load(ctx, "env"); string(data) != "garbage" {
+		t.Error("corrupt state must not be overwritten")
+	}
+	if _, err := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{}); err == nil {
+		t.Error("nothing should be applied on top of corrupt state")
+	}
+	if _, err := eng.Plan(ctx, mustSerialize(t, dsl.NewGraph()), "env"); !errors.Is(err, ErrCorruptState) {
+		t.Errorf("expected Plan to report ErrCorruptState, got %v", err)
+	}
+}
+
+// unreadable fails every Load the way an unreachable backend would.
+type unreadable struct{ state.Store }
+
+func (unreadable) Load(context.Context, string) ([]byte, error) {
+	return nil, errors.New("simulated network error")
+}
+
+func TestEngineApply_UnreadableState(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	local := newLocalStore(t)
+	eng := &Engine{client: client, store: unreadable{local}}
+	ctx := context.Background()
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+
+	err := eng.Apply(ctx, payload, "env")
+	if err == nil || errors.Is(err, state.ErrNotFound) {
+		t.Fatalf("expected the load error to abort Apply, got %v", err)
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected nothing to be sent to the cluster, got %v", client.Actions())
+	}
+	if exists, _ := local.Exists(ctx, "env"); exists {
+		t.Error("state must not be written when it could not be read")
+	}
+	if _, err := eng.Plan(ctx, payload, "env"); err == nil {
+		t.Error("expected Plan to report the load error")
+	}
+	if _, err := eng.Import(ctx, payload, "env"); err == nil {
+		t.Error("expected Import to report the load error")
+	}
+}
+
+func TestEngineApply_NewerSchemaState(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+	newer := binary.AppendUvarint([]byte("GOAT"), ast.SchemaVersion+1)
+	store.Save(ctx, "env", newer)
+
+	err := eng.Apply(ctx, mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080))), "env")
+	if !errors.Is(err, ast.ErrNewerSchema) || errors.Is(err, ErrCorruptState) {
+		t.Fatalf("expected ast.ErrNewerSchema rather than corruption, got %v", err)
+	}
+	if data, _ := store.Load(ctx, "env"); string(data) != string(newer) {
+		t.Error("state written by a newer version must not be overwritten")
+	}
+}
+
+func TestEngineApply_WrongPropertyType(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	dag := &ast.DAG{Nodes: map[string]*ast.Node{
+		"web": {Kind: "Deployment", Name: "web", Namespace: "default", Spec: &ast.RawSpec{"image": 42}},
+	}}
+	payload, err := dag.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Apply(context.Background(), payload, "env"); err == nil {
+		t.Fatal("expected a mistyped property to fail Apply")
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected nothing to be sent to the cluster, got %v", client.Actions())
+	}
+}
+
+func mustSerialize(t *testing.T, g *dsl.GraphBuilder) []byte {
+	t.Helper()
+	payload, err := g.Build().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
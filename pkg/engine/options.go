@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
+)
+
+// Option customizes how an Engine reconciles the graph.
+type Option func(*Engine)
+
+// WithRecreateOnSelectorChange lets the engine delete and recreate a
+// Deployment whose immutable spec.selector differs from the compiled graph.
+// Without it Apply refuses the change with ErrSelectorChanged.
+func WithRecreateOnSelectorChange() Option {
+	return func(e *Engine) {
+		e.recreateOnSelectorChange = true
+	}
+}
+
+// WithDeleteTimeout bounds how long Destroy waits for each object's
+// finalizers to clear. It defaults to DefaultDeleteTimeout.
+func WithDeleteTimeout(d time.Duration) Option {
+	return func(e *Engine) {
+		e.deleteTimeout = d
+	}
+}
+
+// WithAdoption lets Apply take over existing objects that no kube-goAT
+// stack owns, such as ones created by hand or by Helm. Without it Apply
+// refuses to modify them with ErrNotOwned. Objects owned by another stack
+// are refused either way.
+func WithAdoption() Option {
+	return func(e *Engine) {
+		e.adopt = true
+	}
+}
+
+// WithPrune makes a clean Apply delete objects of the given kinds that
+// carry the stack's ownership label but are neither in the graph nor in
+// state, such as objects leaked when state was lost. Only listed kinds are
+// ever pruned: "Service", "Deployment", or Kind.group for custom resources.
+// Plan and PruneCandidates list what would be pruned.
+func WithPrune(kinds ...string) Option {
+	return func(e *Engine) {
+		e.pruneKinds = kinds
+	}
+}
+
+// WithTrust makes Apply and Import refuse payloads that are unsigned or not
+// signed by a key v trusts, with signing.ErrUnsigned, signing.ErrUntrusted
+// or signing.ErrBadSignature. The signer of the payload last applied and
+// the signed payload itself are recorded in state as DAG.Signer and
+// DAG.SignedPayload, so recorded state can be re-applied once its signature
+// is verified again.
+func WithTrust(v signing.Verifier) Option {
+	return func(e *Engine) {
+		e.trust = v
+	}
+}
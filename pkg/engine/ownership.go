@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Ownership metadata stamped on every object the engine creates or updates,
+// as defined by package render.
+const (
+	ManagedByLabel        = render.ManagedByLabel
+	ManagedByValue        = render.ManagedByValue
+	StackLabel            = render.StackLabel
+	StackAnnotation       = render.StackAnnotation
+	NodeAnnotation        = render.NodeAnnotation
+	PayloadHashAnnotation = render.PayloadHashAnnotation
+	NodeHashAnnotation    = render.NodeHashAnnotation
+	ProtectedAnnotation   = render.ProtectedAnnotation
+)
+
+// ErrNotOwned is returned when the engine would modify an existing object
+// that another stack or tool manages. Enable WithAdoption, or run Import,
+// to take it over.
+var ErrNotOwned = errors.New("object is not owned by this stack")
+
+// owner describes the stack applying a node.
+type owner struct {
+	stateKey string
+	node     string
+	hash     string
+	nodeHash string
+	protect  bool
+	// recorded is set when state already tracks the node, which covers
+	// objects created before ownership metadata existed.
+	recorded bool
+	// unchanged is set when state records the node with the same hash and
+	// no failure.
+	unchanged bool
+}
+
+// StackLabelValue returns the StackLabel value for a state key: the key
+// itself when it is a valid label value, otherwise a digest of it.
+func StackLabelValue(stateKey string) string {
+	return render.StackLabelValue(stateKey)
+}
+
+func payloadHash(payload []byte) string {
+	return render.PayloadHash(payload)
+}
+
+// stamp sets the ownership label and annotations on obj.
+func (o owner) stamp(obj metav1.Object) {
+	render.Ownership{
+		StateKey:    o.stateKey,
+		Node:        o.node,
+		PayloadHash: o.hash,
+		NodeHash:    o.nodeHash,
+		Protected:   o.protect,
+	}.Stamp(obj)
+}
+
+// upToDate reports whether writing node to the existing object can be
+// skipped: state records the same node, the object was last written from
+// it by this stack, and none of the fields kube-goAT sets drifted.
+func (o owner) upToDate(node *ast.Node, existing runtime.Object) bool {
+	if !o.unchanged {
+		return false
+	}
+	meta, ok := existing.(metav1.Object)
+	if !ok {
+		return false
+	}
+	annotations := meta.GetAnnotations()
+	if annotations[NodeHashAnnotation] != o.nodeHash || annotations[NodeAnnotation] != o.node ||
+		annotations[StackAnnotation] != o.stateKey || meta.GetLabels()[StackLabel] != StackLabelValue(o.stateKey) {
+		return false
+	}
+	desired, err := render.Object(node)
+	if err != nil {
+		return false
+	}
+	live, err := runtime.DefaultUnstructuredConverter.ToUnstructured(existing)
+	if err != nil {
+		return false
+	}
+	return len(diffOwned("", desired.Object, live)) == 0
+}
+
+// checkOwner refuses to modify an existing object the stack does not own,
+// unless adoption is enabled. Objects owned by another stack are refused
+// either way.
+func (e *Engine) checkOwner(existing metav1.Object, o owner) error {
+	switch stack := existing.GetAnnotations()[StackAnnotation]; {
+	case stack == o.stateKey, stack == "" && o.recorded:
+		return nil
+	case stack != "":
+		return fmt.Errorf("%w: managed by stack %q", ErrNotOwned, stack)
+	case e.adopt:
+		log.Printf("[Engine] Adopting existing %s/%s into %s", existing.GetNamespace(), existing.GetName(), o.stateKey)
+		return nil
+	default:
+		return fmt.Errorf("%w: it was not created by kube-goAT; enable WithAdoption or import it first", ErrNotOwned)
+	}
+}
+
+// Import takes over the existing objects of every node in payload: each
+// object found is stamped with this stack's ownership metadata and
+// recorded in state under stateKey, without changing its spec, so the next
+// Apply may update it. Nodes whose object does not exist are left for
+// Apply to create. Import returns the identities of the imported objects;
+// objects owned by another stack are refused with ErrNotOwned. The store's
+// lock on stateKey is held throughout when the store is a state.Locker.
+func (e *Engine) Import(ctx context.Context, payload []byte, stateKey string) ([]string, error) {
+	ctx, unlock, err := state.Lock(ctx, e.store, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	imported, err := e.importObjects(ctx, payload, stateKey)
+	return imported, unlocked(err, unlock)
+}
+
+func (e *Engine) importObjects(ctx context.Context, payload []byte, stateKey string) ([]string, error) {
+	signed := payload
+	payload, signer, err := e.openPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	dag, err := ast.Deserialize(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize AST: %w", err)
+	}
+	var oldDag *ast.DAG
+	existing, found, err := e.loadState(ctx, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if oldDag, err = ast.Deserialize(existing); err != nil {
+			return nil, stateError(stateKey, err)
+		}
+	}
+	progress := e.newCheckpoint(stateKey, oldDag, stateFormat(existing, payload))
+	progress.signer = signer
+	if signer != "" {
+		progress.signed = signed
+	}
+
+	keys := make([]string, 0, len(dag.Nodes))
+	for key := range dag.Nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var imported []string
+	var failures []*NodeError
+	for _, key := range keys {
+		node := dag.Nodes[key]
+		err := e.claim(ctx, node, owner{stateKey: stateKey, node: key, protect: node.Protected})
+		switch {
+		case apierrors.IsNotFound(err):
+			log.Printf("[Engine] Nothing to import for %s (%s)", node.Name, node.ObjectKind())
+			continue
+		case errors.Is(err, errUnsupportedKind):
+			log.Printf("[WARNING] Unsupported node kind: %s", node.Kind)
+			continue
+		case err != nil:
+			failures = append(failures, newNodeError("import", node, err))
+			continue
+		}
+		progress.applied(key, node)
+		imported = append(imported, node.Identity())
+	}
+	if err := progress.flush(ctx); err != nil {
+		return imported, err
+	}
+	if len(failures) > 0 {
+		return imported, &ApplyError{Errors: failures}
+	}
+	return imported, nil
+}
+
+// claim stamps ownership metadata on a node's live object, leaving the rest
+// of it untouched.
+func (e *Engine) claim(ctx context.Context, node *ast.Node, o owner) error {
+	refuseOthers := func(obj metav1.Object) error {
+		if stack := obj.GetAnnotations()[StackAnnotation]; stack != "" && stack != o.stateKey {
+			return fmt.Errorf("%w: managed by stack %q", ErrNotOwned, stack)
+		}
+		o.stamp(obj)
+		return nil
+	}
+	switch node.Kind {
+	case "Service":
+		svc, err := e.client.CoreV1().Services(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := refuseOthers(svc); err != nil {
+			return err
+		}
+		_, err = e.client.CoreV1().Services(node.Namespace).Update(ctx, svc, metav1.UpdateOptions{})
+		return err
+	case "Deployment":
+		dep, err := e.client.AppsV1().Deployments(node.Namespace).Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := refuseOthers(dep); err != nil {
+			return err
+		}
+		_, err = e.client.AppsV1().Deployments(node.Namespace).Update(ctx, dep, metav1.UpdateOptions{})
+		return err
+	case "Custom":
+		res, err := e.customResource(node)
+		if err != nil {
+			return err
+		}
+		obj, err := res.Get(ctx, node.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := refuseOthers(obj); err != nil {
+			return err
+		}
+		_, err = res.Update(ctx, obj, metav1.UpdateOptions{})
+		return err
+	default:
+		return fmt.Errorf("%w %q", errUnsupportedKind, node.Kind)
+	}
+}
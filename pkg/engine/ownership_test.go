@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/compiler"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func helmDeployment(annotations map[string]string) *appsv1.Deployment {
+	replicas := int32(5)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"kube-goat.io/deployment": "web"}},
+		},
+	}
+}
+
+func TestEngineApply_StampsOwnership(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx")).Build().Serialize()
+	if err := eng.Apply(context.Background(), payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	dep, _ := client.AppsV1().Deployments("default").Get(context.Background(), "web", metav1.GetOptions{})
+	if dep.Labels[ManagedByLabel] != ManagedByValue {
+		t.Errorf("expected %s=%s, got %v", ManagedByLabel, ManagedByValue, dep.Labels)
+	}
+	want := map[string]string{StackAnnotation: "env", NodeAnnotation: "web", PayloadHashAnnotation: payloadHash(payload)}
+	for k, v := range want {
+		if dep.Annotations[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, dep.Annotations[k])
+		}
+	}
+}
+
+func TestEngineApply_RefusesUnownedObjects(t *testing.T) {
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Replicas(2)).Build().Serialize()
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		opts        []Option
+		wantErr     bool
+	}{
+		{"unowned", nil, nil, true},
+		{"unowned adopted", nil, []Option{WithAdoption()}, false},
+		{"other stack", map[string]string{StackAnnotation: "team-b"}, []Option{WithAdoption()}, true},
+		{"same stack", map[string]string{StackAnnotation: "env"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(helmDeployment(tt.annotations))
+			eng := NewEngineForClients(client, nil, nil, newLocalStore(t), tt.opts...)
+
+			err := eng.Apply(ctx, payload, "env")
+			dep, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+			if tt.wantErr {
+				if !errors.Is(err, ErrNotOwned) {
+					t.Fatalf("expected ErrNotOwned, got %v", err)
+				}
+				if *dep.Spec.Replicas != 5 {
+					t.Errorf("refused object was modified: replicas = %d", *dep.Spec.Replicas)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			if *dep.Spec.Replicas != 2 || dep.Annotations[StackAnnotation] != "env" {
+				t.Errorf("expected the object to be taken over, got replicas %d, annotations %v", *dep.Spec.Replicas, dep.Annotations)
+			}
+		})
+	}
+}
+
+func TestEngineApply_KeepsManagingRecordedObjects(t *testing.T) {
+	// Objects created before ownership metadata existed are only known
+	// from state.
+	client := fake.NewSimpleClientset(helmDeployment(nil))
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+	recorded, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx")).Build().Serialize()
+	store.Save(ctx, "env", recorded)
+
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Replicas(2)).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+}
+
+func TestEngine_RefusesToDeleteRecreatedObjects(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := NewEngineForClients(client, nil, nil, newLocalStore(t))
+	ctx := context.Background()
+	applyGraph(t, eng, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)).Add(dsl.NewDeployment("web", "nginx")))
+
+	// The recorded Service was deleted and another stack created its own.
+	client.CoreV1().Services("default").Delete(ctx, "api", metav1.DeleteOptions{})
+	foreign := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default",
+		Annotations: map[string]string{StackAnnotation: "team-b"}}}
+	client.CoreV1().Services("default").Create(ctx, foreign, metav1.CreateOptions{})
+
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx")))
+	if err := eng.Apply(ctx, payload, "env"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected removing the re-created Service to fail with ErrNotOwned, got %v", err)
+	}
+	if err := eng.Destroy(ctx, "env"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected destroying the re-created Service to fail with ErrNotOwned, got %v", err)
+	}
+	if _, err := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the other stack's Service to be kept, got %v", err)
+	}
+}
+
+func TestEngineDestroy_DeletesTheCheckedObject(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := NewEngineForClients(client, nil, nil, newLocalStore(t))
+	ctx := context.Background()
+	applyGraph(t, eng, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	svc, _ := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{})
+	svc.UID = "uid-1"
+	client.CoreV1().Services("default").Update(ctx, svc, metav1.UpdateOptions{})
+
+	client.ClearActions()
+	if err := eng.Destroy(ctx, "env"); err != nil {
+		t.Fatal(err)
+	}
+	deletes := 0
+	for _, action := range client.Actions() {
+		if del, ok := action.(ktesting.DeleteAction); ok {
+			deletes++
+			pre := del.GetDeleteOptions().Preconditions
+			if pre == nil || pre.UID == nil || *pre.UID != "uid-1" {
+				t.Errorf("expected the delete to be conditional on the checked UID, got %+v", pre)
+			}
+		}
+	}
+	if deletes != 1 {
+		t.Errorf("expected 1 delete, got %d", deletes)
+	}
+}
+
+func TestEngineImport(t *testing.T) {
+	existing := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+	client := fake.NewSimpleClientset(existing)
+	store := newLocalStore(t)
+	eng := &Engine{client: client, store: store}
+	ctx := context.Background()
+
+	payload, _ := dsl.NewGraph().
+		Add(dsl.NewService("api", 80, 8080)).
+		Add(dsl.NewDeployment("web", "nginx")).
+		Build().Serialize()
+	imported, err := eng.Import(ctx, payload, "env")
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(imported) != 1 || imported[0] != "Service/default/api" {
+		t.Errorf("expected only the existing Service to be imported, got %v", imported)
+	}
+	svc, _ := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{})
+	if svc.Annotations[StackAnnotation] != "env" || len(svc.Spec.Ports) != 0 {
+		t.Errorf("expected ownership to be stamped without touching the spec, got %+v", svc)
+	}
+	data, _ := store.Load(ctx, "env")
+	dag, _ := ast.Deserialize(data)
+	if len(dag.Nodes) != 1 || dag.Nodes["api"] == nil {
+		t.Errorf("expected state to record only the imported Service, got %v", dag.Nodes)
+	}
+
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply after Import failed: %v", err)
+	}
+	if _, err := eng.Import(ctx, payload, "other"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected importing another stack's objects to fail with ErrNotOwned, got %v", err)
+	}
+}
+
+func TestEngineApply_SkipsUnchangedNodes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	eng := &Engine{client: client, store: newLocalStore(t)}
+	ctx := context.Background()
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Replicas(2)).Build().Serialize()
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	writes := func() []string {
+		var verbs []string
+		for _, action := range client.Actions() {
+			if action.GetResource().Resource == "deployments" && action.GetVerb() != "get" {
+				verbs = append(verbs, action.GetVerb())
+			}
+		}
+		client.ClearActions()
+		return verbs
+	}
+	writes()
+
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got := writes(); len(got) != 0 {
+		t.Errorf("expected an unchanged node to be skipped, got %v", got)
+	}
+
+	// Drift in an owned field is still corrected.
+	dep, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	replicas := int32(1)
+	dep.Spec.Replicas = &replicas
+	client.AppsV1().Deployments("default").Update(ctx, dep, metav1.UpdateOptions{})
+	writes()
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got := writes(); len(got) != 1 {
+		t.Errorf("expected the drifted Deployment to be updated, got %v", got)
+	}
+
+	// So is an object last written from a different node.
+	dep, _ = client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	dep.Annotations[NodeHashAnnotation] = "stale"
+	client.AppsV1().Deployments("default").Update(ctx, dep, metav1.UpdateOptions{})
+	writes()
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got := writes(); len(got) != 1 {
+		t.Errorf("expected a stale hash annotation to force an update, got %v", got)
+	}
+}
+
+func TestEngineApply_ManagesRenderedManifests(t *testing.T) {
+	dag := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx").Replicas(2)).Build()
+	payload, _ := dag.Serialize()
+	ctx := context.Background()
+
+	// Objects created from rendered manifests, as kubectl apply would.
+	created := func(opts ...compiler.ManifestOption) *fake.Clientset {
+		objs, err := compiler.Objects(dag, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dep appsv1.Deployment
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(objs[0].Object, &dep); err != nil {
+			t.Fatal(err)
+		}
+		return fake.NewSimpleClientset(&dep)
+	}
+
+	eng := &Engine{client: created(), store: newLocalStore(t)}
+	if err := eng.Apply(ctx, payload, "env"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected unstamped manifests to be refused with ErrNotOwned, got %v", err)
+	}
+
+	client := created(compiler.WithStateKey("env"))
+	rendered, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	eng = &Engine{client: client, store: newLocalStore(t)}
+	if err := eng.Apply(ctx, payload, "env"); err != nil {
+		t.Fatalf("expected manifests rendered for the stack to be managed, got %v", err)
+	}
+	dep, _ := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{})
+	if !reflect.DeepEqual(dep.Annotations, rendered.Annotations) || !reflect.DeepEqual(dep.Labels, rendered.Labels) {
+		t.Errorf("expected the rendered ownership to match the engine's, rendered %v, applied %v", rendered.Annotations, dep.Annotations)
+	}
+	if err := eng.Apply(ctx, payload, "other"); !errors.Is(err, ErrNotOwned) {
+		t.Errorf("expected another stack to be refused, got %v", err)
+	}
+}
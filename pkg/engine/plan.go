@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/render"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// Action is what Apply would do to a single object.
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+	ActionNoop   Action = "no-op"
+	// ActionPrune deletes an owned object missing from both the graph and
+	// state; see WithPrune.
+	ActionPrune Action = "prune"
+)
+
+// Change describes the action planned for one object.
+type Change struct {
+	Action    Action
+	Kind      string
+	Namespace string
+	Name      string
+	// LastError is the recorded failure of the previous apply, if any.
+	LastError string
+}
+
+func (c Change) String() string {
+	symbol := map[Action]string{ActionCreate: "+", ActionUpdate: "~", ActionDelete: "-", ActionPrune: "-", ActionNoop: " "}[c.Action]
+	s := fmt.Sprintf("%s %s %s %s/%s", symbol, c.Action, c.Kind, c.Namespace, c.Name)
+	if c.LastError != "" {
+		s += fmt.Sprintf(" (last attempt failed: %s)", c.LastError)
+	}
+	return s
+}
+
+// Plan lists the changes applying a payload would make, sorted by kind,
+// namespace and name.
+type Plan struct {
+	Changes []Change
+}
+
+// HasChanges reports whether applying the plan would touch any object.
+func (p *Plan) HasChanges() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionNoop {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the plan the way the goat CLI prints it.
+func (p *Plan) String() string {
+	var b strings.Builder
+	counts := make(map[Action]int)
+	for _, c := range p.Changes {
+		counts[c.Action]++
+		if c.Action != ActionNoop {
+			fmt.Fprintln(&b, c)
+		}
+	}
+	fmt.Fprintf(&b, "Plan: %d to create, %d to update, %d to delete, ", counts[ActionCreate], counts[ActionUpdate], counts[ActionDelete])
+	if counts[ActionPrune] > 0 {
+		fmt.Fprintf(&b, "%d to prune, ", counts[ActionPrune])
+	}
+	fmt.Fprintf(&b, "%d unchanged.\n", counts[ActionNoop])
+	return b.String()
+}
+
+// Plan compares a payload with the state recorded under stateKey without
+// touching the cluster. Objects absent from state are created, objects
+// whose rendered form changed are updated and objects no longer in the
+// graph are deleted. With WithPrune the cluster is listed, read-only, for
+// objects pruning would delete.
+func (e *Engine) Plan(ctx context.Context, payload []byte, stateKey string) (*Plan, error) {
+	dag, err := ast.Deserialize(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize AST: %w", err)
+	}
+	old := make(map[string]*ast.Node)
+	var status map[string]ast.NodeStatus
+	var oldDag *ast.DAG
+	existingState, found, err := e.loadState(ctx, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		oldDag, err = ast.Deserialize(existingState)
+		if err != nil {
+			return nil, stateError(stateKey, err)
+		}
+		for _, node := range oldDag.Nodes {
+			if !createFailed(oldDag, node) {
+				old[node.Identity()] = node
+			}
+		}
+		status = oldDag.Status
+	}
+
+	plan := &Plan{}
+	desired := make(map[string]bool, len(dag.Nodes))
+	for _, node := range dag.Nodes {
+		desired[node.Identity()] = true
+		action := ActionCreate
+		if prev, ok := old[node.Identity()]; ok {
+			action = ActionNoop
+			// A node whose last operation failed may not match the
+			// cluster, so it is always retried.
+			if _, failed := status[node.Identity()]; failed || !sameObject(prev, node) {
+				action = ActionUpdate
+			}
+		}
+		plan.Changes = append(plan.Changes, change(action, node, status))
+	}
+	for id, node := range old {
+		if !desired[id] {
+			plan.Changes = append(plan.Changes, change(ActionDelete, node, status))
+		}
+	}
+	if len(e.pruneKinds) > 0 {
+		candidates, err := e.pruneCandidates(ctx, dag, oldDag, stateKey)
+		if err != nil {
+			return nil, err
+		}
+		plan.Changes = append(plan.Changes, candidates...)
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		a, b := plan.Changes[i], plan.Changes[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return plan, nil
+}
+
+func change(action Action, node *ast.Node, status map[string]ast.NodeStatus) Change {
+	return Change{
+		Action:    action,
+		Kind:      node.ObjectKind(),
+		Namespace: node.Namespace,
+		Name:      node.Name,
+		LastError: status[node.Identity()].Error,
+	}
+}
+
+// sameObject reports whether two nodes render to the same object; nodes
+// that cannot be rendered are compared by their specs.
+func sameObject(a, b *ast.Node) bool {
+	oa, errA := render.Object(a)
+	ob, errB := render.Object(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a.Spec, b.Spec)
+	}
+	return equality.Semantic.DeepEqual(oa.Object, ob.Object)
+}
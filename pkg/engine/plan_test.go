@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+)
+
+func TestEnginePlan(t *testing.T) {
+	store := newLocalStore(t)
+	eng := &Engine{store: store}
+	ctx := context.Background()
+
+	before, _ := dsl.NewGraph().
+		Add(dsl.NewService("api", 80, 8080)).
+		Add(dsl.NewDeployment("web", "nginx:1.0")).
+		Add(dsl.NewDeployment("old", "nginx:1.0")).
+		Build().Serialize()
+	after, _ := dsl.NewGraph().
+		Add(dsl.NewService("api", 80, 8080)).
+		Add(dsl.NewDeployment("web", "nginx:2.0")).
+		Add(dsl.NewDeployment("new", "nginx:1.0")).
+		Build().Serialize()
+
+	plan, err := eng.Plan(ctx, before, "plan")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(plan.Changes) != 3 || !plan.HasChanges() {
+		t.Fatalf("expected 3 creations without state, got %v", plan.Changes)
+	}
+	for _, c := range plan.Changes {
+		if c.Action != ActionCreate {
+			t.Errorf("expected create, got %s", c)
+		}
+	}
+
+	if err := store.Save(ctx, "plan", before); err != nil {
+		t.Fatal(err)
+	}
+	plan, err = eng.Plan(ctx, after, "plan")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	want := map[string]Action{"api": ActionNoop, "web": ActionUpdate, "new": ActionCreate, "old": ActionDelete}
+	if len(plan.Changes) != len(want) {
+		t.Fatalf("unexpected changes: %v", plan.Changes)
+	}
+	for _, c := range plan.Changes {
+		if want[c.Name] != c.Action {
+			t.Errorf("%s: expected %s, got %s", c.Name, want[c.Name], c.Action)
+		}
+	}
+
+	plan, _ = eng.Plan(ctx, before, "plan")
+	if plan.HasChanges() {
+		t.Errorf("expected no changes against identical state, got %v", plan.Changes)
+	}
+}
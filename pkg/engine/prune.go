@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// pruneKind lists and deletes the objects of one allowlisted kind.
+type pruneKind struct {
+	kind   string
+	list   func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error)
+	delete func(ctx context.Context, namespace, name string) error
+}
+
+// PruneCandidates lists the objects Apply would prune: objects of the kinds
+// enabled with WithPrune that carry this stack's ownership label and are
+// neither in payload nor in the recorded state, in every namespace.
+// Objects of protected nodes are never candidates. It is the dry run of
+// pruning and changes nothing.
+func (e *Engine) PruneCandidates(ctx context.Context, payload []byte, stateKey string) ([]Change, error) {
+	dag, err := ast.Deserialize(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize AST: %w", err)
+	}
+	// Corrupt state is exactly what pruning recovers from, so it only
+	// narrows the candidates when it can be decoded. A store that cannot
+	// be read at all is still an error.
+	data, found, err := e.loadState(ctx, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	var oldDag *ast.DAG
+	if found {
+		oldDag, _ = ast.Deserialize(data)
+	}
+	return e.pruneCandidates(ctx, dag, oldDag, stateKey)
+}
+
+func (e *Engine) pruneCandidates(ctx context.Context, dag, oldDag *ast.DAG, stateKey string) ([]Change, error) {
+	if len(e.pruneKinds) == 0 {
+		return nil, fmt.Errorf("pruning is not enabled; list the kinds to prune with WithPrune")
+	}
+	kinds, err := e.pruneTargets()
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool)
+	for _, node := range dag.Nodes {
+		keep[node.Identity()] = true
+	}
+	if oldDag != nil {
+		// Removed nodes still in state are deleted, or kept when
+		// protected, by Apply's own deletion pass.
+		for _, node := range oldDag.Nodes {
+			keep[node.Identity()] = true
+		}
+	}
+
+	selector := metav1.ListOptions{LabelSelector: StackLabel + "=" + StackLabelValue(stateKey)}
+	var candidates []Change
+	for _, k := range kinds {
+		objs, err := k.list(ctx, selector)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", k.kind, err)
+		}
+		for _, obj := range objs {
+			annotations := obj.GetAnnotations()
+			switch {
+			case annotations[StackAnnotation] != stateKey,
+				annotations[ProtectedAnnotation] == "true",
+				obj.GetDeletionTimestamp() != nil,
+				keep[k.kind+"/"+obj.GetNamespace()+"/"+obj.GetName()]:
+				continue
+			}
+			candidates = append(candidates, Change{Action: ActionPrune, Kind: k.kind, Namespace: obj.GetNamespace(), Name: obj.GetName()})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return candidates, nil
+}
+
+// prune deletes every candidate, returning the failures.
+func (e *Engine) prune(ctx context.Context, dag, oldDag *ast.DAG, stateKey string) ([]*NodeError, error) {
+	candidates, err := e.pruneCandidates(ctx, dag, oldDag, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	kinds, err := e.pruneTargets()
+	if err != nil {
+		return nil, err
+	}
+	byKind := make(map[string]pruneKind, len(kinds))
+	for _, k := range kinds {
+		byKind[k.kind] = k
+	}
+
+	var failures []*NodeError
+	for _, c := range candidates {
+		log.Printf("[Engine] Pruning %s %s/%s", c.Kind, c.Namespace, c.Name)
+		if err := byKind[c.Kind].delete(ctx, c.Namespace, c.Name); err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, &NodeError{Op: "prune", Kind: c.Kind, Namespace: c.Namespace, Name: c.Name, Err: err})
+		}
+	}
+	return failures, nil
+}
+
+// pruneTargets resolves the allowlisted kinds. Service and Deployment are
+// built in; other kinds are given as Kind.group, e.g. Widget.example.com,
+// and resolved through the RESTMapper.
+func (e *Engine) pruneTargets() ([]pruneKind, error) {
+	var kinds []pruneKind
+	for _, kind := range e.pruneKinds {
+		switch kind {
+		case "Service":
+			kinds = append(kinds, pruneKind{
+				kind: kind,
+				list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+					list, err := e.client.CoreV1().Services(metav1.NamespaceAll).List(ctx, opts)
+					if err != nil {
+						return nil, err
+					}
+					objs := make([]metav1.Object, len(list.Items))
+					for i := range list.Items {
+						objs[i] = &list.Items[i]
+					}
+					return objs, nil
+				},
+				delete: func(ctx context.Context, namespace, name string) error {
+					return e.client.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+				},
+			})
+		case "Deployment":
+			kinds = append(kinds, pruneKind{
+				kind: kind,
+				list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+					list, err := e.client.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, opts)
+					if err != nil {
+						return nil, err
+					}
+					objs := make([]metav1.Object, len(list.Items))
+					for i := range list.Items {
+						objs[i] = &list.Items[i]
+					}
+					return objs, nil
+				},
+				delete: func(ctx context.Context, namespace, name string) error {
+					return e.client.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+				},
+			})
+		default:
+			k, err := e.customPruneKind(kind)
+			if err != nil {
+				return nil, err
+			}
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds, nil
+}
+
+func (e *Engine) customPruneKind(kind string) (pruneKind, error) {
+	if e.dynamic == nil || e.mapper == nil {
+		return pruneKind{}, fmt.Errorf("prune %s: engine has no dynamic client", kind)
+	}
+	gk := schema.ParseGroupKind(kind)
+	if gk.Group == "" {
+		return pruneKind{}, fmt.Errorf("prune %s: give custom kinds as Kind.group", kind)
+	}
+	mapping, err := e.mapper.RESTMapping(gk)
+	if err != nil {
+		return pruneKind{}, fmt.Errorf("prune %s: %w", kind, err)
+	}
+	resource := e.dynamic.Resource(mapping.Resource)
+	namespaced := mapping.Scope.Name() != meta.RESTScopeNameRoot
+	return pruneKind{
+		kind: gk.Kind,
+		list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+			list, err := resource.List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			objs := make([]metav1.Object, len(list.Items))
+			for i := range list.Items {
+				objs[i] = &list.Items[i]
+			}
+			return objs, nil
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			if namespaced {
+				return resource.Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+			}
+			return resource.Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	}, nil
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// leakedStack applies a graph under "env" and then loses its state, so
+// the Service "api" and the protected Service "db" are only findable by
+// their labels. The Service "other" belongs to the stack "team-b".
+func leakedStack(t *testing.T) (*fake.Clientset, *state.LocalStore) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	applyGraph(t, &Engine{client: client, store: store}, dsl.NewGraph().
+		Add(dsl.NewService("api", 80, 8080)).
+		Add(dsl.NewService("db", 5432, 5432).Protect()).
+		Add(dsl.NewDeployment("web", "nginx")))
+	other, _ := dsl.NewGraph().Add(dsl.NewService("other", 80, 8080)).Build().Serialize()
+	if err := (&Engine{client: client, store: store}).Apply(context.Background(), other, "team-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(context.Background(), "env"); err != nil {
+		t.Fatal(err)
+	}
+	return client, store
+}
+
+func TestEngineApply_Prune(t *testing.T) {
+	ctx := context.Background()
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx")).Build().Serialize()
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantPruned bool
+	}{
+		{"disabled", nil, false},
+		{"kind not allowlisted", []Option{WithPrune("Deployment")}, false},
+		{"enabled", []Option{WithPrune("Service", "Deployment")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, store := leakedStack(t)
+			eng := NewEngineForClients(client, nil, nil, store, tt.opts...)
+			if err := eng.Apply(ctx, payload, "env"); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			_, err := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{})
+			if pruned := apierrors.IsNotFound(err); pruned != tt.wantPruned {
+				t.Errorf("expected api pruned=%v, got get error %v", tt.wantPruned, err)
+			}
+			for _, name := range []string{"db", "other"} {
+				if _, err := client.CoreV1().Services("default").Get(ctx, name, metav1.GetOptions{}); err != nil {
+					t.Errorf("%s must never be pruned: %v", name, err)
+				}
+			}
+			if _, err := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{}); err != nil {
+				t.Errorf("Deployment in the graph was pruned: %v", err)
+			}
+		})
+	}
+}
+
+func TestEnginePruneCandidates_DryRun(t *testing.T) {
+	client, store := leakedStack(t)
+	eng := NewEngineForClients(client, nil, nil, store, WithPrune("Service", "Deployment"))
+	ctx := context.Background()
+	payload, _ := dsl.NewGraph().Add(dsl.NewDeployment("web", "nginx")).Build().Serialize()
+
+	candidates, err := eng.PruneCandidates(ctx, payload, "env")
+	if err != nil {
+		t.Fatalf("PruneCandidates failed: %v", err)
+	}
+	if len(candidates) != 1 || candidates[0].String() != "- prune Service default/api" {
+		t.Errorf("expected only Service default/api, got %v", candidates)
+	}
+	if _, err := client.CoreV1().Services("default").Get(ctx, "api", metav1.GetOptions{}); err != nil {
+		t.Errorf("dry run deleted the object: %v", err)
+	}
+
+	plan, err := eng.Plan(ctx, payload, "env")
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if out := plan.String(); !strings.Contains(out, "- prune Service default/api") || !strings.Contains(out, "1 to prune") {
+		t.Errorf("expected the plan to list the prune, got:\n%s", out)
+	}
+
+	if _, err := (&Engine{client: client, store: store}).PruneCandidates(ctx, payload, "env"); err == nil {
+		t.Error("expected PruneCandidates to fail without WithPrune")
+	}
+}
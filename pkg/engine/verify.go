@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
+)
+
+// openPayload unwraps a signed payload. With a trust set configured the
+// signature must verify and the signer's identity is returned; without
+// one, signed and unsigned payloads are accepted alike. Unsigned payloads
+// are returned along with the signing.ErrUnsigned refusal.
+func (e *Engine) openPayload(payload []byte) ([]byte, string, error) {
+	if e.trust != nil {
+		inner, signer, err := signing.Verify(payload, e.trust)
+		if errors.Is(err, signing.ErrUnsigned) {
+			return payload, "", fmt.Errorf("refusing payload: %w", err)
+		} else if err != nil {
+			return nil, "", fmt.Errorf("refusing payload: %w", err)
+		}
+		return inner, signer, nil
+	}
+	env, err := ast.OpenEnvelope(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to deserialize AST: %w", err)
+	}
+	if env != nil {
+		return env.Payload, "", nil
+	}
+	return payload, "", nil
+}
+
+// verifiedState returns the signer and the signed payload recorded in
+// oldDag when that payload still verifies against the trust set and
+// describes the same graph as dag, as when recorded state is re-applied to
+// correct drift. The recorded Signer is not trusted: anyone able to write
+// the store could forge it.
+func (e *Engine) verifiedState(dag, oldDag *ast.DAG) (string, []byte, bool) {
+	if oldDag == nil || len(oldDag.SignedPayload) == 0 {
+		return "", nil, false
+	}
+	inner, signer, err := signing.Verify(oldDag.SignedPayload, e.trust)
+	if err != nil {
+		return "", nil, false
+	}
+	signed, err := ast.Deserialize(inner)
+	if err != nil || signed.Hash() != dag.Hash() {
+		return "", nil, false
+	}
+	return signer, oldDag.SignedPayload, true
+}
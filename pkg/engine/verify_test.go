@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+	"github.com/arpanpathak/kube-goAT/pkg/dsl"
+	"github.com/arpanpathak/kube-goAT/pkg/signing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEngineApply_VerifiesSignatures(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	client := fake.NewSimpleClientset()
+	store := newLocalStore(t)
+	eng := NewEngineForClients(client, nil, nil, store, WithTrust(signing.TrustSet{"ci": public}))
+	ctx := context.Background()
+
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	untrusted, _ := signing.Sign(payload, signing.NewEd25519Signer("laptop", otherKey))
+	forged, _ := signing.Sign(payload, signing.NewEd25519Signer("ci", otherKey))
+	for name, tc := range map[string]struct {
+		payload []byte
+		want    error
+	}{
+		"unsigned":  {payload, signing.ErrUnsigned},
+		"untrusted": {untrusted, signing.ErrUntrusted},
+		"forged":    {forged, signing.ErrBadSignature},
+	} {
+		if err := eng.Apply(ctx, tc.payload, "env"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+		if _, err := eng.Import(ctx, tc.payload, "env"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected Import to fail with %v, got %v", name, tc.want, err)
+		}
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("expected nothing to be sent to the cluster, got %v", client.Actions())
+	}
+
+	signed, _ := signing.Sign(payload, signing.NewEd25519Signer("ci", private))
+	if err := eng.Apply(ctx, signed, "env"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := store.Load(ctx, "env")
+	recorded, err := ast.Deserialize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded.Signer != "ci" {
+		t.Errorf("expected the signer to be recorded in state, got %q", recorded.Signer)
+	}
+
+	// Recorded state is re-applied unsigned to correct drift.
+	if err := eng.Apply(ctx, data, "env"); err != nil {
+		t.Errorf("expected recorded state to be re-applied, got %v", err)
+	}
+	changed := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 81, 8080)))
+	if err := eng.Apply(ctx, changed, "env"); !errors.Is(err, signing.ErrUnsigned) {
+		t.Errorf("expected an unsigned change to be refused, got %v", err)
+	}
+}
+
+func TestEngineApply_SignedWithoutTrust(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(nil)
+	store := newLocalStore(t)
+	eng := &Engine{client: fake.NewSimpleClientset(), store: store}
+	ctx := context.Background()
+
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	signed, _ := signing.Sign(payload, signing.NewEd25519Signer("ci", private))
+	if err := eng.Apply(ctx, signed, "env"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := store.Load(ctx, "env")
+	if format, _ := ast.PayloadFormat(data); format != ast.FormatGob {
+		t.Errorf("expected state to hold the unwrapped payload, got format %q", format)
+	}
+	if recorded, _ := ast.Deserialize(data); recorded.Signer != "" {
+		t.Errorf("expected no signer to be recorded without verification, got %q", recorded.Signer)
+	}
+}
+
+func TestEngineApply_ReverifiesRecordedState(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	store := newLocalStore(t)
+	eng := NewEngineForClients(fake.NewSimpleClientset(), nil, nil, store, WithTrust(signing.TrustSet{"ci": public}))
+	ctx := context.Background()
+
+	payload := mustSerialize(t, dsl.NewGraph().Add(dsl.NewService("api", 80, 8080)))
+	signed, _ := signing.Sign(payload, signing.NewEd25519Signer("ci", private))
+	if err := eng.Apply(ctx, signed, "env"); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := store.Load(ctx, "env")
+	recorded, _ := ast.Deserialize(data)
+	if !bytes.Equal(recorded.SignedPayload, signed) {
+		t.Fatal("expected the signed payload to be recorded in state")
+	}
+
+	// Anyone able to write the store could claim a signer for another graph.
+	forged := dsl.NewGraph().Add(dsl.NewService("api", 81, 8080)).Build()
+	forged.Signer = "ci"
+	forgedState, _ := forged.Serialize()
+	store.Save(ctx, "forged", forgedState)
+	if err := eng.Apply(ctx, forgedState, "forged"); !errors.Is(err, signing.ErrUnsigned) {
+		t.Errorf("expected a forged signer in state to be refused, got %v", err)
+	}
+	forged.SignedPayload = signed
+	forgedState, _ = forged.Serialize()
+	store.Save(ctx, "forged", forgedState)
+	if err := eng.Apply(ctx, forgedState, "forged"); !errors.Is(err, signing.ErrUnsigned) {
+		t.Errorf("expected state signed for another graph to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package render
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Ownership metadata stamped on every object kube-goAT manages, whether
+// the engine applies it or it is rendered for another tool to apply.
+const (
+	// ManagedByLabel is the well-known label naming the managing tool; its
+	// value is ManagedByValue.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "kube-goat"
+	// StackLabel selects the objects of one stack; its value is
+	// StackLabelValue of the state key.
+	StackLabel = "kube-goat.io/stack"
+
+	// StackAnnotation holds the state key of the graph owning the object.
+	StackAnnotation = "kube-goat.io/stack"
+	// NodeAnnotation holds the key of the node within that graph.
+	NodeAnnotation = "kube-goat.io/node"
+	// PayloadHashAnnotation holds the SHA-256 of the payload last applied.
+	PayloadHashAnnotation = "kube-goat.io/payload-hash"
+	// NodeHashAnnotation holds ast.Node.Hash of the node last written to
+	// the object.
+	NodeHashAnnotation = "kube-goat.io/node-hash"
+	// ProtectedAnnotation marks objects of protected nodes, which pruning
+	// never deletes.
+	ProtectedAnnotation = "kube-goat.io/protected"
+)
+
+// Ownership names the stack and node an object belongs to.
+type Ownership struct {
+	// StateKey is the key the stack's state is recorded under.
+	StateKey string
+	// Node is the key of the node within the stack's graph.
+	Node string
+	// PayloadHash and NodeHash, when set, record what the object was last
+	// written from.
+	PayloadHash string
+	NodeHash    string
+	Protected   bool
+}
+
+// PayloadHash returns the PayloadHashAnnotation value for a payload.
+func PayloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// StackLabelValue returns the StackLabel value for a state key: the key
+// itself when it is a valid label value, otherwise a digest of it.
+func StackLabelValue(stateKey string) string {
+	if len(validation.IsValidLabelValue(stateKey)) == 0 {
+		return stateKey
+	}
+	sum := sha256.Sum256([]byte(stateKey))
+	return "sha256-" + hex.EncodeToString(sum[:])[:32]
+}
+
+// Stamp sets the ownership labels and annotations on obj, keeping the
+// others it carries.
+func (o Ownership) Stamp(obj metav1.Object) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[ManagedByLabel] = ManagedByValue
+	labels[StackLabel] = StackLabelValue(o.StateKey)
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[StackAnnotation] = o.StateKey
+	annotations[NodeAnnotation] = o.Node
+	if o.PayloadHash != "" {
+		annotations[PayloadHashAnnotation] = o.PayloadHash
+	}
+	if o.NodeHash != "" {
+		annotations[NodeHashAnnotation] = o.NodeHash
+	}
+	if o.Protected {
+		annotations[ProtectedAnnotation] = "true"
+	} else {
+		delete(annotations, ProtectedAnnotation)
+	}
+	obj.SetAnnotations(annotations)
+}
@@ -0,0 +1,223 @@
+// Package render turns AST nodes into the Kubernetes objects the engine
+// sends to the API server. Every consumer that needs the concrete object
+// (the engine, importers, manifest output) goes through here so they never
+// diverge.
+package render
+
+import (
+	"fmt"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Service renders a Service node.
+func Service(node *ast.Node) (*corev1.Service, error) {
+	spec, err := ast.SpecOf[*ast.ServiceSpec](node)
+	if err != nil {
+		return nil, err
+	}
+	labels := copyLabels(spec.Labels)
+	selector := spec.Selector
+	if selector == nil {
+		// Payloads compiled before selectors were split route on labels.
+		selector = copyLabels(spec.Labels)
+	}
+
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceType(spec.Type),
+			Ports: servicePorts(spec.Ports),
+		},
+	}
+	if spec.Type == string(corev1.ServiceTypeExternalName) {
+		svc.Spec.ExternalName = spec.ExternalName
+	} else {
+		svc.Spec.Selector = selector
+		svc.Spec.ClusterIP = spec.ClusterIP
+	}
+	if spec.SessionAffinity != "" {
+		svc.Spec.SessionAffinity = corev1.ServiceAffinity(spec.SessionAffinity)
+		if timeout := spec.SessionAffinityTimeout; timeout != 0 {
+			svc.Spec.SessionAffinityConfig = &corev1.SessionAffinityConfig{
+				ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: &timeout},
+			}
+		}
+	}
+	return svc, nil
+}
+
+// servicePorts converts the AST port list.
+func servicePorts(ports []ast.ServicePort) []corev1.ServicePort {
+	if len(ports) == 0 {
+		return nil
+	}
+	out := make([]corev1.ServicePort, 0, len(ports))
+	for _, p := range ports {
+		sp := corev1.ServicePort{
+			Name:     p.Name,
+			Protocol: corev1.Protocol(p.Protocol),
+			Port:     p.Port,
+			NodePort: p.NodePort,
+		}
+		if sp.Protocol == "" {
+			sp.Protocol = corev1.ProtocolTCP
+		}
+		switch {
+		case p.TargetPortName != "":
+			sp.TargetPort = intstr.FromString(p.TargetPortName)
+		case p.TargetPort != 0:
+			sp.TargetPort = intstr.FromInt32(p.TargetPort)
+		default:
+			sp.TargetPort = intstr.FromInt32(p.Port)
+		}
+		out = append(out, sp)
+	}
+	return out
+}
+
+// Deployment renders a Deployment node.
+func Deployment(node *ast.Node) (*appsv1.Deployment, error) {
+	spec, err := ast.SpecOf[*ast.DeploymentSpec](node)
+	if err != nil {
+		return nil, err
+	}
+	replicas := spec.Replicas
+
+	// Payloads compiled before selectors were split select on labels.
+	labels := copyLabels(spec.Labels)
+	selector, podLabels := copyLabels(spec.Labels), copyLabels(spec.Labels)
+	if spec.Selector != nil {
+		selector = spec.Selector
+	}
+	if spec.PodLabels != nil {
+		podLabels = spec.PodLabels
+	}
+
+	var ports []corev1.ContainerPort
+	for _, p := range spec.Ports {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          p.Name,
+			ContainerPort: p.Port,
+			Protocol:      corev1.Protocol(p.Protocol),
+		})
+	}
+
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      node.Name,
+			Namespace: node.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "app", Image: spec.Image, Ports: ports},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// Custom renders a Custom node as an unstructured object.
+func Custom(node *ast.Node) (*unstructured.Unstructured, error) {
+	spec, err := ast.SpecOf[*ast.CustomSpec](node)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Error != "" {
+		return nil, fmt.Errorf("custom resource %s: %s", node.Name, spec.Error)
+	}
+	obj := &unstructured.Unstructured{Object: make(map[string]any)}
+	if spec.Content != nil {
+		obj.Object = runtime.DeepCopyJSON(spec.Content)
+	}
+	obj.SetAPIVersion(spec.APIVersion)
+	obj.SetKind(spec.Kind)
+	obj.SetName(node.Name)
+	obj.SetNamespace(node.Namespace)
+	if len(spec.Labels) > 0 {
+		obj.SetLabels(copyLabels(spec.Labels))
+	}
+	if len(spec.Annotations) > 0 {
+		obj.SetAnnotations(copyLabels(spec.Annotations))
+	}
+	return obj, nil
+}
+
+// copyLabels returns a copy of a label map, or an empty map.
+func copyLabels(l map[string]string) map[string]string {
+	labels := make(map[string]string, len(l))
+	for k, v := range l {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Object renders any supported node as an unstructured object, exactly as
+// the engine would send it before stamping its Ownership. Typed objects
+// are stripped of the null and empty fields client-go structs always
+// carry, so the output reads like a hand-written manifest.
+func Object(node *ast.Node) (*unstructured.Unstructured, error) {
+	var typed runtime.Object
+	var err error
+	switch node.Kind {
+	case "Service":
+		typed, err = Service(node)
+	case "Deployment":
+		typed, err = Deployment(node)
+	case "Custom":
+		return Custom(node)
+	default:
+		return nil, fmt.Errorf("node %s: unsupported kind %q", node.Name, node.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "status")
+	prune(content)
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+// prune removes nil values and empty maps recursively.
+func prune(m map[string]any) {
+	for k, v := range m {
+		switch t := v.(type) {
+		case nil:
+			delete(m, k)
+		case map[string]any:
+			prune(t)
+			if len(t) == 0 {
+				delete(m, k)
+			}
+		case []any:
+			for _, e := range t {
+				if em, ok := e.(map[string]any); ok {
+					prune(em)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+// AlgorithmEd25519 names ed25519 signatures in envelopes.
+const AlgorithmEd25519 = "ed25519"
+
+// Ed25519Signer signs payloads with an ed25519 private key.
+type Ed25519Signer struct {
+	identity string
+	key      ed25519.PrivateKey
+}
+
+func NewEd25519Signer(identity string, key ed25519.PrivateKey) *Ed25519Signer {
+	return &Ed25519Signer{identity: identity, key: key}
+}
+
+// LoadEd25519Signer reads a PEM-encoded PKCS #8 ed25519 private key, as
+// written by `openssl genpkey -algorithm ed25519`. The signer's identity is
+// the file name without its extension, so ci.pem signs as "ci".
+func LoadEd25519Signer(path string) (*Ed25519Signer, error) {
+	key, err := readPEM(path, "PRIVATE KEY", x509.ParsePKCS8PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: %T is not an ed25519 private key", path, key)
+	}
+	return NewEd25519Signer(keyIdentity(path), private), nil
+}
+
+func (s *Ed25519Signer) Identity() string  { return s.identity }
+func (s *Ed25519Signer) Algorithm() string { return AlgorithmEd25519 }
+
+func (s *Ed25519Signer) Sign(payload []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, payload), nil
+}
+
+// TrustSet verifies ed25519 signatures against public keys by signer
+// identity.
+type TrustSet map[string]ed25519.PublicKey
+
+// LoadTrustSet reads PEM-encoded PKIX ed25519 public keys, as written by
+// `openssl pkey -pubout`. Each path is a key file or a directory whose
+// *.pub files are all read. A key's identity is its file name without the
+// extension, so ci.pub verifies payloads signed as "ci".
+func LoadTrustSet(paths ...string) (TrustSet, error) {
+	trust := TrustSet{}
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.pub")); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			key, err := readPEM(file, "PUBLIC KEY", x509.ParsePKIXPublicKey)
+			if err != nil {
+				return nil, err
+			}
+			public, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: %T is not an ed25519 public key", file, key)
+			}
+			id := keyIdentity(file)
+			if _, dup := trust[id]; dup {
+				return nil, fmt.Errorf("%s: a key for %q is already trusted", file, id)
+			}
+			trust[id] = public
+		}
+	}
+	if len(trust) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", strings.Join(paths, ", "))
+	}
+	return trust, nil
+}
+
+func (t TrustSet) Verify(env *ast.Envelope) error {
+	key, ok := t[env.Signer]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUntrusted, env.Signer)
+	}
+	if env.Algorithm != AlgorithmEd25519 {
+		return fmt.Errorf("%w: %q signed with %q, expected %s", ErrBadSignature, env.Signer, env.Algorithm, AlgorithmEd25519)
+	}
+	if !ed25519.Verify(key, env.Payload, env.Signature) {
+		return fmt.Errorf("%w: signed as %q", ErrBadSignature, env.Signer)
+	}
+	return nil
+}
+
+func readPEM(path, blockType string, parse func([]byte) (any, error)) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no PEM %q block", path, blockType)
+	}
+	key, err := parse(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func keyIdentity(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
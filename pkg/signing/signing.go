@@ -0,0 +1,72 @@
+// Package signing signs compiled payloads and verifies them before they are
+// applied, so a payload tampered with between the job that compiled it and
+// the one applying it is refused.
+//
+// A signed payload is an ast.Envelope: the compiled payload together with
+// the signature over its exact bytes and the identity of the key that made
+// it. ast.Deserialize reads signed payloads without checking them.
+package signing
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+var (
+	// ErrUnsigned is returned when verification is required but the
+	// payload carries no signature.
+	ErrUnsigned = errors.New("payload is not signed")
+	// ErrUntrusted is returned when the payload was signed by a key
+	// outside the trust set.
+	ErrUntrusted = errors.New("payload signer is not trusted")
+	// ErrBadSignature is returned when the signature does not match the
+	// payload, for example because the payload was modified after signing.
+	ErrBadSignature = errors.New("payload signature does not match")
+)
+
+// Signer signs payloads. Implementations may keep their key elsewhere, such
+// as in a KMS or an HSM.
+type Signer interface {
+	// Identity names the key. It is recorded in the envelope and, once
+	// verified, in state.
+	Identity() string
+	Algorithm() string
+	Sign(payload []byte) ([]byte, error)
+}
+
+// Verifier checks an envelope's signature against the keys it trusts,
+// returning ErrUntrusted or ErrBadSignature when it does not hold.
+type Verifier interface {
+	Verify(env *ast.Envelope) error
+}
+
+// Sign wraps payload in an envelope signed by s.
+func Sign(payload []byte, s Signer) ([]byte, error) {
+	if format, err := ast.PayloadFormat(payload); err == nil && format == ast.FormatSigned {
+		return nil, errors.New("payload is already signed")
+	}
+	sig, err := s.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("sign payload as %s: %w", s.Identity(), err)
+	}
+	env := &ast.Envelope{Signer: s.Identity(), Algorithm: s.Algorithm(), Signature: sig, Payload: payload}
+	return env.Seal(), nil
+}
+
+// Verify checks a signed payload with v and returns the payload it wraps
+// and the identity of its signer. Unsigned payloads fail with ErrUnsigned.
+func Verify(data []byte, v Verifier) ([]byte, string, error) {
+	env, err := ast.OpenEnvelope(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if env == nil {
+		return nil, "", ErrUnsigned
+	}
+	if err := v.Verify(env); err != nil {
+		return nil, "", err
+	}
+	return env.Payload, env.Signer, nil
+}
@@ -0,0 +1,116 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+// writeKeys writes name.pem and name.pub into dir.
+func writeKeys(t *testing.T, dir, name string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	pub, _ := x509.MarshalPKIXPublicKey(public)
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".pub"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0644)
+}
+
+func samplePayload(t *testing.T) []byte {
+	t.Helper()
+	payload, err := (&ast.DAG{Nodes: map[string]*ast.Node{
+		"web": {Kind: "Deployment", Name: "web", Spec: &ast.DeploymentSpec{Image: "nginx", Replicas: 1}},
+	}}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, "ci")
+	writeKeys(t, dir, "laptop")
+	signer, err := LoadEd25519Signer(filepath.Join(dir, "ci.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Identity() != "ci" {
+		t.Errorf("expected identity ci, got %q", signer.Identity())
+	}
+	trust, err := LoadTrustSet(filepath.Join(dir, "ci.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := samplePayload(t)
+	signed, err := Sign(payload, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, identity, err := Verify(signed, trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(inner, payload) || identity != "ci" {
+		t.Errorf("Verify = %d bytes signed by %q", len(inner), identity)
+	}
+	if _, err := Sign(signed, signer); err == nil {
+		t.Error("expected signing a signed payload to fail")
+	}
+
+	if _, _, err := Verify(payload, trust); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("expected ErrUnsigned, got %v", err)
+	}
+	laptop, _ := LoadEd25519Signer(filepath.Join(dir, "laptop.pem"))
+	other, _ := Sign(payload, laptop)
+	if _, _, err := Verify(other, trust); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("expected ErrUntrusted, got %v", err)
+	}
+
+	env, _ := ast.OpenEnvelope(signed)
+	env.Payload = bytes.Replace(env.Payload, []byte("nginx"), []byte("evil!"), 1)
+	if _, _, err := Verify(env.Seal(), trust); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for a tampered payload, got %v", err)
+	}
+	env.Algorithm = "rsa"
+	if _, _, err := Verify(env.Seal(), trust); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("expected ErrBadSignature for another algorithm, got %v", err)
+	}
+}
+
+func TestLoadTrustSet(t *testing.T) {
+	dir := t.TempDir()
+	writeKeys(t, dir, "ci")
+	writeKeys(t, dir, "release")
+	trust, err := LoadTrustSet(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trust) != 2 || trust["ci"] == nil || trust["release"] == nil {
+		t.Errorf("expected ci and release to be trusted, got %v", trust)
+	}
+
+	if _, err := LoadTrustSet(t.TempDir()); err == nil {
+		t.Error("expected an empty trust set to fail")
+	}
+	if _, err := LoadTrustSet(filepath.Join(dir, "ci.pem")); err == nil {
+		t.Error("expected a private key to be refused as a public key")
+	}
+	if _, err := LoadEd25519Signer(filepath.Join(dir, "ci.pub")); err == nil {
+		t.Error("expected a public key to be refused as a private key")
+	}
+	if _, err := LoadTrustSet(dir, filepath.Join(dir, "ci.pub")); err == nil {
+		t.Error("expected trusting two keys under one identity to fail")
+	}
+}
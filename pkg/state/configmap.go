@@ -0,0 +1,15 @@
+package state
+
+import "k8s.io/client-go/kubernetes"
+
+// ConfigMapStore implements Store like KubernetesStore, but in ConfigMaps,
+// for deployers that may not read Secrets. ConfigMaps are not encrypted at
+// rest and are readable more widely, so state kept in them should hold
+// nothing secret.
+type ConfigMapStore struct {
+	objectStore
+}
+
+func NewConfigMapStore(client kubernetes.Interface, ns string, opts ...KubernetesOption) *ConfigMapStore {
+	return &ConfigMapStore{newObjectStore(client, ns, configMapObjects{client.CoreV1().ConfigMaps(ns)}, opts)}
+}
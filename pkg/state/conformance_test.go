@@ -0,0 +1,32 @@
+package state_test
+
+import (
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+	"github.com/arpanpathak/kube-goAT/pkg/state/storetest"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLocalStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) state.Store {
+		store, err := state.NewLocalStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestKubernetesStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) state.Store {
+		return state.NewKubernetesStore(fake.NewSimpleClientset(), "default")
+	})
+}
+
+func TestConfigMapStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) state.Store {
+		return state.NewConfigMapStore(fake.NewSimpleClientset(), "default")
+	})
+}
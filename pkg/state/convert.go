@@ -0,0 +1,65 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+// Convert rewrites the payloads recorded under keys in the given payload
+// format, or every key when none are given, holding each key's lock while
+// it is rewritten when store implements Locker. Keys already in that format
+// are left alone. It returns the keys it rewrote.
+//
+// The engine keeps state in the format it finds it in, so converted state
+// stays converted across later applies.
+func Convert(ctx context.Context, store Store, format string, keys ...string) ([]string, error) {
+	if _, err := ast.LookupCodec(format); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		var err error
+		if keys, err = store.List(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+	var converted []string
+	for _, key := range keys {
+		rewritten, err := convertKey(ctx, store, key, format)
+		if err != nil {
+			return converted, fmt.Errorf("state %s: %w", key, err)
+		}
+		if rewritten {
+			converted = append(converted, key)
+		}
+	}
+	return converted, nil
+}
+
+// convertKey rewrites one key, holding its lock when store is a Locker,
+// and reports whether it was not already in format.
+func convertKey(ctx context.Context, store Store, key, format string) (rewritten bool, err error) {
+	ctx, unlock, err := Lock(ctx, store, key)
+	if err != nil {
+		return false, err
+	}
+	defer func() { err = errors.Join(err, unlock()) }()
+	data, err := store.Load(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if current, err := ast.PayloadFormat(data); err == nil && current == format {
+		return false, nil
+	}
+	dag, err := ast.Deserialize(data)
+	if err != nil {
+		return false, err
+	}
+	payload, err := dag.SerializeAs(format)
+	if err != nil {
+		return false, err
+	}
+	return true, store.Save(ctx, key, payload)
+}
@@ -0,0 +1,44 @@
+package state
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/ast"
+)
+
+func TestConvert(t *testing.T) {
+	store := newLocalStore(t, t.TempDir())
+	ctx := context.Background()
+	dag := &ast.DAG{Nodes: map[string]*ast.Node{
+		"web": {Kind: "Deployment", Name: "web", Spec: &ast.DeploymentSpec{Image: "nginx", Replicas: 2}},
+	}}
+	gob, _ := dag.Serialize()
+	json, _ := dag.SerializeAs(ast.FormatJSON)
+	store.Save(ctx, "a", gob)
+	store.Save(ctx, "b", json)
+
+	converted, err := Convert(ctx, store, ast.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(converted, []string{"a"}) {
+		t.Errorf("expected only a to be rewritten, got %v", converted)
+	}
+	data, _ := store.Load(ctx, "a")
+	if format, _ := ast.PayloadFormat(data); format != ast.FormatJSON {
+		t.Errorf("expected a to be stored as json, got %q", format)
+	}
+	decoded, err := ast.Deserialize(data)
+	if err != nil || decoded.Hash() != dag.Hash() {
+		t.Errorf("conversion changed the graph: %v", err)
+	}
+
+	if _, err := Convert(ctx, store, "yaml", "a"); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+	if _, err := Convert(ctx, store, ast.FormatProto, "missing"); err == nil {
+		t.Error("expected a missing key to fail")
+	}
+}
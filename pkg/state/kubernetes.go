@@ -1,52 +1,344 @@
 package state
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
 
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// StateLabel marks the Secrets holding state so List can find them among
+// the other Secrets in the namespace.
+const StateLabel = "kube-goat.io/state"
+
+// DefaultChunkSize keeps every state Secret well under the API server's
+// 1 MiB limit on Secret data.
+const DefaultChunkSize = 768 << 10
+
+// ErrChecksumMismatch is returned by Load when the reassembled payload does
+// not match the checksum recorded when it was saved, for example because a
+// chunk Secret was edited or restored from a different backup. Migrate
+// returns it when a copied payload reads back differently.
+var ErrChecksumMismatch = errors.New("state checksum mismatch")
+
+const (
+	// stateDataKey holds the payload, or its first chunk, in a Secret.
+	stateDataKey = "state.gob"
+
+	// The header of a stored payload. Secrets written before payloads were
+	// compressed and chunked carry none of these and hold the payload as is.
+	encodingAnnotation = "kube-goat.io/state-encoding"
+	chunksAnnotation   = "kube-goat.io/state-chunks"
+	checksumAnnotation = "kube-goat.io/state-sha256"
+
+	// chunkLabel marks the Secrets holding the second and later chunks of a
+	// payload; chunkOfAnnotation names the state key they belong to.
+	chunkLabel        = "kube-goat.io/state-chunk"
+	chunkOfAnnotation = "kube-goat.io/state-chunk-of"
+
+	encodingGzip = "gzip"
 )
 
 // KubernetesStore implements Store by saving AST state into a Kubernetes Secret.
+//
+// Payloads are gzip-compressed when that makes them smaller. Payloads still
+// larger than the chunk size are split across the state Secret and further
+// Secrets named after it, and Load reassembles them and verifies their
+// SHA-256 checksum. Lock holds a coordination.k8s.io Lease per key.
 type KubernetesStore struct {
+	objectStore
+}
+
+// KubernetesOption configures a KubernetesStore or a ConfigMapStore.
+type KubernetesOption func(*objectStore)
+
+// WithChunkSize sets the largest number of payload bytes stored in a single
+// object. It defaults to DefaultChunkSize.
+func WithChunkSize(n int) KubernetesOption {
+	return func(k *objectStore) {
+		if n > 0 {
+			k.chunkSize = n
+		}
+	}
+}
+
+func NewKubernetesStore(client kubernetes.Interface, ns string, opts ...KubernetesOption) *KubernetesStore {
+	return &KubernetesStore{newObjectStore(client, ns, secretObjects{client.CoreV1().Secrets(ns)}, opts)}
+}
+
+// objectStore keeps payloads in the Secrets or ConfigMaps of a namespace.
+type objectStore struct {
 	client    kubernetes.Interface
 	namespace string
+	objects   objects
+	chunkSize int
 }
 
-func NewKubernetesStore(client kubernetes.Interface, ns string) *KubernetesStore {
-	return &KubernetesStore{client: client, namespace: ns}
+func newObjectStore(client kubernetes.Interface, ns string, objs objects, opts []KubernetesOption) objectStore {
+	k := objectStore{client: client, namespace: ns, objects: objs, chunkSize: DefaultChunkSize}
+	for _, opt := range opts {
+		opt(&k)
+	}
+	return k
+}
+
+// Namespace returns the namespace holding the state objects.
+func (k *objectStore) Namespace() string {
+	return k.namespace
 }
 
 // Save writes the binary gob payload to a K8s Secret.
-func (k *KubernetesStore) Save(ctx context.Context, key string, data []byte) error {
-	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, key, metav1.GetOptions{})
+//
+// Chunk objects are named after the payload's checksum and written before
+// the state object that points at them, so an interrupted Save leaves the
+// previous payload readable. The chunks of the payload it replaces are
+// removed last. Concurrent saves of a key retry until one wins.
+func (k *objectStore) Save(ctx context.Context, key string, data []byte) error {
+	stored, encoding := compress(data)
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	chunks := split(stored, k.chunkSize)
+	for i := 1; i < len(chunks); i++ {
+		if err := k.saveChunk(ctx, key, chunkName(key, checksum, i), chunks[i]); err != nil {
+			return err
+		}
+	}
 
-	if errors.IsNotFound(err) {
-		newSecret := &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{Name: key, Namespace: k.namespace},
-			Data:       map[string][]byte{"state.gob": data},
+	var replaced *object
+	retriable := func(err error) bool { return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) }
+	err := retry.OnError(retry.DefaultRetry, retriable, func() error {
+		obj, err := k.objects.get(ctx, key)
+		exists := err == nil
+		if apierrors.IsNotFound(err) {
+			obj = &object{ObjectMeta: metav1.ObjectMeta{Name: key, Namespace: k.namespace}}
+		} else if err != nil {
+			return err
 		}
-		_, err = k.client.CoreV1().Secrets(k.namespace).Create(ctx, newSecret, metav1.CreateOptions{})
+		replaced = &object{ObjectMeta: *obj.ObjectMeta.DeepCopy()}
+
+		if obj.Data == nil {
+			obj.Data = make(map[string][]byte)
+		}
+		obj.Data[stateDataKey] = chunks[0]
+		if obj.Labels == nil {
+			obj.Labels = make(map[string]string)
+		}
+		obj.Labels[StateLabel] = "true"
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[checksumAnnotation] = checksum
+		setAnnotation(obj, encodingAnnotation, encoding)
+		if len(chunks) > 1 {
+			setAnnotation(obj, chunksAnnotation, strconv.Itoa(len(chunks)))
+		} else {
+			setAnnotation(obj, chunksAnnotation, "")
+		}
+		if exists {
+			return k.objects.update(ctx, obj)
+		}
+		return k.objects.create(ctx, obj)
+	})
+	if err != nil {
 		return err
-	} else if err != nil {
+	}
+	if old := replaced.Annotations[checksumAnnotation]; old != checksum {
+		return k.deleteChunks(ctx, key, replaced)
+	}
+	return nil
+}
+
+// saveChunk writes one chunk object, refusing to overwrite an object that
+// is not a chunk of key.
+func (k *objectStore) saveChunk(ctx context.Context, key, name string, data []byte) error {
+	chunk := &object{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   k.namespace,
+			Labels:      map[string]string{chunkLabel: "true"},
+			Annotations: map[string]string{chunkOfAnnotation: key},
+		},
+		Data: map[string][]byte{stateDataKey: data},
+	}
+	err := k.objects.create(ctx, chunk)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	existing, err := k.objects.get(ctx, name)
+	if err != nil {
 		return err
 	}
+	if existing.Annotations[chunkOfAnnotation] != key {
+		return fmt.Errorf("%s %s already exists and is not a chunk of state %s", k.objects.kind(), name, key)
+	}
+	existing.Data = chunk.Data
+	return k.objects.update(ctx, existing)
+}
 
-	if secret.Data == nil {
-		secret.Data = make(map[string][]byte)
+// deleteChunks removes the chunk objects of the payload obj held.
+func (k *objectStore) deleteChunks(ctx context.Context, key string, obj *object) error {
+	count, _ := strconv.Atoi(obj.Annotations[chunksAnnotation])
+	checksum := obj.Annotations[checksumAnnotation]
+	var errs []error
+	for i := 1; i < count && len(checksum) >= 12; i++ {
+		if err := k.objects.delete(ctx, chunkName(key, checksum, i)); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
 	}
-	secret.Data["state.gob"] = data
-	_, err = k.client.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
-	return err
+	return errors.Join(errs...)
 }
 
 // Load retrieves the binary gob payload from a K8s Secret.
-func (k *KubernetesStore) Load(ctx context.Context, key string) ([]byte, error) {
-	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, key, metav1.GetOptions{})
+func (k *objectStore) Load(ctx context.Context, key string) ([]byte, error) {
+	obj, err := k.objects.get(ctx, key)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("state %s: %w", key, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	data := obj.Data[stateDataKey]
+	checksum, verify := obj.Annotations[checksumAnnotation]
+
+	if n := obj.Annotations[chunksAnnotation]; n != "" {
+		count, err := strconv.Atoi(n)
+		if err != nil || count < 1 || len(checksum) < 12 {
+			return nil, fmt.Errorf("state %s: invalid chunk header", key)
+		}
+		data = bytes.Clone(data)
+		for i := 1; i < count; i++ {
+			// Not wrapped: a missing chunk must not read as missing state.
+			chunk, err := k.objects.get(ctx, chunkName(key, checksum, i))
+			if err != nil {
+				return nil, fmt.Errorf("state %s: chunk %d: %v", key, i, err)
+			}
+			data = append(data, chunk.Data[stateDataKey]...)
+		}
+	}
+
+	switch encoding := obj.Annotations[encodingAnnotation]; encoding {
+	case "":
+	case encodingGzip:
+		if data, err = gunzip(data); err != nil {
+			return nil, fmt.Errorf("state %s: %w", key, err)
+		}
+	default:
+		return nil, fmt.Errorf("state %s: unknown encoding %q", key, encoding)
+	}
+
+	if verify {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != checksum {
+			return nil, fmt.Errorf("state %s: %w", key, ErrChecksumMismatch)
+		}
+	}
+	return data, nil
+}
+
+// Exists reports whether the state object for key exists.
+func (k *objectStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := k.objects.get(ctx, key)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the keys of the state objects in the namespace starting
+// with prefix.
+func (k *objectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	objs, err := k.objects.list(ctx, StateLabel+"=true")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if strings.HasPrefix(obj.Name, prefix) {
+			keys = append(keys, obj.Name)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the state object for key and its chunk objects, including
+// any left behind by interrupted saves.
+func (k *objectStore) Delete(ctx context.Context, key string) error {
+	if err := k.objects.delete(ctx, key); apierrors.IsNotFound(err) {
+		return fmt.Errorf("state %s: %w", key, ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	chunks, err := k.objects.list(ctx, chunkLabel+"=true")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, chunk := range chunks {
+		if chunk.Annotations[chunkOfAnnotation] != key {
+			continue
+		}
+		if err := k.objects.delete(ctx, chunk.Name); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chunkName names the i-th chunk object of a payload. Including the
+// checksum keeps the chunks of successive payloads apart.
+func chunkName(key, checksum string, i int) string {
+	return key + "." + checksum[:12] + "." + strconv.Itoa(i)
+}
+
+func setAnnotation(obj *object, name, value string) {
+	if value == "" {
+		delete(obj.Annotations, name)
+		return
+	}
+	obj.Annotations[name] = value
+}
+
+// compress gzips data, returning it unchanged when that would not make it
+// smaller.
+func compress(data []byte) ([]byte, string) {
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	zw.Write(data)
+	zw.Close()
+	if buf.Len() >= len(data) {
+		return data, ""
+	}
+	return buf.Bytes(), encodingGzip
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	return secret.Data["state.gob"], nil
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// split cuts data into pieces of at most size bytes, always returning at
+// least one.
+func split(data []byte, size int) [][]byte {
+	chunks := [][]byte{}
+	for len(data) > size {
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+	return append(chunks, data)
 }
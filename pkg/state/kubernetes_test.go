@@ -1,7 +1,12 @@
 package state
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -62,3 +67,122 @@ func TestKubernetesStore(t *testing.T) {
 		t.Errorf("Save on nil Data secret failed: %v", err)
 	}
 }
+
+func TestKubernetesStore_ListDelete(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"},
+	})
+	store := NewKubernetesStore(client, "default")
+	ctx := context.Background()
+	for _, key := range []string{"b", "a"} {
+		if err := store.Save(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := store.List(ctx, "")
+	if err != nil || len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Load(ctx, "a"); err == nil {
+		t.Error("expected deleted key to be gone")
+	}
+}
+
+func TestKubernetesStore_CompressesAndChunks(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := NewKubernetesStore(client, "default", WithChunkSize(1024))
+	ctx := context.Background()
+	secrets := client.CoreV1().Secrets("default")
+
+	// Compressible payloads are gzipped into a single Secret.
+	text := bytes.Repeat([]byte("kube-goat "), 1000)
+	if err := store.Save(ctx, "text", text); err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := secrets.Get(ctx, "text", metav1.GetOptions{})
+	if secret.Annotations[encodingAnnotation] != "gzip" || len(secret.Data[stateDataKey]) >= len(text) {
+		t.Errorf("expected a compressed payload, got %d bytes with %v", len(secret.Data[stateDataKey]), secret.Annotations)
+	}
+	if loaded, err := store.Load(ctx, "text"); err != nil || !bytes.Equal(loaded, text) {
+		t.Errorf("Load of compressed payload = %d bytes, %v", len(loaded), err)
+	}
+
+	// Incompressible payloads are stored as is, split across Secrets.
+	noise := make([]byte, 4000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	if err := store.Save(ctx, "noise", noise); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSecrets(t, client); n != 2+3 {
+		t.Errorf("expected 2 state Secrets and 3 chunk Secrets, got %d Secrets", n)
+	}
+	if loaded, err := store.Load(ctx, "noise"); err != nil || !bytes.Equal(loaded, noise) {
+		t.Errorf("Load of chunked payload = %d bytes, %v", len(loaded), err)
+	}
+	if keys, _ := store.List(ctx, ""); len(keys) != 2 {
+		t.Errorf("expected chunk Secrets to be left out of List, got %v", keys)
+	}
+
+	// A tampered chunk fails the checksum.
+	secret, _ = secrets.Get(ctx, "noise", metav1.GetOptions{})
+	chunk, _ := secrets.Get(ctx, chunkName("noise", secret.Annotations[checksumAnnotation], 2), metav1.GetOptions{})
+	chunk.Data[stateDataKey][0] ^= 0xff
+	secrets.Update(ctx, chunk, metav1.UpdateOptions{})
+	if _, err := store.Load(ctx, "noise"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// Saving a smaller payload removes the old chunks, and Delete removes the rest.
+	if err := store.Save(ctx, "noise", noise[:3000]); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSecrets(t, client); n != 2+2 {
+		t.Errorf("expected stale chunks to be removed, got %d Secrets", n)
+	}
+	if loaded, err := store.Load(ctx, "noise"); err != nil || !bytes.Equal(loaded, noise[:3000]) {
+		t.Errorf("Load after resave = %d bytes, %v", len(loaded), err)
+	}
+	if err := store.Delete(ctx, "noise"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countSecrets(t, client); n != 1 {
+		t.Errorf("expected Delete to remove the chunks, got %d Secrets", n)
+	}
+}
+
+func TestKubernetesStore_RefusesForeignChunkSecret(t *testing.T) {
+	noise := make([]byte, 2000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	sum := sha256.Sum256(noise)
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: chunkName("env", hex.EncodeToString(sum[:]), 1), Namespace: "default"},
+	})
+	store := NewKubernetesStore(client, "default", WithChunkSize(1024))
+	if err := store.Save(context.Background(), "env", noise); err == nil {
+		t.Error("expected an unrelated Secret in the way of a chunk to fail Save")
+	}
+}
+
+func TestKubernetesStore_LoadsLegacySecret(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "env", Namespace: "default"},
+		Data:       map[string][]byte{"state.gob": []byte("payload")},
+	})
+	loaded, err := NewKubernetesStore(client, "default").Load(context.Background(), "env")
+	if err != nil || string(loaded) != "payload" {
+		t.Errorf("Load = %q, %v", loaded, err)
+	}
+}
+
+func countSecrets(t *testing.T, client *fake.Clientset) int {
+	t.Helper()
+	list, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(list.Items)
+}
@@ -0,0 +1,89 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Lock holds the Lease named key + ".lock" until unlock is called,
+// renewing it in the background. A Lease not renewed for
+// DefaultLockDuration is taken over.
+func (k *objectStore) Lock(ctx context.Context, key string) (func() error, error) {
+	name := key + ".lock"
+	return holdLock(ctx, key,
+		func(ctx context.Context, holder string) error { return k.acquire(ctx, name, holder) },
+		func(ctx context.Context, holder string) error { return k.release(ctx, name, holder) })
+}
+
+// acquire creates the Lease, or takes it over when it is expired or
+// already held by holder, renewing it either way.
+func (k *objectStore) acquire(ctx context.Context, name, holder string) error {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(DefaultLockDuration / time.Second)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: k.namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return errLocked
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	held := lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity == holder
+	if !held && !leaseExpired(lease, now.Time) {
+		return errLocked
+	}
+	if !held {
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return errLocked
+	}
+	return err
+}
+
+func (k *objectStore) release(ctx context.Context, name, holder string) error {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		// Taken over after expiring; it is no longer ours to release.
+		return nil
+	}
+	err = leases.Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &lease.UID}})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiry)
+}
@@ -2,29 +2,152 @@ package state
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-// LocalStore implements Store using the local filesystem.
+// ErrInvalidKey is returned for keys that cannot name a state file, such
+// as ones containing path separators.
+var ErrInvalidKey = errors.New("invalid state key")
+
+// LocalStore implements Store using the local filesystem, one file per key
+// named key + ".gob". Files are only readable by their owner, and Save
+// replaces them atomically, so a crash never leaves a partial payload.
 type LocalStore struct {
 	dir string
 }
 
-// NewLocalStore initializes a state store in the given directory.
-func NewLocalStore(dir string) *LocalStore {
-	os.MkdirAll(dir, 0755)
-	return &LocalStore{dir: dir}
+// NewLocalStore initializes a state store in the given directory, creating
+// it if needed.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("state directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// path returns the file holding key, refusing keys that would resolve
+// outside the directory.
+func (l *LocalStore) path(key, suffix string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`+"\x00") || !filepath.IsLocal(key) {
+		return "", fmt.Errorf("%w %q", ErrInvalidKey, key)
+	}
+	return filepath.Join(l.dir, key+suffix), nil
 }
 
-// Save writes the binary gob to a local file.
+// Save writes the binary gob to a temporary file, syncs it and renames it
+// over the key's file.
 func (l *LocalStore) Save(ctx context.Context, key string, data []byte) error {
-	path := filepath.Join(l.dir, key+".gob")
-	return os.WriteFile(path, data, 0644)
+	path, err := l.path(key, ".gob")
+	if err != nil {
+		return err
+	}
+	// The leading dot and the suffix keep temporary files out of List.
+	tmp, err := os.CreateTemp(l.dir, "."+key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
 }
 
 // Load retrieves the binary gob from a local file.
 func (l *LocalStore) Load(ctx context.Context, key string) ([]byte, error) {
-	path := filepath.Join(l.dir, key+".gob")
-	return os.ReadFile(path)
+	path, err := l.path(key, ".gob")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("state %s: %w", key, ErrNotFound)
+	}
+	return data, err
+}
+
+// Exists reports whether the state file for key exists.
+func (l *LocalStore) Exists(ctx context.Context, key string) (bool, error) {
+	path, err := l.path(key, ".gob")
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the keys of the state files in the directory starting with
+// prefix.
+func (l *LocalStore) List(ctx context.Context, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), ".gob")
+		if ok && entry.Type().IsRegular() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the state file for key.
+func (l *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key, ".gob")
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("state %s: %w", key, ErrNotFound)
+	}
+	return err
+}
+
+// Lock holds an exclusive lock on key across processes until unlock is
+// called. The lock lives in a "." + key + ".lock" file next to the state
+// file; see lockFile for how it is held on each platform.
+func (l *LocalStore) Lock(ctx context.Context, key string) (func() error, error) {
+	if _, err := l.path(key, ".gob"); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(l.dir, "."+key+".lock")
+	for {
+		unlock, err := lockFile(path)
+		if err == nil {
+			return unlock, nil
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("lock state %s: %w", key, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock state %s: %w", key, ctx.Err())
+		case <-time.After(lockPoll):
+		}
+	}
 }
@@ -0,0 +1,29 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// lockFile creates path exclusively, failing with errLocked while it
+// exists, and removes it on unlock. Without flock, a holder that crashes
+// leaves the key locked until the file is removed by hand.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, errLocked
+	} else if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() error { return os.Remove(path) }, nil
+}
+
+// syncDir does nothing: not every platform, Windows among them, can open a
+// directory to sync it.
+func syncDir(dir string) error {
+	return nil
+}
@@ -2,9 +2,12 @@ package state
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestLocalStore(t *testing.T) {
@@ -14,7 +17,7 @@ func TestLocalStore(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	store := NewLocalStore(dir)
+	store := newLocalStore(t, dir)
 
 	ctx := context.Background()
 	key := "test-state"
@@ -47,3 +50,142 @@ func TestLocalStore(t *testing.T) {
 		t.Error("Expected error loading non-existent state")
 	}
 }
+
+func TestLocalStore_ListDelete(t *testing.T) {
+	store := newLocalStore(t, t.TempDir())
+	ctx := context.Background()
+	for _, key := range []string{"b", "a"} {
+		if err := store.Save(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := store.List(ctx, "")
+	if err != nil || len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	if err := store.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Load(ctx, "a"); err == nil {
+		t.Error("expected deleted key to be gone")
+	}
+}
+
+func newLocalStore(t *testing.T, dir string) *LocalStore {
+	t.Helper()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestNewLocalStore_DirectoryError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0600)
+	if _, err := NewLocalStore(filepath.Join(file, "state")); err == nil {
+		t.Error("expected a directory that cannot be created to fail")
+	}
+}
+
+func TestLocalStore_AtomicPrivateWrites(t *testing.T) {
+	dir := t.TempDir()
+	store := newLocalStore(t, dir)
+	ctx := context.Background()
+	path := filepath.Join(dir, "env.gob")
+	os.WriteFile(path, []byte("old"), 0644)
+
+	if err := store.Save(ctx, "env", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("state file holds %q", data)
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("state file mode = %v, want 0600", info.Mode().Perm())
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestLocalStore_RejectsTraversal(t *testing.T) {
+	root := t.TempDir()
+	store := newLocalStore(t, filepath.Join(root, "state"))
+	ctx := context.Background()
+	for _, key := range []string{"../../etc/x", "../escape", "a/b", `a\b`, "..", ".", ""} {
+		if err := store.Save(ctx, key, []byte("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Save(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Load(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Load(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Exists(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Exists(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := store.Lock(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Lock(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 1 {
+		t.Errorf("expected nothing written outside the state directory, got %v", entries)
+	}
+}
+
+func TestLocalStore_LockFileIsNotState(t *testing.T) {
+	store := newLocalStore(t, t.TempDir())
+	ctx := context.Background()
+	unlock, err := store.Lock(ctx, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if keys, _ := store.List(ctx, ""); len(keys) != 0 {
+		t.Errorf("expected the lock file not to be listed, got %v", keys)
+	}
+	if exists, _ := store.Exists(ctx, "env"); exists {
+		t.Error("locking a key must not create its state")
+	}
+}
+
+func TestLock(t *testing.T) {
+	store := newLocalStore(t, t.TempDir())
+	ctx, unlock, err := Lock(context.Background(), store, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The holder's context does not wait for its own lock.
+	_, nested, err := Lock(ctx, store, "env")
+	if err != nil {
+		t.Fatalf("expected the held lock to be reentrant, got %v", err)
+	}
+	if err := nested(); err != nil {
+		t.Fatal(err)
+	}
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := Lock(short, store, "env"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected another holder to wait for the lock, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, unlock, err := Lock(context.Background(), store, "env"); err != nil {
+		t.Errorf("expected the released lock to be taken, got %v", err)
+	} else {
+		unlock()
+	}
+
+	// Stores without locks are not locked.
+	type unlocked struct{ Store }
+	if _, unlock, err := Lock(context.Background(), unlocked{store}, "env"); err != nil || unlock() != nil {
+		t.Errorf("expected a store without locks to be left unlocked, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultLockDuration is how long a lock outlives a holder that stopped
+// renewing it, for example because it crashed.
+const DefaultLockDuration = time.Minute
+
+// lockPoll is how often Lock retries a lock held by someone else.
+var lockPoll = time.Second
+
+// errLocked is returned by acquire functions when someone else holds the
+// lock.
+var errLocked = errors.New("locked")
+
+// heldLock is the context key recording that the lock of a key is held.
+type heldLock struct{ key string }
+
+// Lock takes store's lock on key when store implements Locker, and returns
+// a context recording that it is held along with the function releasing
+// it. Under that context Lock returns at once for the same key, so a
+// caller holding the lock can call code that locks the key itself. Stores
+// that are not Lockers are not locked.
+func Lock(ctx context.Context, store Store, key string) (context.Context, func() error, error) {
+	locker, ok := store.(Locker)
+	if !ok || ctx.Value(heldLock{key}) != nil {
+		return ctx, func() error { return nil }, nil
+	}
+	unlock, err := locker.Lock(ctx, key)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return context.WithValue(ctx, heldLock{key}, true), unlock, nil
+}
+
+// holdLock implements Locker.Lock on top of a store's acquire and release
+// functions. acquire takes or renews the lock for holder, failing with
+// errLocked while someone else holds it. The lock is renewed every third
+// of DefaultLockDuration until unlocked.
+func holdLock(ctx context.Context, key string, acquire, release func(ctx context.Context, holder string) error) (func() error, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	holder := hex.EncodeToString(id)
+	for {
+		err := acquire(ctx, holder)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLocked) {
+			return nil, fmt.Errorf("lock state %s: %w", key, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock state %s: %w", key, ctx.Err())
+		case <-time.After(lockPoll):
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(DefaultLockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				acquire(context.Background(), holder)
+			}
+		}
+	}()
+	return func() error {
+		close(stop)
+		<-done
+		return release(context.Background(), holder)
+	}, nil
+}
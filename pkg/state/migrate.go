@@ -0,0 +1,271 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrHistoryNotKept is returned, wrapped, by Migrate for keys with earlier
+// revisions when the destination keeps no history, unless WithoutHistory
+// is given.
+var ErrHistoryNotKept = errors.New("the destination does not keep history")
+
+// MigrateOption customizes Migrate.
+type MigrateOption func(*migrateOptions)
+
+type migrateOptions struct {
+	lock           bool
+	deleteSource   bool
+	withoutHistory bool
+}
+
+// WithMigrationLock holds the lock of each key, in both stores that
+// implement Locker, while it is copied, so no apply can change it midway.
+func WithMigrationLock() MigrateOption {
+	return func(o *migrateOptions) {
+		o.lock = true
+	}
+}
+
+// WithSourceDeletion deletes each key from the source store once its copy
+// has been verified.
+func WithSourceDeletion() MigrateOption {
+	return func(o *migrateOptions) {
+		o.deleteSource = true
+	}
+}
+
+// WithoutHistory lets Migrate copy only the current payload of keys whose
+// earlier revisions the destination cannot keep, instead of failing with
+// ErrHistoryNotKept.
+func WithoutHistory() MigrateOption {
+	return func(o *migrateOptions) {
+		o.withoutHistory = true
+	}
+}
+
+// Migrate copies the payloads recorded under keys, or every key when keys
+// is empty, from one store to another, and returns the keys it copied.
+// When both stores are Versioned, the earlier revisions of a key are
+// copied first, oldest first, so the destination keeps its history. Keys
+// with earlier revisions fail with ErrHistoryNotKept when the destination
+// keeps none, as stores that are not Versioned and S3 buckets without
+// versioning do, unless WithoutHistory is given.
+//
+// Every payload is read back from the destination and compared with the
+// source by SHA-256; a difference fails with ErrChecksumMismatch. A key the
+// destination already holds is skipped if it holds the same payload, and
+// resumed if its history holds the first revisions of the source, which
+// lets an interrupted migration be run again; it is refused otherwise.
+func Migrate(ctx context.Context, from, to Store, keys []string, opts ...MigrateOption) ([]string, error) {
+	var o migrateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(keys) == 0 {
+		var err error
+		if keys, err = from.List(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+	var migrated []string
+	for _, key := range keys {
+		copied, err := migrateKey(ctx, from, to, key, o)
+		if err != nil {
+			return migrated, fmt.Errorf("migrate state %s: %w", key, err)
+		}
+		if copied {
+			migrated = append(migrated, key)
+		}
+	}
+	return migrated, nil
+}
+
+// Rename moves the payload recorded under from to the key to within
+// store, holding the lock of both keys when store implements Locker. Like
+// Migrate, it copies the key's history when store is Versioned, verifies
+// the copy and only then deletes from, so an interrupted rename can be run
+// again; a to key holding a different payload is refused.
+func Rename(ctx context.Context, store Store, from, to string) (err error) {
+	if from == to {
+		return fmt.Errorf("rename state %s: the new key is the same", from)
+	}
+	if locker, ok := store.(Locker); ok {
+		// Locking in key order keeps two opposite renames from
+		// deadlocking.
+		keys := []string{from, to}
+		sort.Strings(keys)
+		for _, key := range keys {
+			unlock, err := locker.Lock(ctx, key)
+			if err != nil {
+				return fmt.Errorf("rename state %s: %w", from, err)
+			}
+			defer func() { err = errors.Join(err, unlock()) }()
+		}
+	}
+	if _, err := copyKey(ctx, store, from, store, to, migrateOptions{deleteSource: true}); err != nil {
+		return fmt.Errorf("rename state %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
+func migrateKey(ctx context.Context, from, to Store, key string, o migrateOptions) (copied bool, err error) {
+	if o.lock {
+		for _, store := range []Store{from, to} {
+			locker, ok := store.(Locker)
+			if !ok {
+				continue
+			}
+			unlock, err := locker.Lock(ctx, key)
+			if err != nil {
+				return false, err
+			}
+			defer func() { err = errors.Join(err, unlock()) }()
+		}
+	}
+	return copyKey(ctx, from, key, to, key, o)
+}
+
+// copyKey copies the payload recorded under fromKey in from, and its
+// history when both stores keep one, to toKey in to, deleting the source
+// once the copy is verified if o.deleteSource is set. It reports whether
+// anything was copied: a destination already holding the same payload is
+// only a copy that was interrupted before the source was deleted, and one
+// holding the first revisions of the source is resumed after them.
+func copyKey(ctx context.Context, from Store, fromKey string, to Store, toKey string, o migrateOptions) (bool, error) {
+	data, err := from.Load(ctx, fromKey)
+	if err != nil {
+		return false, err
+	}
+	existing, err := to.Load(ctx, toKey)
+	found := err == nil
+	switch {
+	case found && bytes.Equal(existing, data):
+		return false, deleteKey(ctx, from, fromKey, o.deleteSource)
+	case !found && !errors.Is(err, ErrNotFound):
+		return false, err
+	}
+
+	history, err := earlierRevisions(ctx, from, to, fromKey, o.withoutHistory)
+	if err != nil {
+		return false, err
+	}
+	payloads := append(history, data)
+	var copied int
+	if found {
+		if copied, err = copiedRevisions(ctx, to, toKey, payloads); err != nil {
+			return false, err
+		}
+		if copied == 0 {
+			return false, fmt.Errorf("the destination already holds a different payload")
+		}
+	}
+	for _, payload := range payloads[copied:] {
+		if err := copyPayload(ctx, to, toKey, payload); err != nil {
+			return false, err
+		}
+	}
+	return true, deleteKey(ctx, from, fromKey, o.deleteSource)
+}
+
+// copiedRevisions returns how many of payloads, oldest first, the history
+// of key in to holds and nothing else, as a copy interrupted midway leaves
+// it, or 0 if it holds anything else.
+func copiedRevisions(ctx context.Context, to Store, key string, payloads [][]byte) (int, error) {
+	dest, ok, err := keepsHistory(ctx, to)
+	if !ok || len(payloads) < 2 {
+		return 0, err
+	}
+	revisions, err := dest.History(ctx, key)
+	if err != nil || len(revisions) >= len(payloads) {
+		return 0, err
+	}
+	for i := range revisions {
+		rev := revisions[len(revisions)-1-i]
+		data, err := dest.LoadRevision(ctx, key, rev.ID)
+		if err != nil {
+			return 0, fmt.Errorf("revision %s: %w", rev.ID, err)
+		}
+		if !bytes.Equal(data, payloads[i]) {
+			return 0, nil
+		}
+	}
+	return len(revisions), nil
+}
+
+// earlierRevisions returns the payloads key held before its current one,
+// oldest first, when both stores keep history. When only from does, there
+// must be none unless dropHistory is set.
+func earlierRevisions(ctx context.Context, from, to Store, key string, dropHistory bool) ([][]byte, error) {
+	source, ok := from.(Versioned)
+	if !ok {
+		return nil, nil
+	}
+	revisions, err := source.History(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, keeps, err := keepsHistory(ctx, to); err != nil {
+		return nil, err
+	} else if !keeps {
+		if len(revisions) > 1 && !dropHistory {
+			return nil, fmt.Errorf("%w: %d earlier revisions would be lost", ErrHistoryNotKept, len(revisions)-1)
+		}
+		return nil, nil
+	}
+	var payloads [][]byte
+	// The newest revision is the current payload.
+	for i := len(revisions) - 1; i >= 1; i-- {
+		data, err := source.LoadRevision(ctx, key, revisions[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("revision %s: %w", revisions[i].ID, err)
+		}
+		payloads = append(payloads, data)
+	}
+	return payloads, nil
+}
+
+// keepsHistory returns store as Versioned if saving a key keeps its
+// earlier payloads: a store may implement Versioned, like S3Store, yet not
+// keep them, like an S3 bucket without versioning.
+func keepsHistory(ctx context.Context, store Store) (Versioned, bool, error) {
+	versioned, ok := store.(Versioned)
+	if !ok {
+		return nil, false, nil
+	}
+	if v, ok := store.(interface {
+		Versioning(ctx context.Context) (bool, error)
+	}); ok {
+		if enabled, err := v.Versioning(ctx); err != nil || !enabled {
+			return nil, false, err
+		}
+	}
+	return versioned, true, nil
+}
+
+// copyPayload saves data under key and reads it back to check it arrived
+// intact.
+func copyPayload(ctx context.Context, to Store, key string, data []byte) error {
+	if err := to.Save(ctx, key, data); err != nil {
+		return err
+	}
+	saved, err := to.Load(ctx, key)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(saved) != sha256.Sum256(data) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+func deleteKey(ctx context.Context, store Store, key string, deleteSource bool) error {
+	if !deleteSource {
+		return nil
+	}
+	return store.Delete(ctx, key)
+}
@@ -0,0 +1,282 @@
+package state_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMigrate_LocalToKubernetes(t *testing.T) {
+	from, err := state.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := state.NewKubernetesStore(fake.NewSimpleClientset(), "default")
+	ctx := context.Background()
+	from.Save(ctx, "web", []byte("web payload"))
+	from.Save(ctx, "api", []byte("api payload"))
+
+	migrated, err := state.Migrate(ctx, from, to, nil, state.WithMigrationLock(), state.WithSourceDeletion())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(migrated, []string{"api", "web"}) {
+		t.Errorf("migrated %v, want [api web]", migrated)
+	}
+	for _, key := range migrated {
+		if data, err := to.Load(ctx, key); err != nil || string(data) != key+" payload" {
+			t.Errorf("destination %s = %q, %v", key, data, err)
+		}
+	}
+	if keys, _ := from.List(ctx, ""); len(keys) != 0 {
+		t.Errorf("expected the source entries to be deleted, got %v", keys)
+	}
+}
+
+func TestMigrate_CopiesHistory(t *testing.T) {
+	_, from := newFakeS3(t, true)
+	_, to := newFakeS3(t, true)
+	ctx := context.Background()
+	saved := []string{"v1", "v2", "v3"}
+	for _, data := range saved {
+		from.Save(ctx, "env", []byte(data))
+	}
+
+	if _, err := state.Migrate(ctx, from, to, []string{"env"}); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := to.History(ctx, "env")
+	if err != nil || len(revisions) != len(saved) {
+		t.Fatalf("expected %d revisions, got %v (%v)", len(saved), revisions, err)
+	}
+	for i, rev := range revisions {
+		want := saved[len(saved)-1-i]
+		if data, _ := to.LoadRevision(ctx, "env", rev.ID); string(data) != want {
+			t.Errorf("revision %d = %q, want %q", i, data, want)
+		}
+	}
+}
+
+func TestMigrate_ReportsDroppedHistory(t *testing.T) {
+	_, from := newFakeS3(t, true)
+	to := state.NewKubernetesStore(fake.NewSimpleClientset(), "default")
+	ctx := context.Background()
+	from.Save(ctx, "once", []byte("v1"))
+	from.Save(ctx, "env", []byte("v1"))
+	from.Save(ctx, "env", []byte("v2"))
+
+	if _, err := state.Migrate(ctx, from, to, []string{"once"}); err != nil {
+		t.Fatalf("expected a key without history to be copied, got %v", err)
+	}
+	if _, err := state.Migrate(ctx, from, to, []string{"env"}); !errors.Is(err, state.ErrHistoryNotKept) {
+		t.Fatalf("expected ErrHistoryNotKept, got %v", err)
+	}
+	if exists, _ := to.Exists(ctx, "env"); exists {
+		t.Error("nothing must be copied when history would be dropped")
+	}
+	if _, err := state.Migrate(ctx, from, to, []string{"env"}, state.WithoutHistory()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := to.Load(ctx, "env"); string(data) != "v2" {
+		t.Errorf("env = %q, want v2", data)
+	}
+}
+
+func TestMigrate_UnversionedBucket(t *testing.T) {
+	_, from := newFakeS3(t, true)
+	_, to := newFakeS3(t, false)
+	ctx := context.Background()
+	if enabled, err := to.Versioning(ctx); err != nil || enabled {
+		t.Fatalf("Versioning = %v, %v, want false", enabled, err)
+	}
+	for _, data := range []string{"v1", "v2", "v3"} {
+		from.Save(ctx, "env", []byte(data))
+	}
+
+	if _, err := state.Migrate(ctx, from, to, nil); !errors.Is(err, state.ErrHistoryNotKept) {
+		t.Fatalf("expected ErrHistoryNotKept, got %v", err)
+	}
+	if exists, _ := to.Exists(ctx, "env"); exists {
+		t.Error("no revision must be written over another")
+	}
+	if _, err := state.Migrate(ctx, from, to, nil, state.WithoutHistory()); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, _ := to.History(ctx, "env"); len(revisions) != 1 {
+		t.Errorf("expected only the current payload to be written, got %v", revisions)
+	}
+	if data, _ := to.Load(ctx, "env"); string(data) != "v3" {
+		t.Errorf("env = %q, want v3", data)
+	}
+}
+
+// interrupted fails every save after the first n.
+type interrupted struct {
+	*state.S3Store
+	n int
+}
+
+func (s *interrupted) Save(ctx context.Context, key string, data []byte) error {
+	if s.n == 0 {
+		return errors.New("interrupted")
+	}
+	s.n--
+	return s.S3Store.Save(ctx, key, data)
+}
+
+func TestMigrate_ResumesHistory(t *testing.T) {
+	_, from := newFakeS3(t, true)
+	_, to := newFakeS3(t, true)
+	ctx := context.Background()
+	saved := []string{"v1", "v2", "v3"}
+	for _, data := range saved {
+		from.Save(ctx, "env", []byte(data))
+	}
+
+	if _, err := state.Migrate(ctx, from, &interrupted{S3Store: to, n: 2}, nil); err == nil {
+		t.Fatal("expected the interrupted migration to fail")
+	}
+	if data, _ := to.Load(ctx, "env"); string(data) != "v2" {
+		t.Fatalf("destination = %q, want the interrupted copy at v2", data)
+	}
+	migrated, err := state.Migrate(ctx, from, to, nil)
+	if err != nil || !slices.Equal(migrated, []string{"env"}) {
+		t.Fatalf("expected the migration to resume, got %v, %v", migrated, err)
+	}
+	revisions, err := to.History(ctx, "env")
+	if err != nil || len(revisions) != len(saved) {
+		t.Fatalf("expected %d revisions, got %v (%v)", len(saved), revisions, err)
+	}
+	for i, rev := range revisions {
+		want := saved[len(saved)-1-i]
+		if data, _ := to.LoadRevision(ctx, "env", rev.ID); string(data) != want {
+			t.Errorf("revision %d = %q, want %q", i, data, want)
+		}
+	}
+
+	// History the source never had is not an interrupted copy.
+	from.Save(ctx, "diverged", []byte("v1"))
+	from.Save(ctx, "diverged", []byte("v2"))
+	to.Save(ctx, "diverged", []byte("other"))
+	if _, err := state.Migrate(ctx, from, to, []string{"diverged"}); err == nil {
+		t.Error("expected a destination with different history to be refused")
+	}
+}
+
+func TestMigrate_ExistingDestination(t *testing.T) {
+	from := state.NewConfigMapStore(fake.NewSimpleClientset(), "default")
+	to := state.NewKubernetesStore(fake.NewSimpleClientset(), "default")
+	ctx := context.Background()
+	from.Save(ctx, "done", []byte("same"))
+	to.Save(ctx, "done", []byte("same"))
+	from.Save(ctx, "clash", []byte("ours"))
+	to.Save(ctx, "clash", []byte("theirs"))
+
+	migrated, err := state.Migrate(ctx, from, to, []string{"done"}, state.WithSourceDeletion())
+	if err != nil || len(migrated) != 0 {
+		t.Fatalf("expected an already copied key to be skipped, got %v, %v", migrated, err)
+	}
+	if exists, _ := from.Exists(ctx, "done"); exists {
+		t.Error("expected an already copied key to still be deleted from the source")
+	}
+
+	if _, err := state.Migrate(ctx, from, to, []string{"clash"}, state.WithSourceDeletion()); err == nil {
+		t.Fatal("expected a different payload in the destination to be refused")
+	}
+	if data, _ := to.Load(ctx, "clash"); string(data) != "theirs" {
+		t.Error("the destination must not be overwritten")
+	}
+	if exists, _ := from.Exists(ctx, "clash"); !exists {
+		t.Error("the source must be kept when its key was not copied")
+	}
+}
+
+// corrupting flips the first byte of every payload it saves.
+type corrupting struct{ state.Store }
+
+func (c corrupting) Save(ctx context.Context, key string, data []byte) error {
+	data = bytes.Clone(data)
+	data[0] ^= 0xff
+	return c.Store.Save(ctx, key, data)
+}
+
+func TestMigrate_VerifiesChecksum(t *testing.T) {
+	from := state.NewKubernetesStore(fake.NewSimpleClientset(), "default")
+	to := corrupting{state.NewKubernetesStore(fake.NewSimpleClientset(), "default")}
+	ctx := context.Background()
+	from.Save(ctx, "env", []byte("payload"))
+
+	_, err := state.Migrate(ctx, from, to, nil, state.WithSourceDeletion())
+	if !errors.Is(err, state.ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if exists, _ := from.Exists(ctx, "env"); !exists {
+		t.Error("the source must be kept when its copy failed verification")
+	}
+}
+
+func TestRename(t *testing.T) {
+	store, err := state.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store.Save(ctx, "old", []byte("payload"))
+	store.Save(ctx, "taken", []byte("other"))
+
+	if err := state.Rename(ctx, store, "old", "taken"); err == nil {
+		t.Fatal("expected renaming onto a different payload to be refused")
+	}
+	if data, _ := store.Load(ctx, "taken"); string(data) != "other" {
+		t.Error("the existing key must not be overwritten")
+	}
+
+	if err := state.Rename(ctx, store, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := store.List(ctx, ""); !slices.Equal(keys, []string{"new", "taken"}) {
+		t.Errorf("keys = %v, want [new taken]", keys)
+	}
+	if data, _ := store.Load(ctx, "new"); string(data) != "payload" {
+		t.Errorf("new = %q", data)
+	}
+
+	// A rename interrupted before deleting the old key completes.
+	store.Save(ctx, "old", []byte("payload"))
+	if err := state.Rename(ctx, store, "old", "new"); err != nil {
+		t.Fatalf("expected the interrupted rename to complete, got %v", err)
+	}
+	if exists, _ := store.Exists(ctx, "old"); exists {
+		t.Error("expected the old key to be deleted")
+	}
+	if err := state.Rename(ctx, store, "missing", "other"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestRename_KeepsHistory(t *testing.T) {
+	_, store := newFakeS3(t, true)
+	ctx := context.Background()
+	store.Save(ctx, "old", []byte("v1"))
+	store.Save(ctx, "old", []byte("v2"))
+
+	if err := state.Rename(ctx, store, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := store.History(ctx, "new")
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %v (%v)", revisions, err)
+	}
+	if data, _ := store.LoadRevision(ctx, "new", revisions[1].ID); string(data) != "v1" {
+		t.Errorf("oldest revision = %q, want v1", data)
+	}
+	if exists, _ := store.Exists(ctx, "old"); exists {
+		t.Error("expected the old key to be deleted")
+	}
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// object is the part of a Secret or ConfigMap a state store uses.
+type object struct {
+	metav1.ObjectMeta
+	Data map[string][]byte
+
+	// orig is the Secret or ConfigMap read, so updates keep the fields
+	// object leaves out.
+	orig any
+}
+
+// objects reads and writes the Secrets or ConfigMaps of one namespace as
+// objects.
+type objects interface {
+	kind() string
+	get(ctx context.Context, name string) (*object, error)
+	create(ctx context.Context, obj *object) error
+	update(ctx context.Context, obj *object) error
+	delete(ctx context.Context, name string) error
+	list(ctx context.Context, selector string) ([]*object, error)
+}
+
+type secretObjects struct {
+	client typedcorev1.SecretInterface
+}
+
+func (secretObjects) kind() string { return "secret" }
+
+func (s secretObjects) get(ctx context.Context, name string) (*object, error) {
+	secret, err := s.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &object{ObjectMeta: secret.ObjectMeta, Data: secret.Data, orig: secret}, nil
+}
+
+func (s secretObjects) create(ctx context.Context, obj *object) error {
+	_, err := s.client.Create(ctx, &corev1.Secret{ObjectMeta: obj.ObjectMeta, Data: obj.Data}, metav1.CreateOptions{})
+	return err
+}
+
+func (s secretObjects) update(ctx context.Context, obj *object) error {
+	secret := &corev1.Secret{}
+	if orig, ok := obj.orig.(*corev1.Secret); ok {
+		secret = orig.DeepCopy()
+	}
+	secret.ObjectMeta, secret.Data = obj.ObjectMeta, obj.Data
+	_, err := s.client.Update(ctx, secret, metav1.UpdateOptions{})
+	return err
+}
+
+func (s secretObjects) delete(ctx context.Context, name string) error {
+	return s.client.Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (s secretObjects) list(ctx context.Context, selector string) ([]*object, error) {
+	secrets, err := s.client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*object, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		objs = append(objs, &object{ObjectMeta: secret.ObjectMeta, Data: secret.Data})
+	}
+	return objs, nil
+}
+
+// configMapObjects keeps object data in BinaryData, since payloads are not
+// UTF-8.
+type configMapObjects struct {
+	client typedcorev1.ConfigMapInterface
+}
+
+func (configMapObjects) kind() string { return "configmap" }
+
+func (c configMapObjects) get(ctx context.Context, name string) (*object, error) {
+	cm, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &object{ObjectMeta: cm.ObjectMeta, Data: cm.BinaryData, orig: cm}, nil
+}
+
+func (c configMapObjects) create(ctx context.Context, obj *object) error {
+	_, err := c.client.Create(ctx, &corev1.ConfigMap{ObjectMeta: obj.ObjectMeta, BinaryData: obj.Data}, metav1.CreateOptions{})
+	return err
+}
+
+func (c configMapObjects) update(ctx context.Context, obj *object) error {
+	cm := &corev1.ConfigMap{}
+	if orig, ok := obj.orig.(*corev1.ConfigMap); ok {
+		cm = orig.DeepCopy()
+	}
+	cm.ObjectMeta, cm.BinaryData = obj.ObjectMeta, obj.Data
+	_, err := c.client.Update(ctx, cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (c configMapObjects) delete(ctx context.Context, name string) error {
+	return c.client.Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+func (c configMapObjects) list(ctx context.Context, selector string) ([]*object, error) {
+	cms, err := c.client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]*object, 0, len(cms.Items))
+	for _, cm := range cms.Items {
+		objs = append(objs, &object{ObjectMeta: cm.ObjectMeta, Data: cm.BinaryData})
+	}
+	return objs, nil
+}
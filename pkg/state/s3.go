@@ -0,0 +1,509 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Config configures an S3Store.
+type S3Config struct {
+	// Endpoint is the base URL of the S3 API, such as
+	// http://localhost:9000 for MinIO. It defaults to AWS's endpoint for
+	// Region.
+	Endpoint string
+	// Region defaults to us-east-1.
+	Region string
+	Bucket string
+	// Prefix is prepended to every object key, such as "clusters/prod/".
+	Prefix string
+
+	// Requests are unsigned when AccessKeyID is empty.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// S3Store implements Store with objects in an S3-compatible bucket, one
+// per key, named Prefix + key. Requests use path-style URLs signed with
+// AWS Signature Version 4.
+//
+// Save is conditional on the object being unchanged since this store last
+// loaded or saved it, or on there being none if it did neither, and
+// otherwise fails with ErrConflict. On a bucket with versioning enabled,
+// History lists every payload saved under a key. Lock writes an object
+// under Prefix + ".locks/" with conditional requests, which the endpoint
+// must support.
+type S3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+
+	mu    sync.Mutex
+	etags map[string]string // of each key's object as last loaded or saved
+}
+
+// S3Error is an error response from the S3 API.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// s3LockPrefix keeps lock objects out of List.
+const s3LockPrefix = ".locks/"
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: endpoint: %w", err)
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Store{cfg: cfg, endpoint: endpoint, client: client, etags: make(map[string]string)}, nil
+}
+
+// Save writes the payload to the key's object if it is still the one last
+// loaded or saved, or if there is none when neither happened, so a
+// concurrent writer's payload is not silently overwritten.
+func (s *S3Store) Save(ctx context.Context, key string, data []byte) error {
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	if etag, ok := s.etag(key); ok {
+		header.Set("If-Match", etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.cfg.Prefix+key, nil, data, header)
+	if preconditionFailed(err) || isS3NotFound(err) {
+		return fmt.Errorf("state %s: %w: %v", key, ErrConflict, err)
+	} else if err != nil {
+		return err
+	}
+	s.setETag(key, resp.header.Get("ETag"))
+	return nil
+}
+
+// Load reads the payload from the key's object.
+func (s *S3Store) Load(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+key, nil, nil, nil)
+	if isS3NotFound(err) {
+		s.setETag(key, "")
+		return nil, fmt.Errorf("state %s: %w", key, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	s.setETag(key, resp.header.Get("ETag"))
+	return resp.body, nil
+}
+
+func (s *S3Store) etag(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etag, ok := s.etags[key]
+	return etag, ok
+}
+
+// setETag records the ETag of the key's object; an empty one records that
+// there is no object.
+func (s *S3Store) setETag(key, etag string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if etag == "" {
+		delete(s.etags, key)
+	} else {
+		s.etags[key] = etag
+	}
+}
+
+// Exists reports whether the key's object exists.
+func (s *S3Store) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.do(ctx, http.MethodHead, s.cfg.Prefix+key, nil, nil, nil)
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns the keys of the objects under the store's prefix that start
+// with prefix.
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix + prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		if err := xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("s3: list objects: %w", err)
+		}
+		for _, obj := range result.Contents {
+			key := strings.TrimPrefix(obj.Key, s.cfg.Prefix)
+			if !strings.HasPrefix(key, s3LockPrefix) {
+				keys = append(keys, key)
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the key's object. On a versioned bucket its history is
+// kept behind a delete marker.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	// S3 reports success for missing objects, and a versioned bucket
+	// would record a delete marker for them.
+	exists, err := s.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("state %s: %w", key, ErrNotFound)
+	}
+	if _, err = s.do(ctx, http.MethodDelete, s.cfg.Prefix+key, nil, nil, nil); err != nil {
+		return err
+	}
+	s.setETag(key, "")
+	return nil
+}
+
+type listVersionsResult struct {
+	Versions            []s3Version `xml:"Version"`
+	DeleteMarkers       []s3Version `xml:"DeleteMarker"`
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIdMarker string
+}
+
+type s3Version struct {
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	LastModified time.Time
+	Size         int64
+}
+
+// Versioning reports whether versioning is enabled on the bucket, so
+// saving a key keeps its earlier payloads. History still works on a bucket
+// without it, but lists only the current payload.
+func (s *S3Store) Versioning(ctx context.Context) (bool, error) {
+	resp, err := s.do(ctx, http.MethodGet, "", url.Values{"versioning": {""}}, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	var result struct {
+		Status string
+	}
+	if err := xml.Unmarshal(resp.body, &result); err != nil {
+		return false, fmt.Errorf("s3: bucket versioning: %w", err)
+	}
+	// A bucket whose versioning was suspended overwrites the null version.
+	return result.Status == "Enabled", nil
+}
+
+// History lists the versions of the key's object, newest first. Buckets
+// without versioning have only the current one, with ID "null".
+func (s *S3Store) History(ctx context.Context, key string) ([]Revision, error) {
+	name := s.cfg.Prefix + key
+	var revisions []Revision
+	query := url.Values{"versions": {""}, "prefix": {name}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listVersionsResult
+		if err := xml.Unmarshal(resp.body, &result); err != nil {
+			return nil, fmt.Errorf("s3: list object versions: %w", err)
+		}
+		for _, marker := range result.DeleteMarkers {
+			if marker.Key == name && marker.IsLatest {
+				return nil, fmt.Errorf("state %s: %w", key, ErrNotFound)
+			}
+		}
+		for _, v := range result.Versions {
+			if v.Key == name {
+				revisions = append(revisions, Revision{ID: v.VersionId, Saved: v.LastModified, Size: v.Size})
+			}
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("key-marker", result.NextKeyMarker)
+		query.Set("version-id-marker", result.NextVersionIdMarker)
+	}
+	if len(revisions) == 0 {
+		return nil, fmt.Errorf("state %s: %w", key, ErrNotFound)
+	}
+	return revisions, nil
+}
+
+// LoadRevision reads one version of the key's object.
+func (s *S3Store) LoadRevision(ctx context.Context, key, id string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.cfg.Prefix+key, url.Values{"versionId": {id}}, nil, nil)
+	if isS3NotFound(err) {
+		return nil, fmt.Errorf("state %s revision %s: %w", key, id, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+// s3Lock is the body of a lock object.
+type s3Lock struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lock holds the object Prefix + ".locks/" + key until unlock is called,
+// renewing it in the background. A lock not renewed for
+// DefaultLockDuration is taken over.
+func (s *S3Store) Lock(ctx context.Context, key string) (func() error, error) {
+	name := s.cfg.Prefix + s3LockPrefix + key
+	return holdLock(ctx, key,
+		func(ctx context.Context, holder string) error { return s.acquire(ctx, name, holder) },
+		func(ctx context.Context, holder string) error { return s.release(ctx, name, holder) })
+}
+
+// acquire creates the lock object if there is none and otherwise replaces
+// it, if expired or already held by holder, only while it is unchanged.
+func (s *S3Store) acquire(ctx context.Context, name, holder string) error {
+	body, _ := json.Marshal(s3Lock{Holder: holder, Expires: time.Now().Add(DefaultLockDuration)})
+	_, err := s.do(ctx, http.MethodPut, name, nil, body, http.Header{"If-None-Match": {"*"}})
+	if !preconditionFailed(err) {
+		return err
+	}
+	current, etag, err := s.readLock(ctx, name)
+	if isS3NotFound(err) {
+		return errLocked
+	} else if err != nil {
+		return err
+	}
+	if current.Holder != holder && time.Now().Before(current.Expires) {
+		return errLocked
+	}
+	_, err = s.do(ctx, http.MethodPut, name, nil, body, http.Header{"If-Match": {etag}})
+	if preconditionFailed(err) || isS3NotFound(err) {
+		return errLocked
+	}
+	return err
+}
+
+func (s *S3Store) release(ctx context.Context, name, holder string) error {
+	current, _, err := s.readLock(ctx, name)
+	if isS3NotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if current.Holder != holder {
+		// Taken over after expiring; it is no longer ours to release.
+		return nil
+	}
+	_, err = s.do(ctx, http.MethodDelete, name, nil, nil, nil)
+	return err
+}
+
+func (s *S3Store) readLock(ctx context.Context, name string) (s3Lock, string, error) {
+	var lock s3Lock
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return lock, "", err
+	}
+	if err := json.Unmarshal(resp.body, &lock); err != nil {
+		return lock, "", fmt.Errorf("s3: lock %s: %w", name, err)
+	}
+	return lock, resp.header.Get("ETag"), nil
+}
+
+func preconditionFailed(err error) bool {
+	var s3Err *S3Error
+	// 409 is returned when a conflicting conditional write is in flight.
+	return errors.As(err, &s3Err) && (s3Err.StatusCode == http.StatusPreconditionFailed || s3Err.StatusCode == http.StatusConflict)
+}
+
+// isS3NotFound reports whether err means the object is missing. A missing
+// bucket is not: it would make every key read as never saved. HEAD
+// responses carry no error code, so for them a 404 is taken at its word.
+func isS3NotFound(err error) bool {
+	var s3Err *S3Error
+	return errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound && s3Err.Code != "NoSuchBucket"
+}
+
+type s3Response struct {
+	header http.Header
+	body   []byte
+}
+
+// do sends a signed request for an object, or for the bucket when name is
+// empty, and reads the whole response.
+func (s *S3Store) do(ctx context.Context, method, name string, query url.Values, body []byte, header http.Header) (*s3Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	if name != "" {
+		u.Path += "/" + name
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if s.cfg.AccessKeyID != "" {
+		signV4(req, s.cfg.Region, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.SessionToken, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		xml.Unmarshal(data, s3Err)
+		return nil, s3Err
+	}
+	return &s3Response{header: resp.Header, body: data}, nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req, signing the host and
+// every header already set. X-Amz-Content-Sha256 must be set, and the URL's
+// path and query must already be in canonical form.
+func signV4(req *http.Request, region, accessKey, secretKey, sessionToken string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	values := map[string]string{"host": host}
+	for name, v := range req.Header {
+		values[strings.ToLower(name)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, headers.String(), signed, req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key and value, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(key, true)+"="+s3Escape(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes every byte but the unreserved characters of
+// RFC 3986 and, unless encodeSlash is set, '/'.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package state_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+	"github.com/arpanpathak/kube-goAT/pkg/state/storetest"
+)
+
+func TestS3Store_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) state.Store {
+		_, store := newFakeS3(t, true)
+		return store
+	})
+}
+
+func TestS3Store_ListPaginatesAndSkipsLocks(t *testing.T) {
+	fake, store := newFakeS3(t, true)
+	ctx := context.Background()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if err := store.Save(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlock, err := store.Lock(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	fake.mu.Lock()
+	fake.objects["other/x"] = []*fakeVersion{{id: "v0", data: []byte("x")}}
+	fake.mu.Unlock()
+
+	keys, err := store.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+}
+
+func TestS3Store_DeleteKeepsHistoryHidden(t *testing.T) {
+	_, store := newFakeS3(t, true)
+	ctx := context.Background()
+	store.Save(ctx, "env", []byte("v1"))
+	if err := store.Delete(ctx, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, "env"); err == nil {
+		t.Error("expected Load of a deleted key to fail")
+	}
+	if _, err := store.History(ctx, "env"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("expected History of a deleted key to be not found, got %v", err)
+	}
+
+	store.Save(ctx, "env", []byte("v2"))
+	revisions, err := store.History(ctx, "env")
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("expected both saves in the history after re-creating the key, got %v (%v)", revisions, err)
+	}
+	if data, _ := store.LoadRevision(ctx, "env", revisions[1].ID); string(data) != "v1" {
+		t.Errorf("oldest revision = %q, want v1", data)
+	}
+}
+
+func TestS3Store_UnversionedHistory(t *testing.T) {
+	_, store := newFakeS3(t, false)
+	ctx := context.Background()
+	store.Save(ctx, "env", []byte("v1"))
+	store.Save(ctx, "env", []byte("v2"))
+	revisions, err := store.History(ctx, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].ID != "null" || revisions[0].Size != 2 {
+		t.Errorf("expected only the current object, got %+v", revisions)
+	}
+}
+
+func TestS3Store_ConditionalSave(t *testing.T) {
+	fake, ours := newFakeS3(t, true)
+	theirs := fake.newStore(t)
+	ctx := context.Background()
+
+	if err := ours.Save(ctx, "env", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Save(ctx, "env", []byte("blind")); !errors.Is(err, state.ErrConflict) {
+		t.Errorf("expected a save without loading the key first to conflict, got %v", err)
+	}
+	if _, err := theirs.Load(ctx, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := theirs.Save(ctx, "env", []byte("theirs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ours.Save(ctx, "env", []byte("ours")); !errors.Is(err, state.ErrConflict) {
+		t.Errorf("expected a save over a concurrent one to conflict, got %v", err)
+	}
+	if data, _ := ours.Load(ctx, "env"); string(data) != "theirs" {
+		t.Errorf("env = %q, want the concurrent payload kept", data)
+	}
+	if err := ours.Save(ctx, "env", []byte("ours")); err != nil {
+		t.Errorf("expected a save after loading the key again to succeed, got %v", err)
+	}
+
+	if err := theirs.Delete(ctx, "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ours.Save(ctx, "env", []byte("recreated")); !errors.Is(err, state.ErrConflict) {
+		t.Errorf("expected a save over a concurrent delete to conflict, got %v", err)
+	}
+	if err := theirs.Save(ctx, "env", []byte("recreated")); err != nil {
+		t.Errorf("expected the deleting store to recreate the key, got %v", err)
+	}
+}
+
+func TestS3Store_TakesOverExpiredLock(t *testing.T) {
+	fake, store := newFakeS3(t, true)
+	ctx := context.Background()
+	expired, _ := json.Marshal(map[string]any{"holder": "crashed", "expires": time.Now().Add(-time.Second)})
+	fake.mu.Lock()
+	fake.objects["stacks/.locks/env"] = []*fakeVersion{{id: "v0", data: expired, etag: `"stale"`}}
+	fake.mu.Unlock()
+
+	short, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	unlock, err := store.Lock(short, "env")
+	if err != nil {
+		t.Fatalf("expected an expired lock to be taken over, got %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, ".locks/env"); err == nil {
+		t.Error("expected unlock to remove the lock object")
+	}
+}
+
+func TestS3Store_Errors(t *testing.T) {
+	if _, err := state.NewS3Store(state.S3Config{}); err == nil {
+		t.Error("expected a bucket to be required")
+	}
+	fake, store := newFakeS3(t, true)
+	fake.mu.Lock()
+	fake.bucket = "other"
+	fake.mu.Unlock()
+	_, err := store.Load(context.Background(), "missing")
+	var s3Err *state.S3Error
+	if !errors.As(err, &s3Err) || s3Err.StatusCode != http.StatusNotFound || s3Err.Code != "NoSuchBucket" {
+		t.Errorf("expected a NoSuchBucket S3Error, got %v", err)
+	}
+	if errors.Is(err, state.ErrNotFound) {
+		t.Error("a missing bucket must not read as missing state")
+	}
+}
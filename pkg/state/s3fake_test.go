@@ -0,0 +1,273 @@
+package state_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+)
+
+// fakeS3 is an in-memory S3 bucket served over HTTP. It implements the
+// subset of the API S3Store uses: conditional PUT, GET and DELETE of
+// objects and their versions, ListObjectsV2 and ListObjectVersions.
+type fakeS3 struct {
+	url       string
+	bucket    string
+	versioned bool
+	// pageSize is the most keys returned by one ListObjectsV2 call.
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string][]*fakeVersion // newest last
+	nextID  int
+}
+
+type fakeVersion struct {
+	id       string
+	data     []byte
+	etag     string
+	modified time.Time
+	deleted  bool
+}
+
+func newFakeS3(t *testing.T, versioned bool) (*fakeS3, *state.S3Store) {
+	t.Helper()
+	fake := &fakeS3{bucket: "goat", versioned: versioned, pageSize: 2, objects: make(map[string][]*fakeVersion)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fake.url = server.URL
+	return fake, fake.newStore(t)
+}
+
+// newStore returns another client of the bucket, as used by a second
+// process.
+func (f *fakeS3) newStore(t *testing.T) *state.S3Store {
+	t.Helper()
+	store, err := state.NewS3Store(state.S3Config{
+		Endpoint:        f.url,
+		Region:          "eu-west-1",
+		Bucket:          "goat",
+		Prefix:          "stacks/",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := checkSigned(r, body); err != nil {
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+	query := r.URL.Query()
+	switch {
+	case key == "" && query.Has("versioning"):
+		f.versioning(w)
+	case key == "" && query.Has("versions"):
+		f.listVersions(w, query.Get("prefix"))
+	case key == "" && query.Get("list-type") == "2":
+		f.listObjects(w, query.Get("prefix"), query.Get("continuation-token"))
+	case r.Method == http.MethodPut:
+		f.put(w, r, key, body)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		v := f.version(key, query.Get("versionId"))
+		if v == nil || v.deleted {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Header().Set("ETag", v.etag)
+		w.Write(v.data)
+	case r.Method == http.MethodDelete:
+		if f.versioned {
+			f.add(key, &fakeVersion{deleted: true})
+		} else {
+			delete(f.objects, key)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// checkSigned checks the parts of a SigV4 request the server can verify
+// without the secret key.
+func checkSigned(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	date := r.Header.Get("X-Amz-Date")
+	if len(date) != len("20060102T150405Z") {
+		return fmt.Errorf("bad X-Amz-Date %q", date)
+	}
+	prefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + date[:8] + "/eu-west-1/s3/aws4_request, SignedHeaders="
+	if !strings.HasPrefix(auth, prefix) || !strings.Contains(auth, ", Signature=") {
+		return fmt.Errorf("bad Authorization %q", auth)
+	}
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("X-Amz-Content-Sha256 does not match the body")
+	}
+	return nil
+}
+
+func (f *fakeS3) put(w http.ResponseWriter, r *http.Request, key string, body []byte) {
+	current := f.version(key, "")
+	exists := current != nil && !current.deleted
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", key)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || current.etag != match) {
+		writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed", key)
+		return
+	}
+	sum := sha256.Sum256(body)
+	v := &fakeVersion{data: body, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	if f.versioned {
+		f.add(key, v)
+	} else {
+		v.id, v.modified = "null", time.Now().UTC()
+		f.objects[key] = []*fakeVersion{v}
+	}
+	w.Header().Set("ETag", v.etag)
+}
+
+func (f *fakeS3) add(key string, v *fakeVersion) {
+	f.nextID++
+	v.id = "v" + strconv.Itoa(f.nextID)
+	v.modified = time.Now().UTC()
+	f.objects[key] = append(f.objects[key], v)
+}
+
+// version returns the version of key with the given ID, or the latest
+// one.
+func (f *fakeS3) version(key, id string) *fakeVersion {
+	versions := f.objects[key]
+	if id == "" {
+		if len(versions) == 0 {
+			return nil
+		}
+		return versions[len(versions)-1]
+	}
+	for _, v := range versions {
+		if v.id == id {
+			return v
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) keys(prefix string) []string {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, prefix, token string) {
+	type object struct {
+		Key  string
+		Size int
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+	for _, key := range f.keys(prefix) {
+		v := f.version(key, "")
+		if v.deleted || key <= token {
+			continue
+		}
+		if len(result.Contents) == f.pageSize {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, object{Key: key, Size: len(v.data)})
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	writeXML(w, result)
+}
+
+func (f *fakeS3) versioning(w http.ResponseWriter) {
+	var result struct {
+		XMLName xml.Name `xml:"VersioningConfiguration"`
+		Status  string   `xml:",omitempty"`
+	}
+	if f.versioned {
+		result.Status = "Enabled"
+	}
+	out, _ := xml.Marshal(result)
+	w.Write(out)
+}
+
+func (f *fakeS3) listVersions(w http.ResponseWriter, prefix string) {
+	type entry struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified time.Time
+		Size         int `xml:",omitempty"`
+	}
+	var result struct {
+		XMLName       xml.Name `xml:"ListVersionsResult"`
+		Versions      []entry  `xml:"Version"`
+		DeleteMarkers []entry  `xml:"DeleteMarker"`
+	}
+	for _, key := range f.keys(prefix) {
+		versions := f.objects[key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			v := versions[i]
+			e := entry{Key: key, VersionId: v.id, IsLatest: i == len(versions)-1, LastModified: v.modified, Size: len(v.data)}
+			if v.deleted {
+				result.DeleteMarkers = append(result.DeleteMarkers, e)
+			} else {
+				result.Versions = append(result.Versions, e)
+			}
+		}
+	}
+	writeXML(w, result)
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: message})
+}
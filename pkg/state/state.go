@@ -1,10 +1,55 @@
 package state
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned, wrapped, by stores when nothing is recorded
+// under a key. Any other error means the state could not be read.
+var ErrNotFound = errors.New("state not found")
+
+// ErrConflict is returned, wrapped, by stores that save conditionally when
+// what is recorded under a key changed since this store last loaded or
+// saved it. Load the key again before retrying.
+var ErrConflict = errors.New("state changed since it was loaded")
 
 // Store defines an interface to save and load serialized infrastructure DAGs.
 // State representation is key for a scalable execution engine to diff resources.
+//
+// Load, Delete, and the History and LoadRevision methods of Versioned
+// stores, wrap ErrNotFound for keys with nothing recorded.
 type Store interface {
 	Save(ctx context.Context, key string, data []byte) error
 	Load(ctx context.Context, key string) ([]byte, error)
+	// Exists reports whether anything is recorded under key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// List returns the keys starting with prefix, sorted. An empty prefix
+	// lists every key.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Locker is implemented by stores that can hold an exclusive lock on a key
+// across processes. Lock blocks until the lock is held or ctx is done, and
+// returns the function releasing it.
+type Locker interface {
+	Lock(ctx context.Context, key string) (unlock func() error, err error)
+}
+
+// Versioned is implemented by stores that keep the earlier payloads of a
+// key.
+type Versioned interface {
+	// History returns the revisions of key, newest first. The first is
+	// the payload Load returns.
+	History(ctx context.Context, key string) ([]Revision, error)
+	LoadRevision(ctx context.Context, key, id string) ([]byte, error)
+}
+
+// Revision describes one saved payload of a key.
+type Revision struct {
+	ID    string
+	Saved time.Time
+	Size  int64
 }
@@ -0,0 +1,275 @@
+// Package storetest is a conformance suite for state.Store implementations.
+//
+// A store's tests run it with a constructor returning an empty store:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) state.Store {
+//			store, err := state.NewLocalStore(t.TempDir())
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return store
+//		})
+//	}
+//
+// Checks of the optional interfaces, state.Locker and state.Versioned, are
+// skipped for stores that do not implement them.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arpanpathak/kube-goAT/pkg/state"
+)
+
+// Run runs every conformance check against stores made by newStore, which
+// must return an empty store each time it is called.
+func Run(t *testing.T, newStore func(t *testing.T) state.Store) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, state.Store)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"NotFound", testNotFound},
+		{"Overwrite", testOverwrite},
+		{"ListDelete", testListDelete},
+		{"Concurrency", testConcurrency},
+		{"Locking", testLocking},
+		{"History", testHistory},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, newStore(t))
+		})
+	}
+}
+
+// payload returns size pseudo-random bytes, which neither compress nor
+// survive a text encoding.
+func payload(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func testRoundTrip(t *testing.T, store state.Store) {
+	ctx := context.Background()
+	for name, data := range map[string][]byte{
+		"small": []byte("payload"),
+		"empty": {},
+		"large": payload(1, 2<<20),
+	} {
+		if err := store.Save(ctx, name, data); err != nil {
+			t.Fatalf("Save %s: %v", name, err)
+		}
+		loaded, err := store.Load(ctx, name)
+		if err != nil {
+			t.Fatalf("Load %s: %v", name, err)
+		}
+		if !bytes.Equal(loaded, data) {
+			t.Errorf("Load %s returned %d bytes, saved %d", name, len(loaded), len(data))
+		}
+	}
+}
+
+func testNotFound(t *testing.T, store state.Store) {
+	ctx := context.Background()
+	if _, err := store.Load(ctx, "missing"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Load of a missing key = %v, want state.ErrNotFound", err)
+	}
+	store.Save(ctx, "present", []byte("x"))
+	if _, err := store.Load(ctx, "missing"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Load of a missing key next to a present one = %v, want state.ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "missing"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Delete of a missing key = %v, want state.ErrNotFound", err)
+	}
+	for key, want := range map[string]bool{"present": true, "missing": false} {
+		if exists, err := store.Exists(ctx, key); err != nil || exists != want {
+			t.Errorf("Exists(%s) = %v, %v, want %v", key, exists, err, want)
+		}
+	}
+}
+
+func testOverwrite(t *testing.T, store state.Store) {
+	ctx := context.Background()
+	for i, data := range [][]byte{payload(1, 4<<20), []byte("short"), payload(2, 100)} {
+		if err := store.Save(ctx, "key", data); err != nil {
+			t.Fatalf("Save %d: %v", i, err)
+		}
+		loaded, err := store.Load(ctx, "key")
+		if err != nil || !bytes.Equal(loaded, data) {
+			t.Fatalf("Load after save %d returned %d bytes (%v), saved %d", i, len(loaded), err, len(data))
+		}
+	}
+}
+
+func testListDelete(t *testing.T, store state.Store) {
+	ctx := context.Background()
+	for _, key := range []string{"b", "a", "c", "prod-web", "prod-api"} {
+		if err := store.Save(ctx, key, payload(3, 3<<20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(prefix string) []string {
+		keys, err := store.List(ctx, prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		return keys
+	}
+	if got, want := list(""), []string{"a", "b", "c", "prod-api", "prod-web"}; !slices.Equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+	if got, want := list("prod-"), []string{"prod-api", "prod-web"}; !slices.Equal(got, want) {
+		t.Errorf("List(prod-) = %v, want %v", got, want)
+	}
+	if got := list("none"); len(got) != 0 {
+		t.Errorf("List(none) = %v, want nothing", got)
+	}
+
+	if err := store.Delete(ctx, "b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Load(ctx, "b"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("Load of a deleted key = %v, want state.ErrNotFound", err)
+	}
+	if exists, err := store.Exists(ctx, "b"); err != nil || exists {
+		t.Errorf("Exists of a deleted key = %v, %v", exists, err)
+	}
+	if _, err := store.Load(ctx, "a"); err != nil {
+		t.Errorf("Delete removed another key: %v", err)
+	}
+	if got, want := list(""), []string{"a", "c", "prod-api", "prod-web"}; !slices.Equal(got, want) {
+		t.Errorf("List after Delete = %v, want %v", got, want)
+	}
+}
+
+// testConcurrency races saves of one key. Stores saving conditionally may
+// refuse all but one of them with state.ErrConflict.
+func testConcurrency(t *testing.T, store state.Store) {
+	ctx := context.Background()
+	const writers = 8
+	saved := make([][]byte, writers)
+	var succeeded atomic.Int32
+	var wg sync.WaitGroup
+	for i := range saved {
+		saved[i] = payload(int64(i), 64<<10)
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			switch err := store.Save(ctx, "key", data); {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, state.ErrConflict):
+				t.Errorf("concurrent Save: %v", err)
+			}
+			if err := store.Save(ctx, fmt.Sprintf("own-%d", i), data); err != nil {
+				t.Errorf("concurrent Save of another key: %v", err)
+			}
+		}(saved[i])
+	}
+	wg.Wait()
+	if succeeded.Load() == 0 {
+		t.Fatal("every concurrent Save failed")
+	}
+
+	loaded, err := store.Load(ctx, "key")
+	if err != nil {
+		t.Fatalf("Load after concurrent saves: %v", err)
+	}
+	if !slices.ContainsFunc(saved, func(data []byte) bool { return bytes.Equal(data, loaded) }) {
+		t.Error("concurrent saves left a payload none of them wrote")
+	}
+	for i, data := range saved {
+		if loaded, err := store.Load(ctx, fmt.Sprintf("own-%d", i)); err != nil || !bytes.Equal(loaded, data) {
+			t.Errorf("own-%d: concurrent saves of other keys interfered: %v", i, err)
+		}
+	}
+}
+
+func testLocking(t *testing.T, store state.Store) {
+	locker, ok := store.(state.Locker)
+	if !ok {
+		t.Skip("store does not implement state.Locker")
+	}
+	ctx := context.Background()
+	unlock, err := locker.Lock(ctx, "key")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := locker.Lock(short, "key"); err == nil {
+		t.Fatal("expected a held lock to block until the context expired")
+	}
+	other, err := locker.Lock(ctx, "other")
+	if err != nil {
+		t.Fatalf("expected locks on other keys to be independent: %v", err)
+	}
+	other()
+
+	acquired := make(chan error, 1)
+	go func() {
+		unlock, err := locker.Lock(ctx, "key")
+		if err == nil {
+			err = unlock()
+		}
+		acquired <- err
+	}()
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("Lock after unlock: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("a released lock was not acquired by its waiter")
+	}
+}
+
+func testHistory(t *testing.T, store state.Store) {
+	versioned, ok := store.(state.Versioned)
+	if !ok {
+		t.Skip("store does not implement state.Versioned")
+	}
+	ctx := context.Background()
+	saved := [][]byte{[]byte("v1"), []byte("v2"), payload(4, 1<<20)}
+	for _, data := range saved {
+		if err := store.Save(ctx, "key", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revisions, err := versioned.History(ctx, "key")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(revisions) < len(saved) {
+		t.Fatalf("expected at least %d revisions, got %d", len(saved), len(revisions))
+	}
+	for i, rev := range revisions[:len(saved)] {
+		want := saved[len(saved)-1-i]
+		data, err := versioned.LoadRevision(ctx, "key", rev.ID)
+		if err != nil || !bytes.Equal(data, want) {
+			t.Errorf("revision %d (%s) returned %d bytes (%v), want %d", i, rev.ID, len(data), err, len(want))
+		}
+		if rev.Size != int64(len(want)) {
+			t.Errorf("revision %d: Size = %d, want %d", i, rev.Size, len(want))
+		}
+	}
+	if _, err := versioned.History(ctx, "missing"); !errors.Is(err, state.ErrNotFound) {
+		t.Errorf("History of a missing key = %v, want state.ErrNotFound", err)
+	}
+}